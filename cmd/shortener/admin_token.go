@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bissquit/url-shortener/internal/auth"
+	"github.com/bissquit/url-shortener/internal/config"
+)
+
+// runAdminToken prints admin token to be used in "Authorization: Bearer" header.
+// Secret is loaded like server does (config file, JWT_SECRET env), so the token is accepted by server
+func runAdminToken(args []string) error {
+	fs := flag.NewFlagSet("admin-token", flag.ContinueOnError)
+	userID := fs.String("user", "", "admin user id")
+	ttl := fs.Duration("ttl", 24*time.Hour, "token lifetime")
+	cfg, err := config.Load(fs, args, os.Getenv)
+	if err != nil {
+		return err
+	}
+	if *userID == "" {
+		return fmt.Errorf("-user is required")
+	}
+	// random key of this process would make token useless
+	if cfg.JWTSecret == "" {
+		return errors.New("JWT secret is not set, set JWT_SECRET env or jwt_secret in config file")
+	}
+	if err = config.ValidateJWTSecret(cfg.JWTSecret); err != nil {
+		return err
+	}
+	auth.SetSecretKey([]byte(cfg.JWTSecret))
+
+	token, err := auth.BuildToken(*userID, auth.RoleAdmin, *ttl)
+	if err != nil {
+		return err
+	}
+	fmt.Println(token)
+	return nil
+}
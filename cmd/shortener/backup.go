@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"github.com/bissquit/url-shortener/internal/service/transfer"
+	"github.com/bissquit/url-shortener/migrations"
+)
+
+// runBackup writes archive of Postgres or file storage, server may keep working
+func runBackup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	dsn, filePath := storageFlags(fs)
+	output := fs.String("out", "-", "archive path, \"-\" writes to stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dsn == "" && *filePath == "" {
+		return errors.New("-d or -f is required")
+	}
+
+	stg, _, closeStorage, err := openStorage(*dsn, *filePath)
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+
+	version, err := migrations.SchemaVersion(*dsn)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	n, err := transfer.Backup(context.Background(), stg, w, version)
+	if err != nil {
+		return err
+	}
+	log.Printf("%d links are saved, schema version %d", n, version)
+	return nil
+}
+
+// runRestore puts archive into empty Postgres or file storage
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	dsn, filePath := storageFlags(fs)
+	input := fs.String("in", "-", "archive path, \"-\" reads stdin")
+	chunkSize := fs.Int("chunk", transfer.DefaultChunkSize, "links per batch")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dsn == "" && *filePath == "" {
+		return errors.New("-d or -f is required, use POST /api/admin/restore for in-memory storage")
+	}
+
+	var r io.Reader = os.Stdin
+	if *input != "-" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	stg, _, closeStorage, err := openStorage(*dsn, *filePath)
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+
+	version, err := migrations.SchemaVersion(*dsn)
+	if err != nil {
+		return err
+	}
+
+	n, err := transfer.Restore(context.Background(), stg, r, version, *chunkSize)
+	if err != nil {
+		return err
+	}
+	log.Printf("%d links are restored", n)
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bissquit/url-shortener/internal/service/transfer"
+	"github.com/bissquit/url-shortener/internal/service/urlcheck"
+)
+
+// runImport stores links from CSV or NDJSON file into configured storage and prints result as JSON
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	dsn, filePath := storageFlags(fs)
+	input := fs.String("input", "-", "CSV or NDJSON file, \"-\" reads stdin")
+	format := fs.String("format", "", "csv or ndjson (default by input extension)")
+	dryRun := fs.Bool("dry-run", false, "validate rows and check conflicts without storing")
+	userID := fs.String("user", "", "owner of rows without user_id")
+	chunkSize := fs.Int("chunk", transfer.DefaultChunkSize, "links per batch")
+	baseURL := fs.String("b", "http://localhost:8080", "base URL, links to it are rejected")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *dsn == "" && *filePath == "" {
+		return fmt.Errorf("-d or -f is required, in-memory storage is lost on exit")
+	}
+	if *format == "" {
+		*format = strings.TrimPrefix(filepath.Ext(*input), ".")
+	}
+
+	var r io.Reader = os.Stdin
+	if *input != "-" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	stg, _, closeStorage, err := openStorage(*dsn, *filePath)
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+
+	result, err := transfer.Import(context.Background(), stg, r, transfer.ImportOptions{
+		Format:        *format,
+		DryRun:        *dryRun,
+		DefaultUserID: *userID,
+		ChunkSize:     *chunkSize,
+		Checker:       urlcheck.New(*baseURL, urlcheck.DefaultSchemes, urlcheck.DefaultMaxLength),
+	})
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if encErr := enc.Encode(result); encErr != nil {
+		return encErr
+	}
+	return err
+}
@@ -2,91 +2,276 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"expvar"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/bissquit/url-shortener/internal/auth"
 	"github.com/bissquit/url-shortener/internal/config"
-	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/bissquit/url-shortener/internal/geoip"
+	"github.com/bissquit/url-shortener/internal/repository/cache"
 	"github.com/bissquit/url-shortener/internal/repository/db"
 	"github.com/bissquit/url-shortener/internal/repository/disk"
-	"github.com/bissquit/url-shortener/internal/repository/memory"
 	"github.com/bissquit/url-shortener/internal/server"
 	"github.com/bissquit/url-shortener/internal/service/crypto"
-	"github.com/bissquit/url-shortener/migrations"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/bissquit/url-shortener/internal/service/urlcheck"
+	"github.com/bissquit/url-shortener/internal/tlscert"
+	"golang.org/x/net/netutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
+// subcommands are run instead of server when the first argument matches
+var subcommands = map[string]func(args []string) error{
+	"admin-token":     runAdminToken,
+	"backup":          runBackup,
+	"restore":         runRestore,
+	"import":          runImport,
+	"migrate-storage": runMigrateStorage,
+}
+
 func main() {
+	// subcommands
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
 	// prepare config
 	cfg := config.GetConfig()
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.JWTSecret != "" {
+		auth.SetSecretKey([]byte(cfg.JWTSecret))
+	} else {
+		log.Println("WARN: JWT secret is not set, tokens are signed by a random key and don't survive restart")
+	}
 
 	// initialize storage
-	var (
-		stg  repository.URLRepository
-		pool *pgxpool.Pool
-	)
-	if cfg.DSN != "" {
-		pool, err := pgxpool.New(context.Background(), cfg.DSN)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer pool.Close()
+	stg, pool, closeStorage, err := openStorage(cfg.DSN, cfg.FileStoragePath, cfg.Replicas()...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// storage is closed by shutdown after all requests and async work are finished
 
-		// apply migrations
-		err = migrations.InitializeDB(cfg.DSN)
-		if err != nil {
-			log.Fatal(err)
-		}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		// initialize db if DSN is set
-		stg = db.NewDBStorage(pool)
-	} else if cfg.FileStoragePath != "" {
-		var err error
-		// initialize file storage if path is set
-		stg, err = disk.NewFileStorage(cfg.FileStoragePath)
-		if err != nil {
-			log.Fatal(err)
+	var opts []server.Option
+	pgStg, isPG := stg.(*db.PGStorage)
+	if isPG {
+		// unhealthy replicas are returned to rotation by monitor
+		go pgStg.MonitorReplicas(ctx, 5*time.Second)
+		opts = append(opts, server.WithPoolsHealth(pgStg.CheckPools))
+	}
+	if fileStg, ok := stg.(*disk.FileStorage); ok {
+		// clicks aren't saved on every redirect
+		go fileStg.FlushClicks(ctx, 5*time.Second)
+	}
+
+	// cache links for redirects, metrics are available at /api/admin/debug/vars
+	if cfg.CacheSize > 0 {
+		linkCache := cache.New(stg, cfg.CacheSize, cfg.CacheTTL, cfg.CacheNegativeTTL)
+		expvar.Publish("link_cache", expvar.Func(func() any { return linkCache.Stats() }))
+		// instances notify each other about changes through Postgres
+		if isPG {
+			pgStg.NotifyChanges()
+			go pgStg.ListenChanges(ctx, linkCache)
 		}
-	} else {
-		// initialize in-memory storage by default if nothing is set
-		stg = memory.NewURLStorage()
+		stg = linkCache
 	}
 
 	// prepare id generator
 	gen := crypto.NewRandomGenerator()
 
+	// prepare destination URL checker, blocklist is reloaded on file change.
+	// Limits, blocklist and log level are reloaded from config on SIGHUP
+	checker := urlcheck.New(cfg.BaseURL, cfg.AllowedSchemes, cfg.MaxURLLength)
+	reload, err := newReloader(ctx, cfg, checker)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go reload.watch()
+	opts = append(opts, server.WithURLChecker(checker))
+
+	// load GeoIP database if it's set
+	if cfg.GeoIPPath != "" {
+		geoDB, err := geoip.Open(cfg.GeoIPPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, server.WithGeoIP(geoDB))
+	}
+
 	// prepare server
-	srv := server.NewServer(cfg, stg, gen)
+	srv := server.NewServer(cfg, stg, gen, opts...)
 	// apply pool if DSN is set, or apply nil (default )
 	srv.DB = pool
 
-	httpSrv := &http.Server{
-		Addr:    cfg.ServerAddr,
-		Handler: srv.Handler(),
+	httpSrv := newHTTPServer(cfg, cfg.ServerAddr, srv.Handler())
+	servers := []*http.Server{httpSrv}
+
+	ln, err := listen(cfg.ServerAddr, cfg.MaxConnections)
+	if err != nil {
+		log.Fatal(err)
 	}
-	log.Println("server is listening on " + cfg.ServerAddr)
+	serve := func() error { return httpSrv.Serve(ln) }
+	var tlsConfig *tls.Config
+	if cfg.EnableHTTPS {
+		tlsConfig, err = newTLSConfig(ctx, cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		httpSrv.TLSConfig = tlsConfig
+		// certificate is taken from TLSConfig.GetCertificate
+		serve = func() error { return httpSrv.ServeTLS(ln, "", "") }
 
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
-	defer stop()
+		if cfg.HTTPRedirectAddr != "" {
+			redirectSrv := newHTTPServer(cfg, cfg.HTTPRedirectAddr, server.RedirectToHTTPS(cfg.ServerAddr))
+			redirectLn, err := listen(cfg.HTTPRedirectAddr, cfg.MaxConnections)
+			if err != nil {
+				log.Fatal(err)
+			}
+			servers = append(servers, redirectSrv)
+			log.Println("redirecting to HTTPS from " + cfg.HTTPRedirectAddr)
+			go func() {
+				if err := redirectSrv.Serve(redirectLn); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					log.Printf("http redirect server error: %v", err)
+					stop()
+				}
+			}()
+		}
+	}
+	log.Println("server is listening on " + cfg.ServerAddr)
 
 	go func() {
 		// log and stop main if server is stopping not by Shutdown/Close
-		if err := httpSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		if err := serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Printf("http server error: %v", err)
 			stop()
 		}
 	}()
 
+	var grpcSrv *grpc.Server
+	if cfg.GRPCAddr != "" {
+		grpcOpts := []grpc.ServerOption{grpc.MaxRecvMsgSize(cfg.GRPCMaxRecvMsgSize)}
+		if tlsConfig != nil {
+			grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		grpcSrv = srv.GRPCServer(cfg.GRPCCallTimeout, grpcOpts...)
+		grpcLn, err := listen(cfg.GRPCAddr, cfg.MaxConnections)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("gRPC server is listening on " + cfg.GRPCAddr)
+		go func() {
+			if err := grpcSrv.Serve(grpcLn); err != nil {
+				log.Printf("grpc server error: %v", err)
+				stop()
+			}
+		}()
+	}
+
 	<-ctx.Done()
+	shutdown(cfg.ShutdownTimeout, servers, grpcSrv, srv, closeStorage)
+}
 
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// shutdown stops in order: listeners and running handlers, then async work of handlers,
+// then storage (file storage saves unsaved changes, database pools are closed).
+// timeout is shared by all steps, storage is closed even if it's exceeded
+func shutdown(timeout time.Duration, servers []*http.Server, grpcSrv *grpc.Server, srv *server.Server, closeStorage func()) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	// Shutdown forces ListenAndServe to return ErrServerClosed
-	_ = httpSrv.Shutdown(shutdownCtx)
+	log.Println("shutting down")
+	// Shutdown stops accepting connections and waits for active requests,
+	// it forces Serve to return ErrServerClosed
+	for _, s := range servers {
+		if err := s.Shutdown(ctx); err != nil {
+			log.Printf("http server %s is not shut down gracefully: %v", s.Addr, err)
+		}
+	}
+	if grpcSrv != nil {
+		if err := stopGRPC(ctx, grpcSrv); err != nil {
+			log.Printf("grpc server is not shut down gracefully: %v", err)
+		}
+	}
+	if err := srv.Drain(ctx); err != nil {
+		log.Printf("async work is not finished: %v", err)
+	}
+	closeStorage()
+	log.Println("server is stopped")
+}
+
+// stopGRPC waits for running calls like http.Server.Shutdown, they are cancelled when ctx is done
+func stopGRPC(ctx context.Context, s *grpc.Server) error {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.Stop()
+		return ctx.Err()
+	}
+}
+
+// newHTTPServer applies timeouts and limits from config
+func newHTTPServer(cfg *config.Config, addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+}
+
+// listen limits number of accepted connections, new ones wait in backlog until others are closed
+func listen(addr string, maxConnections int) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if maxConnections > 0 {
+		ln = netutil.LimitListener(ln, maxConnections)
+	}
+	return ln, nil
+}
+
+// newTLSConfig serves certificate which is reloaded when its files change
+func newTLSConfig(ctx context.Context, cfg *config.Config) (*tls.Config, error) {
+	minVersion, err := cfg.TLSVersion()
+	if err != nil {
+		return nil, err
+	}
+	certs, err := tlscert.New(cfg.TLSCertPath, cfg.TLSKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load TLS certificate: %w", err)
+	}
+	go certs.Watch(ctx, 10*time.Second)
+
+	return &tls.Config{
+		MinVersion:     minVersion,
+		GetCertificate: certs.GetCertificate,
+	}, nil
 }
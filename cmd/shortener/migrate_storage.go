@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/bissquit/url-shortener/internal/service/transfer"
+)
+
+// runMigrateStorage copies all links between storages, e.g. from file to Postgres.
+// Server must be stopped while it's running
+func runMigrateStorage(args []string) error {
+	fs := flag.NewFlagSet("migrate-storage", flag.ContinueOnError)
+	fromDSN := fs.String("from-d", "", "source Database DSN")
+	fromFile := fs.String("from-f", "", "source file storage path")
+	toDSN := fs.String("to-d", "", "destination Database DSN")
+	toFile := fs.String("to-f", "", "destination file storage path")
+	chunkSize := fs.Int("chunk", transfer.DefaultChunkSize, "links per batch")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// one of each pair is required
+	if (*fromDSN == "") == (*fromFile == "") {
+		return errors.New("exactly one of -from-d and -from-f is required")
+	}
+	if (*toDSN == "") == (*toFile == "") {
+		return errors.New("exactly one of -to-d and -to-f is required")
+	}
+	if *fromDSN == *toDSN && *fromFile == *toFile {
+		return errors.New("source and destination are the same")
+	}
+
+	from, _, closeFrom, err := openStorage(*fromDSN, *fromFile)
+	if err != nil {
+		return err
+	}
+	defer closeFrom()
+	to, _, closeTo, err := openStorage(*toDSN, *toFile)
+	if err != nil {
+		return err
+	}
+	defer closeTo()
+
+	result, err := transfer.Migrate(context.Background(), from, to, *chunkSize)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if encErr := enc.Encode(result); encErr != nil {
+		return encErr
+	}
+	return err
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bissquit/url-shortener/internal/config"
+	"github.com/bissquit/url-shortener/internal/logging"
+	"github.com/bissquit/url-shortener/internal/service/urlcheck"
+)
+
+// blocklistWatchInterval is how often blocklist file is checked for changes
+const blocklistWatchInterval = 10 * time.Second
+
+// reloader applies settings which may be changed without restart
+type reloader struct {
+	ctx     context.Context
+	cfg     *config.Config
+	checker *urlcheck.Checker
+	// stopWatch stops watching of the current blocklist file
+	stopWatch context.CancelFunc
+	hup       chan os.Signal
+}
+
+func newReloader(ctx context.Context, cfg *config.Config, checker *urlcheck.Checker) (*reloader, error) {
+	r := &reloader{ctx: ctx, cfg: cfg, checker: checker, stopWatch: func() {}, hup: make(chan os.Signal, 1)}
+	if err := logging.SetLevel(cfg.LogLevel); err != nil {
+		return nil, err
+	}
+	if err := r.setBlocklist(cfg.BlocklistPath); err != nil {
+		return nil, err
+	}
+	// SIGHUP terminates process by default, so it's caught before watch is started
+	signal.Notify(r.hup, syscall.SIGHUP)
+	return r, nil
+}
+
+// setBlocklist loads blocklist from path and watches it for changes, empty path clears blocklist
+func (r *reloader) setBlocklist(path string) error {
+	if path == "" {
+		r.stopWatch()
+		r.checker.ClearBlocklist()
+		return nil
+	}
+	if err := r.checker.LoadBlocklist(path); err != nil {
+		return err
+	}
+	r.stopWatch()
+	var watchCtx context.Context
+	watchCtx, r.stopWatch = context.WithCancel(r.ctx)
+	go r.checker.WatchBlocklist(watchCtx, path, blocklistWatchInterval)
+	return nil
+}
+
+// apply switches to next config, invalid one is rejected as a whole
+func (r *reloader) apply(next *config.Config) {
+	if err := next.Validate(); err != nil {
+		log.Printf("config is not reloaded: %v", err)
+		return
+	}
+	if changed := r.cfg.RestartRequired(next); len(changed) > 0 {
+		log.Printf("config reload: restart is required to apply %s", strings.Join(changed, ", "))
+	}
+
+	if err := r.setBlocklist(next.BlocklistPath); err != nil {
+		log.Printf("config is not reloaded: blocklist: %v", err)
+		return
+	}
+	r.checker.SetLimits(next.AllowedSchemes, next.MaxURLLength)
+	// level is validated above
+	_ = logging.SetLevel(next.LogLevel)
+
+	r.cfg.BlocklistPath = next.BlocklistPath
+	r.cfg.AllowedSchemes = next.AllowedSchemes
+	r.cfg.MaxURLLength = next.MaxURLLength
+	r.cfg.LogLevel = next.LogLevel
+	log.Println("config is reloaded")
+}
+
+// watch reloads config from the same sources on SIGHUP until ctx is done
+func (r *reloader) watch() {
+	defer signal.Stop(r.hup)
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-r.hup:
+		}
+
+		next, err := config.Load(flag.NewFlagSet(os.Args[0], flag.ContinueOnError), os.Args[1:], os.Getenv)
+		if err != nil {
+			log.Printf("config is not reloaded: %v", err)
+			continue
+		}
+		r.apply(next)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/bissquit/url-shortener/internal/repository/db"
+	"github.com/bissquit/url-shortener/internal/repository/disk"
+	"github.com/bissquit/url-shortener/internal/repository/memory"
+	"github.com/bissquit/url-shortener/migrations"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// openStorage chooses storage the same way for server and subcommands:
+// Postgres if dsn is set, file if filePath is set, in-memory otherwise.
+// Postgres reads may be spread over replicaDSNs.
+// closeFn must be called to release pools or save unsaved file changes
+func openStorage(dsn, filePath string, replicaDSNs ...string) (stg repository.URLRepository, pool *pgxpool.Pool, closeFn func(), err error) {
+	if dsn != "" {
+		pool, err = pgxpool.New(context.Background(), dsn)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		pools := []*pgxpool.Pool{pool}
+		closePools := func() {
+			for _, p := range pools {
+				p.Close()
+			}
+		}
+
+		// apply migrations, replicas get them by replication
+		if err = migrations.InitializeDB(dsn); err != nil {
+			closePools()
+			return nil, nil, nil, err
+		}
+
+		// pool is created lazily, so unavailable replica doesn't prevent start
+		for _, replicaDSN := range replicaDSNs {
+			replica, err := pgxpool.New(context.Background(), replicaDSN)
+			if err != nil {
+				closePools()
+				return nil, nil, nil, err
+			}
+			pools = append(pools, replica)
+		}
+
+		return db.NewDBStorage(pool, pools[1:]...), pool, closePools, nil
+	}
+
+	if filePath != "" {
+		fileStg, err := disk.NewFileStorage(filePath)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		// save data which is not saved on every change
+		return fileStg, nil, func() {
+			if err := fileStg.Close(); err != nil {
+				log.Printf("cannot save file storage: %v", err)
+			}
+		}, nil
+	}
+
+	return memory.NewURLStorage(), nil, func() {}, nil
+}
+
+// storageFlags adds server storage flags to subcommand, defaults are taken from env
+func storageFlags(fs *flag.FlagSet) (dsn, filePath *string) {
+	dsn = fs.String("d", os.Getenv("DATABASE_DSN"), "Database DSN")
+	filePath = fs.String("f", os.Getenv("FILE_STORAGE_PATH"), "file storage path")
+	return dsn, filePath
+}
@@ -2,7 +2,10 @@ package auth
 
 import (
 	"context"
+	"crypto/rand"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -12,34 +15,103 @@ import (
 type Claims struct {
 	jwt.RegisteredClaims
 	UserID string `json:"user_id"`
+	// Role is empty for regular users
+	Role string `json:"role,omitempty"`
 }
 
 type contextKey string
 
-const UserIDKey contextKey = "user_id"
+const (
+	UserIDKey contextKey = "user_id"
+	RoleKey   contextKey = "role"
+	// newUserKey marks user issued by the current request
+	newUserKey contextKey = "new_user"
+)
+
+// RoleAdmin grants access to /api/admin
+const RoleAdmin = "admin"
 
 var tokenExp = time.Hour * 24
-var secretKey = []byte("my-secret-key-change-in-production")
+
+// secretKey is random until SetSecretKey is called, so tokens can't be forged
+// but they don't survive restart
+var secretKey = randomKey()
+
+func randomKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("cannot generate JWT secret: %v", err))
+	}
+	return key
+}
+
+// SetSecretKey replaces key of issued and accepted tokens, it must be called before serving
+func SetSecretKey(key []byte) {
+	secretKey = key
+}
+
+// BuildToken signs token for userID with role, ttl <= 0 means default expiration
+func BuildToken(userID, role string, ttl time.Duration) (string, error) {
+	if ttl <= 0 {
+		ttl = tokenExp
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+		UserID: userID,
+		Role:   role,
+	})
+	return token.SignedString(secretKey)
+}
+
+// tokenFromRequest takes token from auth_token cookie or from "Authorization: Bearer" header
+func tokenFromRequest(r *http.Request) (string, bool) {
+	if cookie, err := r.Cookie("auth_token"); err == nil {
+		return cookie.Value, true
+	}
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return bearer, true
+	}
+	return "", false
+}
+
+// ParseToken returns claims of a valid token signed by BuildToken
+func ParseToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return secretKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	return claims, nil
+}
+
+// WithUser puts user into context the same way as JWTAuth does
+func WithUser(ctx context.Context, userID, role string) context.Context {
+	ctx = context.WithValue(ctx, UserIDKey, userID)
+	return context.WithValue(ctx, RoleKey, role)
+}
 
 func JWTAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("auth_token")
-		var userID string
+		tokenString, hasToken := tokenFromRequest(r)
+		var userID, role string
 		var hasValidCookie bool
 
-		if err == nil {
-			token, err := jwt.ParseWithClaims(cookie.Value, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-				if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-					return nil, jwt.ErrSignatureInvalid
-				}
-				return secretKey, nil
-			})
-
-			if err == nil && token.Valid {
+		if hasToken {
+			if claims, err := ParseToken(tokenString); err == nil {
 				hasValidCookie = true
-				if claims, ok := token.Claims.(*Claims); ok {
-					userID = claims.UserID
-				}
+				userID = claims.UserID
+				role = claims.Role
 			}
 		}
 
@@ -52,15 +124,7 @@ func JWTAuth(next http.Handler) http.Handler {
 			}
 			userID = uuid.New().String()
 
-			token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
-				RegisteredClaims: jwt.RegisteredClaims{
-					ExpiresAt: jwt.NewNumericDate(time.Now().Add(tokenExp)),
-					IssuedAt:  jwt.NewNumericDate(time.Now()),
-				},
-				UserID: userID,
-			})
-
-			tokenString, err := token.SignedString(secretKey)
+			tokenString, err := BuildToken(userID, "", tokenExp)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 			}
@@ -71,12 +135,24 @@ func JWTAuth(next http.Handler) http.Handler {
 				Path:     "/",
 				Expires:  time.Now().Add(tokenExp),
 				HttpOnly: true,
-				Secure:   false,
+				// cookie must not leak to plain HTTP when it's issued over HTTPS
+				Secure: r.TLS != nil,
 			})
+			r = r.WithContext(context.WithValue(r.Context(), newUserKey, true))
 		}
 
-		ctx := context.WithValue(r.Context(), UserIDKey, userID)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), userID, role)))
+	})
+}
+
+// RequireAdmin should be used after JWTAuth
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if role, _ := GetRoleFromContext(r.Context()); role != RoleAdmin {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
 	})
 }
 
@@ -84,3 +160,15 @@ func GetUserIDFromContext(ctx context.Context) (string, bool) {
 	userID, ok := ctx.Value(UserIDKey).(string)
 	return userID, ok
 }
+
+// IsNewUser reports whether user has been issued by the current request,
+// so it's not stable across requests of the same client
+func IsNewUser(ctx context.Context) bool {
+	isNew, _ := ctx.Value(newUserKey).(bool)
+	return isNew
+}
+
+func GetRoleFromContext(ctx context.Context) (string, bool) {
+	role, ok := ctx.Value(RoleKey).(string)
+	return role, ok
+}
@@ -0,0 +1,72 @@
+// Package clientip finds address of a client behind reverse proxies
+package clientip
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Resolver honors X-Real-IP and X-Forwarded-For only when request comes from a trusted proxy,
+// otherwise any client could spoof them. Nil Resolver trusts nobody
+type Resolver struct {
+	proxies []netip.Prefix
+}
+
+func New(proxies []netip.Prefix) *Resolver {
+	return &Resolver{proxies: proxies}
+}
+
+// ClientIP returns remote address of untrusted peer. Behind trusted proxy it takes X-Real-IP,
+// then the last X-Forwarded-For hop which is not a trusted proxy itself
+func (r *Resolver) ClientIP(req *http.Request) (netip.Addr, bool) {
+	remote, ok := parse(remoteHost(req.RemoteAddr))
+	if !ok || !r.trusted(remote) {
+		return remote, ok
+	}
+
+	if ip, ok := parse(req.Header.Get("X-Real-IP")); ok {
+		return ip, true
+	}
+	// every proxy appends address of its peer, so the chain is checked from the end
+	hops := strings.Split(strings.Join(req.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, ok := parse(hops[i])
+		if !ok {
+			// the rest of chain can't be trusted
+			break
+		}
+		if !r.trusted(ip) || i == 0 {
+			return ip, true
+		}
+	}
+	return remote, true
+}
+
+func (r *Resolver) trusted(ip netip.Addr) bool {
+	if r == nil {
+		return false
+	}
+	for _, p := range r.proxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteHost(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
+
+func parse(addr string) (netip.Addr, bool) {
+	ip, err := netip.ParseAddr(strings.TrimSpace(addr))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap(), true
+}
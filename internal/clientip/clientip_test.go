@@ -0,0 +1,80 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ResolverClientIP(t *testing.T) {
+	proxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	tests := []struct {
+		name       string
+		resolver   *Resolver
+		remoteAddr string
+		realIP     string
+		forwarded  string
+		want       string
+	}{
+		{
+			name:       "untrusted peer spoofs headers",
+			resolver:   New(proxies),
+			remoteAddr: "203.0.113.5:1234",
+			realIP:     "10.0.0.7",
+			forwarded:  "10.0.0.7",
+			want:       "203.0.113.5",
+		},
+		{
+			name:       "nil resolver trusts nobody",
+			remoteAddr: "10.0.0.1:1234",
+			realIP:     "192.168.1.1",
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "X-Real-IP from trusted proxy",
+			resolver:   New(proxies),
+			remoteAddr: "10.0.0.1:1234",
+			realIP:     "192.168.1.1",
+			want:       "192.168.1.1",
+		},
+		{
+			name:       "X-Forwarded-For skips trusted hops",
+			resolver:   New(proxies),
+			remoteAddr: "10.0.0.1:1234",
+			forwarded:  "1.1.1.1, 198.51.100.2, 10.0.0.9",
+			want:       "198.51.100.2",
+		},
+		{
+			name:       "X-Forwarded-For with invalid hop",
+			resolver:   New(proxies),
+			remoteAddr: "10.0.0.1:1234",
+			forwarded:  "198.51.100.2, unknown",
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "trusted proxy without headers",
+			resolver:   New(proxies),
+			remoteAddr: "[::ffff:10.0.0.1]:1234",
+			want:       "10.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			ip, ok := tt.resolver.ClientIP(r)
+			assert.True(t, ok)
+			assert.Equal(t, tt.want, ip.String())
+		})
+	}
+}
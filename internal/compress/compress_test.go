@@ -0,0 +1,334 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var payload = strings.Repeat(`{"url":"https://example.com/some/long/path"}`, 100)
+
+func encode(t *testing.T, coding string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	enc := getEncoder(coding, &buf)
+	_, err := enc.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, enc.Close())
+	putEncoder(coding, enc)
+	return buf.Bytes()
+}
+
+func decode(t *testing.T, coding string, data []byte) []byte {
+	t.Helper()
+	var r io.Reader
+	switch coding {
+	case encodingGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		require.NoError(t, err)
+		r = zr
+	case encodingBrotli:
+		r = brotli.NewReader(bytes.NewReader(data))
+	case encodingZstd:
+		zr, err := zstd.NewReader(bytes.NewReader(data))
+		require.NoError(t, err)
+		defer zr.Close()
+		r = zr
+	}
+	plain, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return plain
+}
+
+var compressTypes = []string{"application/json", "text/*"}
+
+func writeJSON(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(payload))
+}
+
+func Test_Response(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		handler        http.HandlerFunc
+		minSize        int
+		wantStatus     int
+		wantEncoding   string
+		wantType       string
+	}{
+		{
+			name:           "zstd",
+			acceptEncoding: "zstd",
+			handler:        writeJSON,
+			wantStatus:     http.StatusOK,
+			wantEncoding:   encodingZstd,
+		},
+		{
+			name:           "brotli",
+			acceptEncoding: "br, gzip;q=0.5",
+			handler:        writeJSON,
+			wantStatus:     http.StatusOK,
+			wantEncoding:   encodingBrotli,
+		},
+		{
+			name:           "gzip with wildcard type",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.Write([]byte(payload))
+			},
+			wantStatus:   http.StatusOK,
+			wantEncoding: encodingGzip,
+		},
+		{
+			name:           "not accepted",
+			acceptEncoding: "",
+			handler:        writeJSON,
+			wantStatus:     http.StatusOK,
+		},
+		{
+			name:           "type is not allowed",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "image/png")
+				w.Write([]byte(payload))
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:           "smaller than min size",
+			acceptEncoding: "gzip",
+			handler:        writeJSON,
+			minSize:        len(payload) + 1,
+			wantStatus:     http.StatusOK,
+		},
+		{
+			name:           "written in chunks reaching min size",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				for i := 0; i < len(payload); i += 100 {
+					w.Write([]byte(payload[i:min(i+100, len(payload))]))
+				}
+			},
+			minSize:      len(payload) / 2,
+			wantStatus:   http.StatusCreated,
+			wantEncoding: encodingGzip,
+		},
+		{
+			name:           "without WriteHeader and Content-Type",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(payload))
+			},
+			wantStatus:   http.StatusOK,
+			wantEncoding: encodingGzip,
+			wantType:     "text/plain; charset=utf-8",
+		},
+		{
+			name:           "already encoded",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Content-Encoding", "identity")
+				w.Write([]byte(payload))
+			},
+			wantStatus:   http.StatusOK,
+			wantEncoding: "identity",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Response(compressTypes, tt.minSize)(tt.handler)
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			w := httptest.NewRecorder()
+
+			h.ServeHTTP(w, r)
+
+			require.Equal(t, tt.wantStatus, w.Code)
+			require.Equal(t, tt.wantEncoding, w.Header().Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+			if tt.wantType != "" {
+				assert.Equal(t, tt.wantType, w.Header().Get("Content-Type"))
+			}
+			body := w.Body.Bytes()
+			if tt.wantEncoding != "" && tt.wantEncoding != "identity" {
+				body = decode(t, tt.wantEncoding, body)
+			}
+			assert.Equal(t, payload, string(body))
+		})
+	}
+}
+
+func Test_ResponseWithoutBody(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+	}{
+		{name: "redirect", status: http.StatusTemporaryRedirect},
+		{name: "no content", status: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Response(compressTypes, 0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Location", "https://example.com")
+				w.WriteHeader(tt.status)
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			w := httptest.NewRecorder()
+
+			h.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.status, w.Code)
+			assert.Empty(t, w.Header().Get("Content-Encoding"))
+			assert.Empty(t, w.Body.Bytes())
+		})
+	}
+}
+
+func Test_ResponseStreaming(t *testing.T) {
+	const chunk = `{"id":"a"}` + "\n"
+	flushed := make(chan struct{})
+	srv := httptest.NewServer(Response(compressTypes, 1024)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(chunk))
+		w.(http.Flusher).Flush()
+		// client must get the first chunk before response is completed
+		<-flushed
+		w.Write([]byte(chunk))
+	})))
+	defer srv.Close()
+	// handler must not block server shutdown when test fails
+	release := sync.OnceFunc(func() { close(flushed) })
+	defer release()
+
+	r, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	r.Header.Set("Accept-Encoding", "zstd")
+	resp, err := srv.Client().Do(r)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, encodingZstd, resp.Header.Get("Content-Encoding"))
+
+	zr, err := zstd.NewReader(resp.Body)
+	require.NoError(t, err)
+	defer zr.Close()
+	first := make([]byte, len(chunk))
+	_, err = io.ReadFull(zr, first)
+	require.NoError(t, err)
+	assert.Equal(t, chunk, string(first))
+	release()
+
+	rest, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, chunk, string(rest))
+}
+
+func Test_Request(t *testing.T) {
+	tests := []struct {
+		name            string
+		contentEncoding string
+		body            func(t *testing.T) []byte
+		maxSize         int64
+		wantStatus      int
+	}{
+		{
+			name:       "plain",
+			body:       func(t *testing.T) []byte { return []byte(payload) },
+			maxSize:    1 << 20,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:            "gzip",
+			contentEncoding: "gzip",
+			body:            func(t *testing.T) []byte { return encode(t, encodingGzip, []byte(payload)) },
+			maxSize:         1 << 20,
+			wantStatus:      http.StatusOK,
+		},
+		{
+			name:            "brotli",
+			contentEncoding: "br",
+			body:            func(t *testing.T) []byte { return encode(t, encodingBrotli, []byte(payload)) },
+			maxSize:         1 << 20,
+			wantStatus:      http.StatusOK,
+		},
+		{
+			name:            "zstd",
+			contentEncoding: "zstd",
+			body:            func(t *testing.T) []byte { return encode(t, encodingZstd, []byte(payload)) },
+			maxSize:         1 << 20,
+			wantStatus:      http.StatusOK,
+		},
+		{
+			name:            "chained gzip then zstd",
+			contentEncoding: "gzip, zstd",
+			body: func(t *testing.T) []byte {
+				return encode(t, encodingZstd, encode(t, encodingGzip, []byte(payload)))
+			},
+			maxSize:    1 << 20,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:            "decoded body exceeds limit",
+			contentEncoding: "zstd",
+			body:            func(t *testing.T) []byte { return encode(t, encodingZstd, []byte(payload)) },
+			maxSize:         int64(len(payload) - 1),
+			wantStatus:      http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:            "invalid gzip",
+			contentEncoding: "gzip",
+			body:            func(t *testing.T) []byte { return []byte(payload) },
+			maxSize:         1 << 20,
+			wantStatus:      http.StatusBadRequest,
+		},
+		{
+			name:            "unsupported coding",
+			contentEncoding: "deflate",
+			body:            func(t *testing.T) []byte { return []byte(payload) },
+			maxSize:         1 << 20,
+			wantStatus:      http.StatusUnsupportedMediaType,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Request(tt.maxSize)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Empty(t, r.Header.Get("Content-Encoding"))
+				body, err := io.ReadAll(r.Body)
+				var maxBytesErr *http.MaxBytesError
+				if errors.As(err, &maxBytesErr) {
+					w.WriteHeader(http.StatusRequestEntityTooLarge)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, payload, string(body))
+			}))
+			r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(tt.body(t)))
+			r.Header.Set("Content-Encoding", tt.contentEncoding)
+			w := httptest.NewRecorder()
+
+			h.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus == http.StatusUnsupportedMediaType {
+				assert.Equal(t, "zstd, br, gzip", w.Header().Get("Accept-Encoding"))
+			}
+		})
+	}
+}
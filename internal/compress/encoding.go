@@ -0,0 +1,203 @@
+package compress
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// content codings
+const (
+	encodingGzip     = "gzip"
+	encodingBrotli   = "br"
+	encodingZstd     = "zstd"
+	encodingIdentity = "identity"
+)
+
+// preference breaks ties of equal q-values: zstd and brotli compress better than gzip,
+// zstd is the cheapest to encode
+var preference = []string{encodingZstd, encodingBrotli, encodingGzip}
+
+// brotliLevel is a usual trade-off for dynamic responses, higher levels are for static files
+const brotliLevel = 5
+
+// zstdMaxWindow limits memory used by a single request body decoder
+const zstdMaxWindow = 8 << 20
+
+// normalizeCoding lowercases coding and maps aliases, see RFC 9110 section 8.4.1.3
+func normalizeCoding(coding string) string {
+	coding = strings.ToLower(strings.TrimSpace(coding))
+	if coding == "x-gzip" {
+		return encodingGzip
+	}
+	return coding
+}
+
+// negotiate picks coding of Accept-Encoding with the highest q-value. "*" matches codings
+// which are not listed, q=0 forbids coding. Empty result means no compression
+func negotiate(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	weights := make(map[string]float64)
+	wildcard := -1.0
+	for _, item := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(item, ";")
+		coding = normalizeCoding(coding)
+		if coding == "" {
+			continue
+		}
+		q := qValue(params)
+		if coding == "*" {
+			wildcard = q
+			continue
+		}
+		weights[coding] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range preference {
+		q, ok := weights[coding]
+		if !ok {
+			if wildcard < 0 {
+				continue
+			}
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// qValue returns weight from "q=0.5" parameter, 1 when it's absent and 0 when it's malformed
+func qValue(params string) float64 {
+	for _, param := range strings.Split(params, ";") {
+		name, value, ok := strings.Cut(param, "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(name), "q") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || q < 0 || q > 1 {
+			return 0
+		}
+		return q
+	}
+	return 1
+}
+
+// parseContentEncoding returns codings in order they were applied, identity is skipped
+func parseContentEncoding(contentEncoding string) ([]string, error) {
+	var codings []string
+	for _, item := range strings.Split(contentEncoding, ",") {
+		coding := normalizeCoding(item)
+		switch coding {
+		case "", encodingIdentity:
+		case encodingGzip, encodingBrotli, encodingZstd:
+			codings = append(codings, coding)
+		default:
+			return nil, fmt.Errorf("unsupported Content-Encoding: %q", coding)
+		}
+	}
+	return codings, nil
+}
+
+// encoder is implemented by gzip, brotli and zstd writers
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// encoders are expensive to allocate, so they are reused between responses
+var encoderPools = map[string]*sync.Pool{
+	encodingGzip: {New: func() any {
+		return gzip.NewWriter(nil)
+	}},
+	encodingBrotli: {New: func() any {
+		return brotli.NewWriterLevel(nil, brotliLevel)
+	}},
+	encodingZstd: {New: func() any {
+		// options are valid, so error is impossible
+		zw, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithLowerEncoderMem(true))
+		return zw
+	}},
+}
+
+func getEncoder(coding string, w io.Writer) encoder {
+	enc := encoderPools[coding].Get().(encoder)
+	enc.Reset(w)
+	return enc
+}
+
+// putEncoder returns closed encoder to pool, it must not be used after that
+func putEncoder(coding string, enc encoder) {
+	// don't keep response writer alive
+	enc.Reset(nil)
+	encoderPools[coding].Put(enc)
+}
+
+var zstdDecoderPool = sync.Pool{New: func() any {
+	// options are valid, so error is impossible
+	zr, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(zstdMaxWindow))
+	return zr
+}}
+
+// decoder reads decoded body and releases resources of its coding on Close
+type decoder struct {
+	io.Reader
+	close func() error
+}
+
+func (d *decoder) Close() error {
+	return d.close()
+}
+
+// newDecoder decodes body of a single coding, body is closed with decoder
+func newDecoder(coding string, body io.ReadCloser) (io.ReadCloser, error) {
+	switch coding {
+	case encodingGzip:
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		return &decoder{Reader: zr, close: func() error {
+			// gzip.Reader.Close() does not close the underlying reader
+			// so we should close both
+			return closeBoth(zr, body)
+		}}, nil
+
+	case encodingBrotli:
+		return &decoder{Reader: brotli.NewReader(body), close: body.Close}, nil
+
+	case encodingZstd:
+		zr := zstdDecoderPool.Get().(*zstd.Decoder)
+		if err := zr.Reset(body); err != nil {
+			zstdDecoderPool.Put(zr)
+			return nil, err
+		}
+		return &decoder{Reader: zr, close: func() error {
+			_ = zr.Reset(nil)
+			zstdDecoderPool.Put(zr)
+			return body.Close()
+		}}, nil
+	}
+	return nil, fmt.Errorf("unsupported Content-Encoding: %q", coding)
+}
+
+func closeBoth(first, second io.Closer) error {
+	err1 := first.Close()
+	err2 := second.Close()
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
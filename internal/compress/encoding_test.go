@@ -0,0 +1,61 @@
+package compress
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_negotiate(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		want           string
+	}{
+		{name: "empty", acceptEncoding: "", want: ""},
+		{name: "gzip only", acceptEncoding: "gzip", want: encodingGzip},
+		{name: "x-gzip alias", acceptEncoding: "x-gzip", want: encodingGzip},
+		{name: "server preference on tie", acceptEncoding: "gzip, deflate, br, zstd", want: encodingZstd},
+		{name: "higher q wins", acceptEncoding: "zstd;q=0.5, br;q=0.9, gzip;q=0.8", want: encodingBrotli},
+		{name: "case and spaces", acceptEncoding: " GZIP ; Q=0.4 , Br;q=0.3", want: encodingGzip},
+		{name: "q=0 forbids coding", acceptEncoding: "zstd;q=0, br;q=0, gzip", want: encodingGzip},
+		{name: "wildcard", acceptEncoding: "*", want: encodingZstd},
+		{name: "wildcard with exclusions", acceptEncoding: "*;q=0.5, zstd;q=0", want: encodingBrotli},
+		{name: "wildcard forbids unlisted", acceptEncoding: "gzip;q=0.2, *;q=0", want: encodingGzip},
+		{name: "invalid q", acceptEncoding: "zstd;q=abc, br;q=2, gzip;q=0.1", want: encodingGzip},
+		{name: "unsupported only", acceptEncoding: "deflate, compress", want: ""},
+		{name: "identity only", acceptEncoding: "identity", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, negotiate(tt.acceptEncoding))
+		})
+	}
+}
+
+func Test_parseContentEncoding(t *testing.T) {
+	tests := []struct {
+		name            string
+		contentEncoding string
+		want            []string
+		wantErr         bool
+	}{
+		{name: "empty", contentEncoding: "", want: nil},
+		{name: "identity", contentEncoding: "identity", want: nil},
+		{name: "single", contentEncoding: "BR", want: []string{encodingBrotli}},
+		{name: "chained", contentEncoding: "gzip, zstd", want: []string{encodingGzip, encodingZstd}},
+		{name: "unsupported", contentEncoding: "gzip, deflate", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseContentEncoding(tt.contentEncoding)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
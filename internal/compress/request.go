@@ -0,0 +1,59 @@
+package compress
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Request decodes request body encoded with gzip, br or zstd (codings may be chained).
+// Decoded body is limited by maxSize, so handlers get *http.MaxBytesError on compression bombs
+func Request(maxSize int64) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			codings, err := parseContentEncoding(r.Header.Get("Content-Encoding"))
+			if err != nil {
+				log.Printf("compress: %v", err)
+				// RFC 9110 section 15.5.16: tell client which codings are accepted
+				w.Header().Set("Accept-Encoding", strings.Join(preference, ", "))
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+				return
+			}
+			if len(codings) == 0 {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := decodeBody(r.Body, codings)
+			if err != nil {
+				log.Printf("compress: invalid request body: %v", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			// defer should be only after 'if err...' because in case of error
+			// body is nil, so defer will call nil pointer
+			defer body.Close()
+
+			r.Body = http.MaxBytesReader(w, body, maxSize)
+			r.ContentLength = -1
+			r.Header.Del("Content-Length")
+			r.Header.Del("Content-Encoding")
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// decodeBody removes codings in reverse order of their application
+func decodeBody(body io.ReadCloser, codings []string) (io.ReadCloser, error) {
+	for i := len(codings) - 1; i >= 0; i-- {
+		decoded, err := newDecoder(codings[i], body)
+		if err != nil {
+			body.Close()
+			return nil, err
+		}
+		body = decoded
+	}
+	return body, nil
+}
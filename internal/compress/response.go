@@ -0,0 +1,256 @@
+package compress
+
+import (
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// mediaTypes is an allowlist of compressible Content-Types, "text/*" matches any subtype
+type mediaTypes map[string]struct{}
+
+func newMediaTypes(types []string) mediaTypes {
+	m := make(mediaTypes, len(types))
+	for _, t := range types {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			m[t] = struct{}{}
+		}
+	}
+	return m
+}
+
+func (m mediaTypes) match(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if _, ok := m[mediaType]; ok {
+		return true
+	}
+	major, _, _ := strings.Cut(mediaType, "/")
+	_, ok := m[major+"/*"]
+	return ok
+}
+
+// sniffLen is how much data http.DetectContentType looks at
+const sniffLen = 512
+
+// compressWriter buffers response until minSize bytes are written, then it decides whether
+// to compress by Content-Type. Smaller responses are sent as is when handler returns
+type compressWriter struct {
+	// pure embedding
+	http.ResponseWriter
+	types   mediaTypes
+	minSize int
+	// coding is negotiated with client, encoder is taken from pool only when response is compressed
+	coding string
+	enc    encoder
+	head   bool
+	// status is 0 until handler calls WriteHeader() or Write()
+	status  int
+	buf     []byte
+	decided bool
+}
+
+// writers are reused between responses together with their buffers
+var writerPool = sync.Pool{New: func() any {
+	return &compressWriter{}
+}}
+
+func (c *compressWriter) reset(w http.ResponseWriter, types mediaTypes, minSize int, coding string, head bool) {
+	*c = compressWriter{
+		ResponseWriter: w,
+		types:          types,
+		minSize:        minSize,
+		coding:         coding,
+		head:           head,
+		buf:            c.buf[:0],
+	}
+}
+
+func (c *compressWriter) Header() http.Header {
+	return c.ResponseWriter.Header()
+}
+
+func (c *compressWriter) WriteHeader(statusCode int) {
+	// informational responses don't have body and may be followed by the final one
+	if statusCode >= 100 && statusCode < 200 {
+		c.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	if c.status != 0 {
+		// let net/http log superfluous call
+		if c.decided {
+			c.ResponseWriter.WriteHeader(statusCode)
+		}
+		return
+	}
+	c.status = statusCode
+
+	// there is nothing to wait for when body is absent or too small
+	if !bodyAllowed(statusCode) || c.head || c.Header().Get("Content-Encoding") != "" {
+		c.decide(false)
+		return
+	}
+	if n, err := strconv.Atoi(c.Header().Get("Content-Length")); err == nil && n < c.minSize {
+		c.decide(false)
+	}
+}
+
+func (c *compressWriter) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	if !c.decided {
+		if len(c.buf)+len(b) < c.minSize {
+			c.buf = append(c.buf, b...)
+			return len(b), nil
+		}
+		c.decide(c.compressible(b))
+		if err := c.writeBuffered(); err != nil {
+			return 0, err
+		}
+	}
+	if c.enc != nil {
+		return c.enc.Write(b)
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+// compressible checks Content-Type, it's sniffed from body like net/http does when handler didn't set it
+func (c *compressWriter) compressible(next []byte) bool {
+	if c.Header().Get("Content-Encoding") != "" {
+		return false
+	}
+	ct := c.Header().Get("Content-Type")
+	if ct == "" {
+		sniff := c.buf
+		if len(sniff) < sniffLen {
+			sniff = append(sniff[:len(sniff):len(sniff)], next[:min(len(next), sniffLen-len(sniff))]...)
+		}
+		if len(sniff) == 0 {
+			return false
+		}
+		ct = http.DetectContentType(sniff)
+		c.Header().Set("Content-Type", ct)
+	}
+	return c.types.match(ct)
+}
+
+// decide sends headers, so it's called once per response
+func (c *compressWriter) decide(compress bool) {
+	c.decided = true
+	if compress {
+		c.enc = getEncoder(c.coding, c.ResponseWriter)
+		c.Header().Set("Content-Encoding", c.coding)
+		c.Header().Del("Content-Length")
+		// ranges of the identity representation don't match encoded one
+		c.Header().Del("Accept-Ranges")
+	}
+	c.ResponseWriter.WriteHeader(c.status)
+}
+
+func (c *compressWriter) writeBuffered() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+	var err error
+	if c.enc != nil {
+		_, err = c.enc.Write(c.buf)
+	} else {
+		_, err = c.ResponseWriter.Write(c.buf)
+	}
+	c.buf = c.buf[:0]
+	return err
+}
+
+// Flush sends compressed so far data to client, it's used by streaming handlers.
+// Streamed response is compressed regardless of its size
+func (c *compressWriter) Flush() {
+	if !c.decided {
+		if c.status == 0 {
+			c.status = http.StatusOK
+		}
+		c.decide(c.compressible(nil))
+	}
+	if err := c.writeBuffered(); err != nil {
+		log.Printf("compress: cannot write buffered data: %v", err)
+		return
+	}
+	if c.enc != nil {
+		if err := c.enc.Flush(); err != nil {
+			log.Printf("compress: cannot flush %s: %v", c.coding, err)
+			return
+		}
+	}
+	// underlying writer may be wrapped by other middlewares
+	if err := http.NewResponseController(c.ResponseWriter).Flush(); err != nil {
+		log.Printf("compress: cannot flush: %v", err)
+	}
+}
+
+// Unwrap lets http.ResponseController reach deadlines of the underlying connection
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// ResponseWriter doesn't have Close() method (https://pkg.go.dev/net/http#ResponseWriter)
+// but small buffered responses must be sent, and encoders must be closed to write
+// buffered data and footer of the stream. It does not close the underlying io.Writer
+func (c *compressWriter) Close() error {
+	if !c.decided {
+		// handler wrote nothing, net/http will send empty 200 response
+		if c.status == 0 {
+			return nil
+		}
+		c.decide(false)
+	}
+	if err := c.writeBuffered(); err != nil {
+		return err
+	}
+	if c.enc == nil {
+		return nil
+	}
+	err := c.enc.Close()
+	putEncoder(c.coding, c.enc)
+	c.enc = nil
+	return err
+}
+
+// Response compresses response body with the best coding of Accept-Encoding (zstd, br or gzip)
+// when its Content-Type matches types and body is at least minSize bytes or it's streamed
+func Response(types []string, minSize int) func(http.Handler) http.Handler {
+	allowed := newMediaTypes(types)
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// caches must not serve compressed response to client which doesn't support it
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			coding := negotiate(r.Header.Get("Accept-Encoding"))
+			if coding == "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			cw := writerPool.Get().(*compressWriter)
+			cw.reset(w, allowed, minSize, coding, r.Method == http.MethodHead)
+			defer func() {
+				if err := cw.Close(); err != nil {
+					log.Printf("compress: cannot close %s response: %v", coding, err)
+				}
+				// don't keep response writer alive
+				cw.reset(nil, nil, 0, "", false)
+				writerPool.Put(cw)
+			}()
+			h.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// bodyAllowed reports whether response with status may have body, see RFC 9110 section 6.4.1
+func bodyAllowed(status int) bool {
+	return status != http.StatusNoContent && status != http.StatusNotModified
+}
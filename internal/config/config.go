@@ -1,51 +1,641 @@
 package config
 
 import (
+	"crypto/tls"
+	"errors"
 	"flag"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// Config is loaded from (in order of precedence, the last wins):
+// defaults, config file, environment variables and command line flags.
+// File keys are lowercased names of environment variables
 type Config struct {
-	ServerAddr      string
-	BaseURL         string
-	FileStoragePath string
-	DSN             string
+	// ConfigPath is a YAML or JSON file, it's set by -c flag or CONFIG env only
+	ConfigPath string `yaml:"-"`
+	ServerAddr string `yaml:"server_address"`
+	// GRPCAddr serves gRPC API, empty turns it off. It uses TLS when EnableHTTPS is set
+	GRPCAddr string `yaml:"grpc_address"`
+	// GRPCMaxRecvMsgSize limits size of gRPC request message
+	GRPCMaxRecvMsgSize int `yaml:"grpc_max_recv_msg_size"`
+	// GRPCCallTimeout limits every gRPC call, client may set a shorter deadline, 0 means no limit
+	GRPCCallTimeout time.Duration `yaml:"grpc_call_timeout"`
+	BaseURL         string        `yaml:"base_url"`
+	FileStoragePath string        `yaml:"file_storage_path"`
+	DSN             string        `yaml:"database_dsn"`
+	// ReplicaDSNs is a comma separated list of read-only replicas of DSN database
+	ReplicaDSNs string `yaml:"database_replica_dsns"`
+	// RedirectCode is used for links without their own redirect code
+	RedirectCode int `yaml:"redirect_code"`
+	// QueryPolicy is used for links without their own query policy
+	QueryPolicy string `yaml:"query_policy"`
+	// GeoIPPath points to CSV file with "network,country" lines
+	GeoIPPath string `yaml:"geoip_db_path"`
+	// destination URL restrictions
+	MaxURLLength   int    `yaml:"max_url_length"`
+	AllowedSchemes string `yaml:"allowed_schemes"`
+	BlocklistPath  string `yaml:"blocklist_path"`
+	// CacheSize is a number of cached links, 0 turns cache off.
+	// Instances sharing Postgres should turn cache on alike (see db.PGStorage.NotifyChanges)
+	CacheSize        int           `yaml:"cache_size"`
+	CacheTTL         time.Duration `yaml:"cache_ttl"`
+	CacheNegativeTTL time.Duration `yaml:"cache_negative_ttl"`
+	// LogLevel is one of debug, info, warn or error
+	LogLevel string `yaml:"log_level"`
+	// JWTSecret signs user and admin tokens, it's set by file or JWT_SECRET env only,
+	// so it doesn't leak into process list.
+	// Empty secret means a random key of process, so tokens don't survive restart
+	JWTSecret string `yaml:"jwt_secret"`
+	// EnableHTTPS serves TLS on ServerAddr, certificate files are reloaded on change
+	EnableHTTPS bool   `yaml:"enable_https"`
+	TLSCertPath string `yaml:"tls_cert_file"`
+	TLSKeyPath  string `yaml:"tls_key_file"`
+	// TLSMinVersion is 1.2 or 1.3
+	TLSMinVersion string `yaml:"tls_min_version"`
+	// HTTPRedirectAddr is a plain HTTP listener redirecting to HTTPS, empty turns it off
+	HTTPRedirectAddr string `yaml:"http_redirect_address"`
+	// HSTSMaxAge is sent in Strict-Transport-Security over HTTPS, 0 turns header off
+	HSTSMaxAge time.Duration `yaml:"hsts_max_age"`
+	// http.Server limits, 0 timeout means no timeout
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	MaxHeaderBytes    int           `yaml:"max_header_bytes"`
+	// MaxConnections limits concurrently accepted connections, 0 means no limit
+	MaxConnections int `yaml:"max_connections"`
+	// ShutdownTimeout limits graceful shutdown as a whole
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// MaxDecodedBody limits size of request body after Content-Encoding is decoded
+	MaxDecodedBody int `yaml:"max_decoded_body"`
+	// CompressTypes is a comma separated list of compressible media types, "text/*" matches any subtype
+	CompressTypes string `yaml:"compress_types"`
+	// CompressMinSize is a size of response body to start compression, streamed responses are always compressed
+	CompressMinSize int `yaml:"compress_min_size"`
+	// TrustedSubnet is a CIDR allowed to call /api/internal, empty forbids everyone
+	TrustedSubnet string `yaml:"trusted_subnet"`
+	// TrustedProxies is a comma separated list of reverse proxy addresses or CIDRs,
+	// X-Real-IP and X-Forwarded-For are honored only when they are sent by these proxies.
+	// Loopback is trusted by default for proxy on the same host, empty value trusts nobody
+	TrustedProxies string `yaml:"trusted_proxies"`
+	// ReportRateLimit is a number of link reports per minute accepted from one client IP, 0 means no limit
+	ReportRateLimit int `yaml:"report_rate_limit"`
 }
 
+// query policies define what to do with query parameters of a short URL on redirect
+const (
+	// QueryPolicyIgnore drops incoming parameters
+	QueryPolicyIgnore = "ignore"
+	// QueryPolicyAppend adds incoming parameters which are absent in original URL
+	QueryPolicyAppend = "append"
+	// QueryPolicyOverride adds incoming parameters replacing the same ones in original URL
+	QueryPolicyOverride = "override"
+)
+
 func GetDefaultConfig() *Config {
 	return &Config{
-		ServerAddr:      ":8080",
-		BaseURL:         "http://localhost:8080",
-		FileStoragePath: "",
-		DSN:             "",
+		ServerAddr:       ":8080",
+		BaseURL:          "http://localhost:8080",
+		FileStoragePath:  "",
+		DSN:              "",
+		RedirectCode:     http.StatusTemporaryRedirect,
+		QueryPolicy:      QueryPolicyIgnore,
+		MaxURLLength:     2048,
+		AllowedSchemes:   "http,https",
+		CacheSize:        0,
+		CacheTTL:         time.Minute,
+		CacheNegativeTTL: 10 * time.Second,
+		LogLevel:         "info",
+		TLSCertPath:      "cert.pem",
+		TLSKeyPath:       "key.pem",
+		TLSMinVersion:    "1.2",
+		HSTSMaxAge:       365 * 24 * time.Hour,
+		// streaming handlers (export, backup) lift write deadline themselves
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       time.Minute,
+		WriteTimeout:      2 * time.Minute,
+		IdleTimeout:       2 * time.Minute,
+		MaxHeaderBytes:    http.DefaultMaxHeaderBytes,
+		MaxConnections:    0,
+		ShutdownTimeout:   10 * time.Second,
+		MaxDecodedBody:    64 << 20,
+		CompressTypes:     "application/json,application/x-ndjson,text/html,text/csv",
+		CompressMinSize:   1024,
+		ReportRateLimit:   10,
+		TrustedProxies:    "127.0.0.1,::1",
+		// 4 MiB is gRPC default, calls are limited like HTTP requests by ReadTimeout
+		GRPCMaxRecvMsgSize: 4 << 20,
+		GRPCCallTimeout:    time.Minute,
 	}
 }
 
+// GetConfig loads config from process arguments and environment, it exits on error
 func GetConfig() *Config {
+	cfg, err := Load(flag.CommandLine, os.Args[1:], os.Getenv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return cfg
+}
+
+// Load parses args with fs and applies sources in order of precedence:
+// defaults, config file, env (getenv) and flags set in args.
+// fs must not have flags with the same names already
+func Load(fs *flag.FlagSet, args []string, getenv func(string) string) (*Config, error) {
 	cfg := GetDefaultConfig()
+	own := registerFlags(fs, cfg)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// flags are parsed first only to find config file,
+	// values of set ones are applied again over file and env
+	set := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		if _, ok := own[f.Name]; ok {
+			set[f.Name] = f.Value.String()
+		}
+	})
+
+	path := cfg.ConfigPath
+	if path == "" {
+		path = getenv("CONFIG")
+	}
+
+	*cfg = *GetDefaultConfig()
+	if path != "" {
+		if err := loadFile(path, cfg); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyEnv(cfg, getenv); err != nil {
+		return nil, err
+	}
+	for name, value := range set {
+		if err := fs.Set(name, value); err != nil {
+			return nil, fmt.Errorf("invalid value %q for flag -%s: %w", value, name, err)
+		}
+	}
+	cfg.ConfigPath = path
 
-	flag.StringVar(&cfg.ServerAddr, "a", cfg.ServerAddr,
+	return cfg, nil
+}
+
+// registerFlags binds flags to cfg fields and returns their names
+func registerFlags(fs *flag.FlagSet, cfg *Config) map[string]struct{} {
+	names := make(map[string]struct{})
+	add := func(name string) string {
+		names[name] = struct{}{}
+		return name
+	}
+	fs.StringVar(&cfg.ConfigPath, add("c"), cfg.ConfigPath,
+		"config file path, YAML or JSON (default \"\")")
+	fs.StringVar(&cfg.ServerAddr, add("a"), cfg.ServerAddr,
 		"server address in host:port format (default :8080)")
-	flag.StringVar(&cfg.BaseURL, "b", cfg.BaseURL,
+	fs.StringVar(&cfg.GRPCAddr, add("grpc-addr"), cfg.GRPCAddr,
+		"gRPC server address in host:port format (default \"\")")
+	fs.IntVar(&cfg.GRPCMaxRecvMsgSize, add("grpc-max-recv-msg-size"), cfg.GRPCMaxRecvMsgSize,
+		"max size of gRPC request message (default 4194304)")
+	fs.DurationVar(&cfg.GRPCCallTimeout, add("grpc-call-timeout"), cfg.GRPCCallTimeout,
+		"gRPC call timeout, 0 means no timeout (default 1m)")
+	fs.StringVar(&cfg.BaseURL, add("b"), cfg.BaseURL,
 		"base URL (default http://localhost:8080)")
-	flag.StringVar(&cfg.FileStoragePath, "f", cfg.FileStoragePath,
+	fs.StringVar(&cfg.FileStoragePath, add("f"), cfg.FileStoragePath,
 		"file storage path (default \"\")")
-	flag.StringVar(&cfg.DSN, "d", cfg.DSN,
+	fs.StringVar(&cfg.DSN, add("d"), cfg.DSN,
 		"Database DSN (default \"\")")
-	flag.Parse()
+	fs.StringVar(&cfg.ReplicaDSNs, add("replica-dsn"), cfg.ReplicaDSNs,
+		"comma separated list of read-only replica DSNs (default \"\")")
+	fs.IntVar(&cfg.RedirectCode, add("r"), cfg.RedirectCode,
+		"default redirect status code: 301, 302, 307 or 308 (default 307)")
+	fs.StringVar(&cfg.QueryPolicy, add("q"), cfg.QueryPolicy,
+		"default query parameters policy on redirect: ignore, append or override (default ignore)")
+	fs.StringVar(&cfg.GeoIPPath, add("g"), cfg.GeoIPPath,
+		"GeoIP database path (default \"\")")
+	fs.IntVar(&cfg.MaxURLLength, add("max-url-length"), cfg.MaxURLLength,
+		"max length of destination URL (default 2048)")
+	fs.StringVar(&cfg.AllowedSchemes, add("allowed-schemes"), cfg.AllowedSchemes,
+		"comma separated list of allowed destination URL schemes (default http,https)")
+	fs.StringVar(&cfg.BlocklistPath, add("blocklist"), cfg.BlocklistPath,
+		"path to file with blocked domains, one per line (default \"\")")
+	fs.IntVar(&cfg.CacheSize, add("cache-size"), cfg.CacheSize,
+		"number of cached links, 0 turns cache off (default 0)")
+	fs.DurationVar(&cfg.CacheTTL, add("cache-ttl"), cfg.CacheTTL,
+		"cached link lifetime (default 1m)")
+	fs.DurationVar(&cfg.CacheNegativeTTL, add("cache-negative-ttl"), cfg.CacheNegativeTTL,
+		"lifetime of cached \"not found\", 0 turns it off (default 10s)")
+	fs.StringVar(&cfg.LogLevel, add("log-level"), cfg.LogLevel,
+		"log level: debug, info, warn or error (default info)")
+	fs.BoolVar(&cfg.EnableHTTPS, add("s"), cfg.EnableHTTPS,
+		"serve HTTPS (default false)")
+	fs.StringVar(&cfg.TLSCertPath, add("tls-cert"), cfg.TLSCertPath,
+		"TLS certificate file, it's reloaded on change (default cert.pem)")
+	fs.StringVar(&cfg.TLSKeyPath, add("tls-key"), cfg.TLSKeyPath,
+		"TLS private key file, it's reloaded on change (default key.pem)")
+	fs.StringVar(&cfg.TLSMinVersion, add("tls-min-version"), cfg.TLSMinVersion,
+		"minimum TLS version: 1.2 or 1.3 (default 1.2)")
+	fs.StringVar(&cfg.HTTPRedirectAddr, add("http-redirect-addr"), cfg.HTTPRedirectAddr,
+		"address of plain HTTP listener redirecting to HTTPS (default \"\")")
+	fs.DurationVar(&cfg.HSTSMaxAge, add("hsts-max-age"), cfg.HSTSMaxAge,
+		"Strict-Transport-Security max age, 0 turns it off (default 8760h)")
+	fs.DurationVar(&cfg.ReadHeaderTimeout, add("read-header-timeout"), cfg.ReadHeaderTimeout,
+		"time to read request headers (default 5s)")
+	fs.DurationVar(&cfg.ReadTimeout, add("read-timeout"), cfg.ReadTimeout,
+		"time to read the whole request, 0 means no timeout (default 1m)")
+	fs.DurationVar(&cfg.WriteTimeout, add("write-timeout"), cfg.WriteTimeout,
+		"time to write response, 0 means no timeout (default 2m)")
+	fs.DurationVar(&cfg.IdleTimeout, add("idle-timeout"), cfg.IdleTimeout,
+		"keep-alive connection idle time, 0 means read timeout (default 2m)")
+	fs.IntVar(&cfg.MaxHeaderBytes, add("max-header-bytes"), cfg.MaxHeaderBytes,
+		"max size of request headers (default 1048576)")
+	fs.IntVar(&cfg.MaxConnections, add("max-connections"), cfg.MaxConnections,
+		"max concurrent connections, 0 means no limit (default 0)")
+	fs.DurationVar(&cfg.ShutdownTimeout, add("shutdown-timeout"), cfg.ShutdownTimeout,
+		"graceful shutdown timeout (default 10s)")
+	fs.IntVar(&cfg.MaxDecodedBody, add("max-decoded-body"), cfg.MaxDecodedBody,
+		"max size of decompressed request body (default 67108864)")
+	fs.StringVar(&cfg.CompressTypes, add("compress-types"), cfg.CompressTypes,
+		"comma separated list of compressible response media types (default application/json,application/x-ndjson,text/html,text/csv)")
+	fs.IntVar(&cfg.CompressMinSize, add("compress-min-size"), cfg.CompressMinSize,
+		"min size of response body to compress (default 1024)")
+	fs.StringVar(&cfg.TrustedSubnet, add("t"), cfg.TrustedSubnet,
+		"CIDR of clients allowed to call internal API (default \"\")")
+	fs.StringVar(&cfg.TrustedProxies, add("trusted-proxies"), cfg.TrustedProxies,
+		"comma separated list of reverse proxy addresses or CIDRs allowed to set client IP headers (default 127.0.0.1,::1)")
+	fs.IntVar(&cfg.ReportRateLimit, add("report-rate-limit"), cfg.ReportRateLimit,
+		"link reports per minute from one client IP, 0 means no limit (default 10)")
+	return names
+}
+
+// applyEnv overrides cfg with set environment variables
+func applyEnv(cfg *Config, getenv func(string) string) error {
+	envString(getenv, "SERVER_ADDRESS", &cfg.ServerAddr)
+	envString(getenv, "GRPC_ADDRESS", &cfg.GRPCAddr)
+	envString(getenv, "BASE_URL", &cfg.BaseURL)
+	envString(getenv, "JWT_SECRET", &cfg.JWTSecret)
+	envString(getenv, "FILE_STORAGE_PATH", &cfg.FileStoragePath)
+	envString(getenv, "DATABASE_DSN", &cfg.DSN)
+	envString(getenv, "DATABASE_REPLICA_DSNS", &cfg.ReplicaDSNs)
+	envString(getenv, "QUERY_POLICY", &cfg.QueryPolicy)
+	envString(getenv, "GEOIP_DB_PATH", &cfg.GeoIPPath)
+	envString(getenv, "ALLOWED_SCHEMES", &cfg.AllowedSchemes)
+	envString(getenv, "COMPRESS_TYPES", &cfg.CompressTypes)
+	envString(getenv, "BLOCKLIST_PATH", &cfg.BlocklistPath)
+	envString(getenv, "LOG_LEVEL", &cfg.LogLevel)
+	envString(getenv, "TLS_CERT_FILE", &cfg.TLSCertPath)
+	envString(getenv, "TLS_KEY_FILE", &cfg.TLSKeyPath)
+	envString(getenv, "TLS_MIN_VERSION", &cfg.TLSMinVersion)
+	envString(getenv, "HTTP_REDIRECT_ADDRESS", &cfg.HTTPRedirectAddr)
+	envString(getenv, "TRUSTED_SUBNET", &cfg.TrustedSubnet)
+	envString(getenv, "TRUSTED_PROXIES", &cfg.TrustedProxies)
+
+	return errors.Join(
+		envInt(getenv, "REDIRECT_CODE", &cfg.RedirectCode),
+		envInt(getenv, "MAX_URL_LENGTH", &cfg.MaxURLLength),
+		envInt(getenv, "CACHE_SIZE", &cfg.CacheSize),
+		envDuration(getenv, "CACHE_TTL", &cfg.CacheTTL),
+		envDuration(getenv, "CACHE_NEGATIVE_TTL", &cfg.CacheNegativeTTL),
+		envBool(getenv, "ENABLE_HTTPS", &cfg.EnableHTTPS),
+		envDuration(getenv, "HSTS_MAX_AGE", &cfg.HSTSMaxAge),
+		envDuration(getenv, "READ_HEADER_TIMEOUT", &cfg.ReadHeaderTimeout),
+		envDuration(getenv, "READ_TIMEOUT", &cfg.ReadTimeout),
+		envDuration(getenv, "WRITE_TIMEOUT", &cfg.WriteTimeout),
+		envDuration(getenv, "IDLE_TIMEOUT", &cfg.IdleTimeout),
+		envInt(getenv, "MAX_HEADER_BYTES", &cfg.MaxHeaderBytes),
+		envInt(getenv, "MAX_CONNECTIONS", &cfg.MaxConnections),
+		envDuration(getenv, "SHUTDOWN_TIMEOUT", &cfg.ShutdownTimeout),
+		envInt(getenv, "MAX_DECODED_BODY", &cfg.MaxDecodedBody),
+		envInt(getenv, "COMPRESS_MIN_SIZE", &cfg.CompressMinSize),
+		envInt(getenv, "REPORT_RATE_LIMIT", &cfg.ReportRateLimit),
+		envInt(getenv, "GRPC_MAX_RECV_MSG_SIZE", &cfg.GRPCMaxRecvMsgSize),
+		envDuration(getenv, "GRPC_CALL_TIMEOUT", &cfg.GRPCCallTimeout),
+	)
+}
 
-	if envServerAddr := os.Getenv("SERVER_ADDRESS"); envServerAddr != "" {
-		cfg.ServerAddr = envServerAddr
+func envString(getenv func(string) string, key string, dst *string) {
+	if v := getenv(key); v != "" {
+		*dst = v
 	}
-	if envBaseURL := os.Getenv("BASE_URL"); envBaseURL != "" {
-		cfg.BaseURL = envBaseURL
+}
+
+func envInt(getenv func(string) string, key string, dst *int) error {
+	v := getenv(key)
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", key, v, err)
 	}
-	if envFileStoragePath := os.Getenv("FILE_STORAGE_PATH"); envFileStoragePath != "" {
-		cfg.FileStoragePath = envFileStoragePath
+	*dst = n
+	return nil
+}
+
+func envBool(getenv func(string) string, key string, dst *bool) error {
+	v := getenv(key)
+	if v == "" {
+		return nil
 	}
-	if envDSN := os.Getenv("DATABASE_DSN"); envDSN != "" {
-		cfg.DSN = envDSN
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", key, v, err)
 	}
+	*dst = b
+	return nil
+}
 
-	return cfg
+func envDuration(getenv func(string) string, key string, dst *time.Duration) error {
+	v := getenv(key)
+	if v == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", key, v, err)
+	}
+	*dst = d
+	return nil
+}
+
+// IsRedirectCode reports whether code may be used to redirect from a short URL
+func IsRedirectCode(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently,
+		http.StatusFound,
+		http.StatusTemporaryRedirect,
+		http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+func IsQueryPolicy(policy string) bool {
+	switch policy {
+	case QueryPolicyIgnore, QueryPolicyAppend, QueryPolicyOverride:
+		return true
+	}
+	return false
+}
+
+// Replicas splits ReplicaDSNs skipping empty items
+func (c *Config) Replicas() []string {
+	var dsns []string
+	for _, dsn := range strings.Split(c.ReplicaDSNs, ",") {
+		if dsn = strings.TrimSpace(dsn); dsn != "" {
+			dsns = append(dsns, dsn)
+		}
+	}
+	return dsns
+}
+
+// Validate checks values which cannot be fixed by defaults
+func (c *Config) Validate() error {
+	if err := validateServerAddr(c.ServerAddr); err != nil {
+		return err
+	}
+	if c.GRPCAddr != "" {
+		if err := validateServerAddr(c.GRPCAddr); err != nil {
+			return fmt.Errorf("gRPC: %w", err)
+		}
+		if c.GRPCAddr == c.ServerAddr || c.GRPCAddr == c.HTTPRedirectAddr {
+			return fmt.Errorf("gRPC address must differ from HTTP listeners: %q", c.GRPCAddr)
+		}
+	}
+	if err := validateBaseURL(c.BaseURL); err != nil {
+		return err
+	}
+	if err := ValidateJWTSecret(c.JWTSecret); err != nil {
+		return err
+	}
+	if !IsLogLevel(c.LogLevel) {
+		return fmt.Errorf("unsupported log level: %q", c.LogLevel)
+	}
+	if _, err := c.TrustedNetwork(); err != nil {
+		return err
+	}
+	if _, err := c.TrustedProxyNetworks(); err != nil {
+		return err
+	}
+	if _, err := c.CompressMediaTypes(); err != nil {
+		return err
+	}
+	if err := c.validateTLS(); err != nil {
+		return err
+	}
+	if err := c.validateServerLimits(); err != nil {
+		return err
+	}
+	if !IsRedirectCode(c.RedirectCode) {
+		return fmt.Errorf("unsupported redirect code: %d", c.RedirectCode)
+	}
+	if !IsQueryPolicy(c.QueryPolicy) {
+		return fmt.Errorf("unsupported query policy: %q", c.QueryPolicy)
+	}
+	if c.MaxURLLength <= 0 {
+		return fmt.Errorf("max URL length must be positive: %d", c.MaxURLLength)
+	}
+	if c.ReplicaDSNs != "" && c.DSN == "" {
+		return fmt.Errorf("replica DSNs require primary DSN")
+	}
+	if c.CacheSize < 0 {
+		return fmt.Errorf("cache size must not be negative: %d", c.CacheSize)
+	}
+	if c.CacheSize > 0 && c.CacheTTL <= 0 {
+		return fmt.Errorf("cache TTL must be positive: %s", c.CacheTTL)
+	}
+	return nil
+}
+
+// minJWTSecretLength is a size of HS256 hash, shorter keys are easier to brute force
+const minJWTSecretLength = 32
+
+// insecureJWTSecret was hardcoded before the secret became configurable, it's public
+const insecureJWTSecret = "my-secret-key-change-in-production"
+
+// ValidateJWTSecret refuses short and publicly known secrets, empty one is not set at all
+func ValidateJWTSecret(secret string) error {
+	switch {
+	case secret == "":
+		return nil
+	case secret == insecureJWTSecret:
+		return errors.New("JWT secret must not be the former default value")
+	case len(secret) < minJWTSecretLength:
+		return fmt.Errorf("JWT secret must be at least %d bytes", minJWTSecretLength)
+	}
+	return nil
+}
+
+// validateServerAddr accepts host:port with empty host meaning all interfaces
+func validateServerAddr(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid server address %q, host:port is expected: %w", addr, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid server address %q: port must be a number from 0 to 65535", addr)
+	}
+	return nil
+}
+
+// validateBaseURL requires absolute http(s) URL, short IDs are appended to its path
+func validateBaseURL(baseURL string) error {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return fmt.Errorf("invalid base URL %q: %w", baseURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid base URL %q: scheme must be http or https", baseURL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid base URL %q: host is empty", baseURL)
+	}
+	if u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return fmt.Errorf("invalid base URL %q: query, fragment and user info are not allowed", baseURL)
+	}
+	return nil
+}
+
+func IsLogLevel(level string) bool {
+	switch level {
+	case "debug", "info", "warn", "error":
+		return true
+	}
+	return false
+}
+
+// TrustedNetwork parses TrustedSubnet, result is invalid (zero) prefix when it's not set
+func (c *Config) TrustedNetwork() (netip.Prefix, error) {
+	if c.TrustedSubnet == "" {
+		return netip.Prefix{}, nil
+	}
+	prefix, err := netip.ParsePrefix(c.TrustedSubnet)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid trusted subnet: %w", err)
+	}
+	return prefix.Masked(), nil
+}
+
+// CompressMediaTypes splits CompressTypes, each item is a media type without parameters or "type/*"
+func (c *Config) CompressMediaTypes() ([]string, error) {
+	var types []string
+	for _, t := range strings.Split(c.CompressTypes, ",") {
+		if t = strings.ToLower(strings.TrimSpace(t)); t == "" {
+			continue
+		}
+		mediaType, params, err := mime.ParseMediaType(t)
+		if err != nil || len(params) != 0 || !strings.Contains(mediaType, "/") {
+			return nil, fmt.Errorf("invalid compress media type: %q", t)
+		}
+		types = append(types, mediaType)
+	}
+	return types, nil
+}
+
+// TrustedProxyNetworks parses TrustedProxies, single address is a network of one host
+func (c *Config) TrustedProxyNetworks() ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(c.TrustedProxies, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		if ip, err := netip.ParseAddr(item); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %w", err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// TLSVersion converts TLSMinVersion to crypto/tls constant
+func (c *Config) TLSVersion() (uint16, error) {
+	switch c.TLSMinVersion {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS min version: %q, 1.2 or 1.3 is expected", c.TLSMinVersion)
+}
+
+func (c *Config) validateTLS() error {
+	if !c.EnableHTTPS {
+		if c.HTTPRedirectAddr != "" {
+			return fmt.Errorf("HTTP redirect listener requires HTTPS to be enabled")
+		}
+		return nil
+	}
+	if c.TLSCertPath == "" || c.TLSKeyPath == "" {
+		return fmt.Errorf("HTTPS requires TLS certificate and key files")
+	}
+	if _, err := c.TLSVersion(); err != nil {
+		return err
+	}
+	if c.HSTSMaxAge < 0 {
+		return fmt.Errorf("HSTS max age must not be negative: %s", c.HSTSMaxAge)
+	}
+	if c.HTTPRedirectAddr != "" {
+		if err := validateServerAddr(c.HTTPRedirectAddr); err != nil {
+			return fmt.Errorf("HTTP redirect listener: %w", err)
+		}
+		if c.HTTPRedirectAddr == c.ServerAddr {
+			return fmt.Errorf("HTTP redirect listener must differ from server address %q", c.ServerAddr)
+		}
+	}
+	return nil
+}
+
+// validateServerLimits allows zero timeouts, connections and report rate meaning no limit
+func (c *Config) validateServerLimits() error {
+	timeouts := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"read header timeout", c.ReadHeaderTimeout},
+		{"read timeout", c.ReadTimeout},
+		{"write timeout", c.WriteTimeout},
+		{"idle timeout", c.IdleTimeout},
+		{"gRPC call timeout", c.GRPCCallTimeout},
+	}
+	for _, t := range timeouts {
+		if t.value < 0 {
+			return fmt.Errorf("%s must not be negative: %s", t.name, t.value)
+		}
+	}
+	if c.MaxHeaderBytes <= 0 {
+		return fmt.Errorf("max header bytes must be positive: %d", c.MaxHeaderBytes)
+	}
+	if c.MaxConnections < 0 {
+		return fmt.Errorf("max connections must not be negative: %d", c.MaxConnections)
+	}
+	if c.GRPCMaxRecvMsgSize <= 0 {
+		return fmt.Errorf("gRPC max receive message size must be positive: %d", c.GRPCMaxRecvMsgSize)
+	}
+	if c.MaxDecodedBody <= 0 {
+		return fmt.Errorf("max decoded body must be positive: %d", c.MaxDecodedBody)
+	}
+	if c.CompressMinSize < 0 {
+		return fmt.Errorf("compress min size must not be negative: %d", c.CompressMinSize)
+	}
+	if c.ReportRateLimit < 0 {
+		return fmt.Errorf("report rate limit must not be negative: %d", c.ReportRateLimit)
+	}
+	if c.ShutdownTimeout <= 0 {
+		return fmt.Errorf("shutdown timeout must be positive: %s", c.ShutdownTimeout)
+	}
+	return nil
 }
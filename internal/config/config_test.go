@@ -2,10 +2,14 @@ package config
 
 import (
 	"flag"
+	"io"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func resetFlagForTesting() {
@@ -48,9 +52,10 @@ func Test_Config(t *testing.T) {
 				"SERVER_ADDRESS": "localhost:1085",
 				"BASE_URL":       "http://127.0.0.1:1085",
 			},
+			// flags take precedence over envs
 			want: Config{
-				ServerAddr: "localhost:1085",
-				BaseURL:    "http://127.0.0.1:1085",
+				ServerAddr: ":443",
+				BaseURL:    "https://localhost:443",
 			},
 		},
 		{
@@ -82,3 +87,335 @@ func Test_Config(t *testing.T) {
 		})
 	}
 }
+
+func Test_LoadPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(yamlPath, []byte(
+		"server_address: \":9000\"\n"+
+			"base_url: http://file.example\n"+
+			"max_url_length: 100\n"+
+			"cache_ttl: 5m\n"), 0644))
+	jsonPath := filepath.Join(dir, "config.json")
+	require.NoError(t, os.WriteFile(jsonPath, []byte(
+		`{"server_address": ":9001", "log_level": "debug"}`), 0644))
+
+	tests := []struct {
+		name string
+		args []string
+		envs map[string]string
+		want func(t *testing.T, cfg *Config)
+	}{
+		{
+			name: "file overrides defaults",
+			args: []string{"-c", yamlPath},
+			want: func(t *testing.T, cfg *Config) {
+				assert.Equal(t, ":9000", cfg.ServerAddr)
+				assert.Equal(t, "http://file.example", cfg.BaseURL)
+				assert.Equal(t, 100, cfg.MaxURLLength)
+				assert.Equal(t, 5*time.Minute, cfg.CacheTTL)
+				// not set in file
+				assert.Equal(t, "http,https", cfg.AllowedSchemes)
+			},
+		},
+		{
+			name: "env overrides file",
+			args: []string{"-c", yamlPath},
+			envs: map[string]string{"SERVER_ADDRESS": ":9100", "MAX_URL_LENGTH": "200"},
+			want: func(t *testing.T, cfg *Config) {
+				assert.Equal(t, ":9100", cfg.ServerAddr)
+				assert.Equal(t, 200, cfg.MaxURLLength)
+				assert.Equal(t, "http://file.example", cfg.BaseURL)
+			},
+		},
+		{
+			name: "flags override env and file",
+			args: []string{"-c", yamlPath, "-a", ":9200", "-max-url-length", "300"},
+			envs: map[string]string{"SERVER_ADDRESS": ":9100", "MAX_URL_LENGTH": "200"},
+			want: func(t *testing.T, cfg *Config) {
+				assert.Equal(t, ":9200", cfg.ServerAddr)
+				assert.Equal(t, 300, cfg.MaxURLLength)
+				assert.Equal(t, 5*time.Minute, cfg.CacheTTL)
+			},
+		},
+		{
+			name: "file is set by env",
+			envs: map[string]string{"CONFIG": jsonPath},
+			want: func(t *testing.T, cfg *Config) {
+				assert.Equal(t, jsonPath, cfg.ConfigPath)
+				assert.Equal(t, ":9001", cfg.ServerAddr)
+				assert.Equal(t, "debug", cfg.LogLevel)
+			},
+		},
+		{
+			name: "flag set to default value still overrides env",
+			args: []string{"-a", ":8080"},
+			envs: map[string]string{"SERVER_ADDRESS": ":9100"},
+			want: func(t *testing.T, cfg *Config) {
+				assert.Equal(t, ":8080", cfg.ServerAddr)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			getenv := func(key string) string { return tt.envs[key] }
+			cfg, err := Load(flag.NewFlagSet("test", flag.ContinueOnError), tt.args, getenv)
+			require.NoError(t, err)
+			tt.want(t, cfg)
+		})
+	}
+}
+
+func Test_LoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	unknownKey := filepath.Join(dir, "unknown.yaml")
+	require.NoError(t, os.WriteFile(unknownKey, []byte("server_adress: \":9000\"\n"), 0644))
+
+	tests := []struct {
+		name string
+		args []string
+		envs map[string]string
+	}{
+		{
+			name: "missing file",
+			args: []string{"-c", filepath.Join(dir, "missing.yaml")},
+		},
+		{
+			name: "unknown key in file",
+			args: []string{"-c", unknownKey},
+		},
+		{
+			name: "invalid env value",
+			envs: map[string]string{"CACHE_TTL": "forever"},
+		},
+		{
+			name: "unknown flag",
+			args: []string{"-unknown"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			getenv := func(key string) string { return tt.envs[key] }
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			_, err := Load(fs, tt.args, getenv)
+			assert.Error(t, err)
+		})
+	}
+}
+
+const testJWTSecret = "0123456789abcdef0123456789abcdef"
+
+func Test_ConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(cfg *Config)
+		wantErr bool
+	}{
+		{
+			name:   "defaults",
+			modify: func(cfg *Config) {},
+		},
+		{
+			name:   "missing JWT secret",
+			modify: func(cfg *Config) { cfg.JWTSecret = "" },
+		},
+		{
+			name:    "former default JWT secret",
+			modify:  func(cfg *Config) { cfg.JWTSecret = "my-secret-key-change-in-production" },
+			wantErr: true,
+		},
+		{
+			name:    "short JWT secret",
+			modify:  func(cfg *Config) { cfg.JWTSecret = "short" },
+			wantErr: true,
+		},
+		{
+			name:   "base URL with path",
+			modify: func(cfg *Config) { cfg.BaseURL = "https://example.com/s" },
+		},
+		{
+			name:    "base URL without scheme",
+			modify:  func(cfg *Config) { cfg.BaseURL = "localhost:8080" },
+			wantErr: true,
+		},
+		{
+			name:    "base URL with query",
+			modify:  func(cfg *Config) { cfg.BaseURL = "http://localhost:8080/?a=b" },
+			wantErr: true,
+		},
+		{
+			name:   "server address with host",
+			modify: func(cfg *Config) { cfg.ServerAddr = "127.0.0.1:8080" },
+		},
+		{
+			name:    "server address without port",
+			modify:  func(cfg *Config) { cfg.ServerAddr = "localhost" },
+			wantErr: true,
+		},
+		{
+			name:    "server address with invalid port",
+			modify:  func(cfg *Config) { cfg.ServerAddr = ":http-alt" },
+			wantErr: true,
+		},
+		{
+			name:    "HTTPS with unsupported TLS version",
+			modify:  func(cfg *Config) { cfg.EnableHTTPS = true; cfg.TLSMinVersion = "1.0" },
+			wantErr: true,
+		},
+		{
+			name: "HTTPS with redirect listener",
+			modify: func(cfg *Config) {
+				cfg.EnableHTTPS = true
+				cfg.ServerAddr = ":443"
+				cfg.HTTPRedirectAddr = ":80"
+			},
+		},
+		{
+			name:    "redirect listener without HTTPS",
+			modify:  func(cfg *Config) { cfg.HTTPRedirectAddr = ":80" },
+			wantErr: true,
+		},
+		{
+			name:    "negative read timeout",
+			modify:  func(cfg *Config) { cfg.ReadTimeout = -time.Second },
+			wantErr: true,
+		},
+		{
+			name:   "no write timeout and connection limit",
+			modify: func(cfg *Config) { cfg.WriteTimeout = 0; cfg.MaxConnections = 0 },
+		},
+		{
+			name:    "negative max connections",
+			modify:  func(cfg *Config) { cfg.MaxConnections = -1 },
+			wantErr: true,
+		},
+		{
+			name:    "zero gRPC message size",
+			modify:  func(cfg *Config) { cfg.GRPCMaxRecvMsgSize = 0 },
+			wantErr: true,
+		},
+		{
+			name:    "negative gRPC call timeout",
+			modify:  func(cfg *Config) { cfg.GRPCCallTimeout = -time.Second },
+			wantErr: true,
+		},
+		{
+			name:    "negative report rate limit",
+			modify:  func(cfg *Config) { cfg.ReportRateLimit = -1 },
+			wantErr: true,
+		},
+		{
+			name:    "zero shutdown timeout",
+			modify:  func(cfg *Config) { cfg.ShutdownTimeout = 0 },
+			wantErr: true,
+		},
+		{
+			name:    "zero max decoded body",
+			modify:  func(cfg *Config) { cfg.MaxDecodedBody = 0 },
+			wantErr: true,
+		},
+		{
+			name:   "compress media types with wildcard",
+			modify: func(cfg *Config) { cfg.CompressTypes = "application/json, text/*" },
+		},
+		{
+			name:    "compress media type with parameters",
+			modify:  func(cfg *Config) { cfg.CompressTypes = "text/html; charset=utf-8" },
+			wantErr: true,
+		},
+		{
+			name:    "negative compress min size",
+			modify:  func(cfg *Config) { cfg.CompressMinSize = -1 },
+			wantErr: true,
+		},
+		{
+			name:   "gRPC listener",
+			modify: func(cfg *Config) { cfg.GRPCAddr = ":3200" },
+		},
+		{
+			name:    "gRPC listener on HTTP address",
+			modify:  func(cfg *Config) { cfg.GRPCAddr = cfg.ServerAddr },
+			wantErr: true,
+		},
+		{
+			name:   "trusted subnet",
+			modify: func(cfg *Config) { cfg.TrustedSubnet = "192.168.0.0/16" },
+		},
+		{
+			name:   "trusted proxies",
+			modify: func(cfg *Config) { cfg.TrustedProxies = "10.0.0.1, 172.16.0.0/12" },
+		},
+		{
+			name:    "invalid trusted proxy",
+			modify:  func(cfg *Config) { cfg.TrustedProxies = "proxy.local" },
+			wantErr: true,
+		},
+		{
+			name:    "trusted subnet without mask",
+			modify:  func(cfg *Config) { cfg.TrustedSubnet = "192.168.0.1" },
+			wantErr: true,
+		},
+		{
+			name:    "unknown log level",
+			modify:  func(cfg *Config) { cfg.LogLevel = "verbose" },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := GetDefaultConfig()
+			cfg.JWTSecret = testJWTSecret
+			tt.modify(cfg)
+			err := cfg.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func Test_ConfigRestartRequired(t *testing.T) {
+	cfg := GetDefaultConfig()
+
+	next := GetDefaultConfig()
+	next.MaxURLLength = 10
+	next.BlocklistPath = "/tmp/blocklist"
+	next.LogLevel = "debug"
+	assert.Empty(t, cfg.RestartRequired(next))
+
+	next.ServerAddr = ":9090"
+	next.CacheSize = 10
+	assert.Equal(t, []string{"server_address", "cache_size"}, cfg.RestartRequired(next))
+}
+
+func Test_ConfigReplicas(t *testing.T) {
+	tests := []struct {
+		name        string
+		replicaDSNs string
+		want        []string
+	}{
+		{
+			name:        "no replicas",
+			replicaDSNs: "",
+			want:        nil,
+		},
+		{
+			name:        "several replicas with spaces and empty items",
+			replicaDSNs: "postgres://replica1/db, postgres://replica2/db,,",
+			want:        []string{"postgres://replica1/db", "postgres://replica2/db"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{ReplicaDSNs: tt.replicaDSNs}
+			assert.Equal(t, tt.want, cfg.Replicas())
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package config
+
+// RestartRequired returns names of changed settings which are applied on start only.
+// Other settings (limits, blocklist, log level) may be applied by reload
+func (c *Config) RestartRequired(next *Config) []string {
+	var changed []string
+	add := func(name string, differ bool) {
+		if differ {
+			changed = append(changed, name)
+		}
+	}
+	add("server_address", c.ServerAddr != next.ServerAddr)
+	add("grpc_address", c.GRPCAddr != next.GRPCAddr)
+	add("grpc_max_recv_msg_size", c.GRPCMaxRecvMsgSize != next.GRPCMaxRecvMsgSize)
+	add("grpc_call_timeout", c.GRPCCallTimeout != next.GRPCCallTimeout)
+	add("base_url", c.BaseURL != next.BaseURL)
+	add("jwt_secret", c.JWTSecret != next.JWTSecret)
+	add("file_storage_path", c.FileStoragePath != next.FileStoragePath)
+	add("database_dsn", c.DSN != next.DSN)
+	add("database_replica_dsns", c.ReplicaDSNs != next.ReplicaDSNs)
+	add("redirect_code", c.RedirectCode != next.RedirectCode)
+	add("query_policy", c.QueryPolicy != next.QueryPolicy)
+	add("geoip_db_path", c.GeoIPPath != next.GeoIPPath)
+	add("cache_size", c.CacheSize != next.CacheSize)
+	add("cache_ttl", c.CacheTTL != next.CacheTTL)
+	add("cache_negative_ttl", c.CacheNegativeTTL != next.CacheNegativeTTL)
+	add("enable_https", c.EnableHTTPS != next.EnableHTTPS)
+	add("tls_cert_file", c.TLSCertPath != next.TLSCertPath)
+	add("tls_key_file", c.TLSKeyPath != next.TLSKeyPath)
+	add("tls_min_version", c.TLSMinVersion != next.TLSMinVersion)
+	add("http_redirect_address", c.HTTPRedirectAddr != next.HTTPRedirectAddr)
+	add("hsts_max_age", c.HSTSMaxAge != next.HSTSMaxAge)
+	add("read_header_timeout", c.ReadHeaderTimeout != next.ReadHeaderTimeout)
+	add("read_timeout", c.ReadTimeout != next.ReadTimeout)
+	add("write_timeout", c.WriteTimeout != next.WriteTimeout)
+	add("idle_timeout", c.IdleTimeout != next.IdleTimeout)
+	add("max_header_bytes", c.MaxHeaderBytes != next.MaxHeaderBytes)
+	add("max_connections", c.MaxConnections != next.MaxConnections)
+	add("shutdown_timeout", c.ShutdownTimeout != next.ShutdownTimeout)
+	add("max_decoded_body", c.MaxDecodedBody != next.MaxDecodedBody)
+	add("compress_types", c.CompressTypes != next.CompressTypes)
+	add("compress_min_size", c.CompressMinSize != next.CompressMinSize)
+	add("trusted_subnet", c.TrustedSubnet != next.TrustedSubnet)
+	add("trusted_proxies", c.TrustedProxies != next.TrustedProxies)
+	add("report_rate_limit", c.ReportRateLimit != next.ReportRateLimit)
+	return changed
+}
@@ -0,0 +1,86 @@
+package geoip
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
+type network struct {
+	prefix  netip.Prefix
+	country string
+}
+
+// DB resolves client IP to ISO 3166-1 alpha-2 country code.
+// It's loaded from local CSV file with "network,country" lines, for example:
+//
+//	# comments and empty lines are skipped
+//	network,country
+//	1.0.0.0/24,AU
+//	2001:200::/32,JP
+//
+// Networks must not overlap, as in GeoLite2 Country blocks
+type DB struct {
+	networks []network
+}
+
+func Open(path string) (*DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	db := &DB{}
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Split(text, ",")
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("geoip: line %d: expected \"network,country\"", line)
+		}
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(fields[0]))
+		if err != nil {
+			// header line
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("geoip: line %d: %w", line, err)
+		}
+
+		db.networks = append(db.networks, network{
+			prefix:  prefix.Masked(),
+			country: strings.ToUpper(strings.TrimSpace(fields[1])),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(db.networks, func(i, j int) bool {
+		return db.networks[i].prefix.Addr().Less(db.networks[j].prefix.Addr())
+	})
+	return db, nil
+}
+
+// Country returns empty string when ip is not in database
+func (db *DB) Country(ip netip.Addr) string {
+	ip = ip.Unmap()
+	// find the last network starting before or at ip
+	i := sort.Search(len(db.networks), func(i int) bool {
+		return ip.Less(db.networks[i].prefix.Addr())
+	}) - 1
+	if i < 0 || !db.networks[i].prefix.Contains(ip) {
+		return ""
+	}
+	return db.networks[i].country
+}
@@ -0,0 +1,53 @@
+package geoip
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DBCountry(t *testing.T) {
+	const data = `network,country
+# test data
+1.0.0.0/24,au
+2.16.0.0/13,DE
+
+2001:200::/32,JP
+`
+	path := filepath.Join(t.TempDir(), "geoip.csv")
+	require.NoError(t, os.WriteFile(path, []byte(data), 0644))
+
+	db, err := Open(path)
+	require.NoError(t, err)
+
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{ip: "1.0.0.1", want: "AU"},
+		{ip: "2.23.255.255", want: "DE"},
+		{ip: "2.24.0.0", want: ""},
+		{ip: "0.0.0.1", want: ""},
+		{ip: "::ffff:1.0.0.200", want: "AU"},
+		{ip: "2001:200::1", want: "JP"},
+		{ip: "2001:201::1", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			assert.Equal(t, tt.want, db.Country(netip.MustParseAddr(tt.ip)))
+		})
+	}
+}
+
+func Test_OpenInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geoip.csv")
+	require.NoError(t, os.WriteFile(path, []byte("1.0.0.0/24,AU\nbroken,DE\n"), 0644))
+
+	_, err := Open(path)
+	assert.Error(t, err)
+}
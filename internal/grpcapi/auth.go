@@ -0,0 +1,52 @@
+package grpcapi
+
+import (
+	"context"
+	"strings"
+
+	"github.com/bissquit/url-shortener/internal/auth"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// authorizationKey carries "Bearer <token>" in request and response metadata
+const authorizationKey = "authorization"
+
+// Authenticate puts user from token into context like auth.JWTAuth does.
+// Missing or invalid token creates a new user, its token is sent in response header
+func Authenticate(ctx context.Context, req any, _ *grpc.UnaryServerInfo, next grpc.UnaryHandler) (any, error) {
+	if token, ok := tokenFromMetadata(ctx); ok {
+		if claims, err := auth.ParseToken(token); err == nil {
+			if claims.UserID == "" {
+				return nil, status.Error(codes.Unauthenticated, "invalid user ID in token")
+			}
+			return next(auth.WithUser(ctx, claims.UserID, claims.Role), req)
+		}
+	}
+
+	userID := uuid.New().String()
+	token, err := auth.BuildToken(userID, "", 0)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := grpc.SetHeader(ctx, metadata.Pairs(authorizationKey, "Bearer "+token)); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return next(auth.WithUser(ctx, userID, ""), req)
+}
+
+func tokenFromMetadata(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	for _, v := range md.Get(authorizationKey) {
+		if token, ok := strings.CutPrefix(v, "Bearer "); ok {
+			return token, true
+		}
+	}
+	return "", false
+}
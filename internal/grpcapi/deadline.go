@@ -0,0 +1,39 @@
+package grpcapi
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Deadline limits every call by timeout, a shorter client deadline wins.
+// Service layer doesn't take context, so late call is answered with DeadlineExceeded
+// and its work is finished in background (storage calls have their own timeouts)
+func Deadline(timeout time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, next grpc.UnaryHandler) (any, error) {
+		if timeout <= 0 {
+			return next(ctx, req)
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		type result struct {
+			resp any
+			err  error
+		}
+		done := make(chan result, 1)
+		go func() {
+			resp, err := next(ctx, req)
+			done <- result{resp: resp, err: err}
+		}()
+
+		select {
+		case r := <-done:
+			return r.resp, r.err
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
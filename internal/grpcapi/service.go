@@ -0,0 +1,120 @@
+// Package grpcapi serves shortener.v1.Shortener, it's an adapter of service.Shortener like HTTP handlers
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/bissquit/url-shortener/internal/auth"
+	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/bissquit/url-shortener/internal/service"
+	pb "github.com/bissquit/url-shortener/pkg/api/shortener/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type Service struct {
+	pb.UnimplementedShortenerServer
+	shortener *service.Shortener
+}
+
+func New(shortener *service.Shortener) *Service {
+	return &Service{shortener: shortener}
+}
+
+// Register adds service, its authentication and call timeout (see Deadline) to a new server
+func Register(shortener *service.Shortener, callTimeout time.Duration, opts ...grpc.ServerOption) *grpc.Server {
+	srv := grpc.NewServer(append(opts, grpc.ChainUnaryInterceptor(Authenticate, Deadline(callTimeout)))...)
+	pb.RegisterShortenerServer(srv, New(shortener))
+	return srv
+}
+
+func (s *Service) Shorten(ctx context.Context, req *pb.ShortenRequest) (*pb.ShortenResponse, error) {
+	var settings repository.LinkSettings
+	if st := req.GetSettings(); st != nil {
+		settings = repository.LinkSettings{
+			RedirectCode: int(st.GetRedirectCode()),
+			Preview:      st.GetPreview(),
+			QueryPolicy:  st.GetQueryPolicy(),
+			UTM:          st.GetUtm(),
+		}
+	}
+	resp, err := s.shortener.Shorten(service.ShortenRequest{UserID: userID(ctx), URL: req.GetUrl(), Settings: settings})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.ShortenResponse{ShortUrl: resp.ShortURL, Created: resp.Created}, nil
+}
+
+func (s *Service) ShortenBatch(ctx context.Context, req *pb.ShortenBatchRequest) (*pb.ShortenBatchResponse, error) {
+	items := make([]repository.BatchItemInput, 0, len(req.GetItems()))
+	for _, it := range req.GetItems() {
+		items = append(items, repository.BatchItemInput{
+			CorrelationID: it.GetCorrelationId(),
+			OriginalURL:   it.GetOriginalUrl(),
+		})
+	}
+	result, err := s.shortener.ShortenBatch(service.ShortenBatchRequest{UserID: userID(ctx), Items: items})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &pb.ShortenBatchResponse{Items: make([]*pb.BatchResult, 0, len(result.Items))}
+	for _, it := range result.Items {
+		resp.Items = append(resp.Items, &pb.BatchResult{CorrelationId: it.CorrelationID, ShortUrl: it.ShortURL})
+	}
+	return resp, nil
+}
+
+func (s *Service) Expand(_ context.Context, req *pb.ExpandRequest) (*pb.ExpandResponse, error) {
+	originalURL, err := s.shortener.Expand(req.GetId())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.ExpandResponse{OriginalUrl: originalURL}, nil
+}
+
+func (s *Service) ListUserURLs(ctx context.Context, _ *pb.ListUserURLsRequest) (*pb.ListUserURLsResponse, error) {
+	urls, err := s.shortener.UserURLs(userID(ctx))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &pb.ListUserURLsResponse{Urls: make([]*pb.UserURL, 0, len(urls))}
+	for _, u := range urls {
+		resp.Urls = append(resp.Urls, &pb.UserURL{ShortUrl: u.ShortURL, OriginalUrl: u.OriginalURL})
+	}
+	return resp, nil
+}
+
+func (s *Service) DeleteUserURLs(ctx context.Context, req *pb.DeleteUserURLsRequest) (*pb.DeleteUserURLsResponse, error) {
+	s.shortener.DeleteURLs(userID(ctx), req.GetIds())
+	return &pb.DeleteUserURLsResponse{}, nil
+}
+
+// userID is always set by Authenticate
+func userID(ctx context.Context) string {
+	id, _ := auth.GetUserIDFromContext(ctx)
+	return id
+}
+
+// toStatus maps errors of service.Shortener like writeServiceError does for HTTP
+func toStatus(err error) error {
+	var invalid *service.ValidationError
+	switch {
+	case errors.As(err, &invalid):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, service.ErrURLConflict):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, service.ErrNotFound), errors.Is(err, service.ErrDeleted):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, service.ErrBlocked):
+		return status.Error(codes.PermissionDenied, err.Error())
+	default:
+		log.Printf("ERROR: grpc: %v", err)
+		return status.Error(codes.Internal, "internal error")
+	}
+}
@@ -0,0 +1,209 @@
+package grpcapi
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bissquit/url-shortener/internal/auth"
+	"github.com/bissquit/url-shortener/internal/repository/memory"
+	"github.com/bissquit/url-shortener/internal/service"
+	"github.com/bissquit/url-shortener/internal/service/crypto"
+	"github.com/bissquit/url-shortener/internal/service/urlcheck"
+	pb "github.com/bissquit/url-shortener/pkg/api/shortener/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const baseURL = "http://localhost:8080"
+
+func newClient(t *testing.T) (pb.ShortenerClient, *service.Shortener) {
+	t.Helper()
+	checker := urlcheck.New(baseURL, urlcheck.DefaultSchemes, urlcheck.DefaultMaxLength)
+	shortener := service.NewShortener(memory.NewURLStorage(), baseURL, crypto.NewRandomGenerator(), checker)
+	srv := Register(shortener, 0)
+	ln := bufconn.Listen(1 << 20)
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return pb.NewShortenerClient(conn), shortener
+}
+
+func withToken(t *testing.T, userID string) context.Context {
+	t.Helper()
+	token, err := auth.BuildToken(userID, "", 0)
+	require.NoError(t, err)
+	return metadata.AppendToOutgoingContext(context.Background(), authorizationKey, "Bearer "+token)
+}
+
+func Test_ServiceShorten(t *testing.T) {
+	client, _ := newClient(t)
+	ctx := withToken(t, "user-1")
+
+	resp, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "https://example.com"})
+	require.NoError(t, err)
+	assert.True(t, resp.GetCreated())
+	assert.Contains(t, resp.GetShortUrl(), baseURL+"/")
+
+	// the same URL returns existing link like 409 in HTTP API
+	again, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "https://example.com"})
+	require.NoError(t, err)
+	assert.False(t, again.GetCreated())
+	assert.Equal(t, resp.GetShortUrl(), again.GetShortUrl())
+
+	tests := []struct {
+		name string
+		req  *pb.ShortenRequest
+	}{
+		{
+			name: "empty URL",
+			req:  &pb.ShortenRequest{},
+		},
+		{
+			name: "scheme is not allowed",
+			req:  &pb.ShortenRequest{Url: "javascript:alert(1)"},
+		},
+		{
+			name: "unsupported redirect code",
+			req:  &pb.ShortenRequest{Url: "https://example.org", Settings: &pb.LinkSettings{RedirectCode: 200}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.Shorten(ctx, tt.req)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		})
+	}
+}
+
+func Test_ServiceShortenBatch(t *testing.T) {
+	client, _ := newClient(t)
+	ctx := withToken(t, "user-1")
+
+	resp, err := client.ShortenBatch(ctx, &pb.ShortenBatchRequest{Items: []*pb.BatchItem{
+		{CorrelationId: "1", OriginalUrl: "https://example.com/1"},
+		{CorrelationId: "2", OriginalUrl: "https://example.com/2"},
+	}})
+	require.NoError(t, err)
+	require.Len(t, resp.GetItems(), 2)
+	assert.Equal(t, "1", resp.GetItems()[0].GetCorrelationId())
+	assert.Equal(t, "2", resp.GetItems()[1].GetCorrelationId())
+
+	_, err = client.ShortenBatch(ctx, &pb.ShortenBatchRequest{Items: []*pb.BatchItem{
+		{CorrelationId: "3", OriginalUrl: "https://example.com/1"},
+	}})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	_, err = client.ShortenBatch(ctx, &pb.ShortenBatchRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func Test_ServiceUserURLs(t *testing.T) {
+	client, shortener := newClient(t)
+
+	// a new user gets token in response header
+	var header metadata.MD
+	created, err := client.Shorten(context.Background(), &pb.ShortenRequest{Url: "https://example.com"}, grpc.Header(&header))
+	require.NoError(t, err)
+	require.Len(t, header.Get(authorizationKey), 1)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), authorizationKey, header.Get(authorizationKey)[0])
+
+	list, err := client.ListUserURLs(ctx, &pb.ListUserURLsRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.GetUrls(), 1)
+	assert.Equal(t, created.GetShortUrl(), list.GetUrls()[0].GetShortUrl())
+	assert.Equal(t, "https://example.com", list.GetUrls()[0].GetOriginalUrl())
+
+	// other user doesn't see and cannot delete the link
+	other := withToken(t, "user-2")
+	list, err = client.ListUserURLs(other, &pb.ListUserURLsRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, list.GetUrls())
+
+	id := created.GetShortUrl()[len(baseURL)+1:]
+	_, err = client.DeleteUserURLs(other, &pb.DeleteUserURLsRequest{Ids: []string{id}})
+	require.NoError(t, err)
+	require.NoError(t, shortener.Wait(context.Background()))
+	expanded, err := client.Expand(ctx, &pb.ExpandRequest{Id: id})
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", expanded.GetOriginalUrl())
+
+	_, err = client.DeleteUserURLs(ctx, &pb.DeleteUserURLsRequest{Ids: []string{id}})
+	require.NoError(t, err)
+	require.NoError(t, shortener.Wait(context.Background()))
+	_, err = client.Expand(ctx, &pb.ExpandRequest{Id: id})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.Expand(ctx, &pb.ExpandRequest{Id: "unknown"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func Test_AuthenticateInvalidUser(t *testing.T) {
+	client, _ := newClient(t)
+
+	// token is valid but has no user like in HTTP API
+	_, err := client.ListUserURLs(withToken(t, ""), &pb.ListUserURLsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func Test_Deadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	slow := func(ctx context.Context, _ any) (any, error) {
+		<-release
+		return "late", nil
+	}
+	fast := func(ctx context.Context, _ any) (any, error) {
+		_, ok := ctx.Deadline()
+		return ok, nil
+	}
+
+	tests := []struct {
+		name     string
+		timeout  time.Duration
+		handler  grpc.UnaryHandler
+		wantResp any
+		wantCode codes.Code
+	}{
+		{
+			name:     "call in time gets deadline",
+			timeout:  time.Minute,
+			handler:  fast,
+			wantResp: true,
+			wantCode: codes.OK,
+		},
+		{
+			name:     "zero timeout means no deadline",
+			timeout:  0,
+			handler:  fast,
+			wantResp: false,
+			wantCode: codes.OK,
+		},
+		{
+			name:     "late call",
+			timeout:  10 * time.Millisecond,
+			handler:  slow,
+			wantCode: codes.DeadlineExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := Deadline(tt.timeout)(context.Background(), nil, &grpc.UnaryServerInfo{}, tt.handler)
+			assert.Equal(t, tt.wantCode, status.Code(err))
+			assert.Equal(t, tt.wantResp, resp)
+		})
+	}
+}
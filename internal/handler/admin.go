@@ -0,0 +1,367 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/bissquit/url-shortener/internal/auth"
+	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/bissquit/url-shortener/internal/service/transfer"
+	"github.com/bissquit/url-shortener/internal/service/urlcheck"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultSearchLimit = 100
+	maxSearchLimit     = 1000
+	// maxImportSize limits request body of import
+	maxImportSize = 64 << 20
+	// maxRestoreSize limits request body of restore
+	maxRestoreSize = 1 << 30
+)
+
+// AdminHandlers serve /api/admin and are not scoped by user,
+// access is checked by auth.RequireAdmin middleware
+type AdminHandlers struct {
+	storage repository.URLRepository
+	baseURL string
+	// Checker validates imported URLs, nil turns the check off
+	Checker *urlcheck.Checker
+	// SchemaVersion returns current storage schema version for backup and restore
+	SchemaVersion func() (uint, error)
+}
+
+func NewAdminHandlers(storage repository.URLRepository, baseURL string) *AdminHandlers {
+	return &AdminHandlers{
+		storage: storage,
+		baseURL: baseURL,
+	}
+}
+
+type adminLinkResponse struct {
+	ShortID     string `json:"short_id"`
+	ShortURL    string `json:"short_url"`
+	OriginalURL string `json:"original_url"`
+	UserID      string `json:"user_id"`
+	Deleted     bool   `json:"is_deleted"`
+	Banned      bool   `json:"is_banned"`
+}
+
+func (h *AdminHandlers) linkResponse(link repository.Link) (adminLinkResponse, error) {
+	shortURL, err := url.JoinPath(h.baseURL, link.ShortID)
+	if err != nil {
+		return adminLinkResponse{}, err
+	}
+	return adminLinkResponse{
+		ShortID:     link.ShortID,
+		ShortURL:    shortURL,
+		OriginalURL: link.OriginalURL,
+		UserID:      link.UserID,
+		Deleted:     link.Deleted,
+		Banned:      link.Banned,
+	}, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("ERROR: cannot encode response: %v", err)
+	}
+}
+
+// SearchLinks accepts q, user_id, deleted, limit and offset query parameters
+func (h *AdminHandlers) SearchLinks(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := repository.LinkFilter{
+		Query:          query.Get("q"),
+		UserID:         query.Get("user_id"),
+		IncludeDeleted: query.Get("deleted") == "true",
+		Limit:          defaultSearchLimit,
+	}
+
+	var err error
+	if v := query.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit <= 0 || filter.Limit > maxSearchLimit {
+			BadRequest(w, "limit must be in range 1-"+strconv.Itoa(maxSearchLimit))
+			return
+		}
+	}
+	if v := query.Get("offset"); v != "" {
+		if filter.Offset, err = strconv.Atoi(v); err != nil || filter.Offset < 0 {
+			BadRequest(w, "offset must be non-negative")
+			return
+		}
+	}
+
+	links, err := h.storage.SearchLinks(filter)
+	if err != nil {
+		log.Printf("ERROR: cannot search links: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]adminLinkResponse, 0, len(links))
+	for _, link := range links {
+		item, err := h.linkResponse(link)
+		if err != nil {
+			log.Printf("ERROR: cannot build short url for %s: %v", link.ShortID, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		resp = append(resp, item)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// GetLink shows a link with its owner
+func (h *AdminHandlers) GetLink(w http.ResponseWriter, r *http.Request) {
+	link, err := h.storage.GetLink(chi.URLParam(r, "id"))
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	resp, err := h.linkResponse(link)
+	if err != nil {
+		log.Printf("ERROR: cannot build short url for %s: %v", link.ShortID, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *AdminHandlers) ForceDelete(w http.ResponseWriter, r *http.Request) {
+	if err := h.storage.ForceDelete(chi.URLParam(r, "id")); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandlers) Ban(w http.ResponseWriter, r *http.Request) {
+	h.setBanned(w, r, true)
+}
+
+func (h *AdminHandlers) Unban(w http.ResponseWriter, r *http.Request) {
+	h.setBanned(w, r, false)
+}
+
+func (h *AdminHandlers) setBanned(w http.ResponseWriter, r *http.Request, banned bool) {
+	if err := h.storage.SetBanned(chi.URLParam(r, "id"), banned); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DisableUser deletes all links of a user
+func (h *AdminHandlers) DisableUser(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userID")
+	n, err := h.storage.DeleteByUserID(userID)
+	if err != nil {
+		log.Printf("ERROR: cannot disable links of user %s: %v", userID, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Disabled int64 `json:"disabled"`
+	}{Disabled: n})
+}
+
+func (h *AdminHandlers) Stats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.storage.GetStats()
+	if err != nil {
+		log.Printf("ERROR: cannot get stats: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// InternalStats is a short summary for internal services: live links and their distinct owners
+func (h *AdminHandlers) InternalStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.storage.GetStats()
+	if err != nil {
+		log.Printf("ERROR: cannot get stats: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		URLs  int64 `json:"urls"`
+		Users int64 `json:"users"`
+	}{URLs: stats.Live, Users: stats.Users})
+}
+
+// GetReports lists reports with status from query (open by default, "all" for any)
+func (h *AdminHandlers) GetReports(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = repository.ReportOpen
+	case "all":
+		status = ""
+	case repository.ReportOpen, repository.ReportBlocked, repository.ReportDismissed:
+	default:
+		BadRequest(w, "unknown report status")
+		return
+	}
+
+	reports, err := h.storage.GetReports(status)
+	if err != nil {
+		log.Printf("ERROR: cannot get reports: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, reports)
+}
+
+type requestResolveReport struct {
+	Status string `json:"status"`
+}
+
+// ResolveReport closes report as blocked (the link is banned) or dismissed
+func (h *AdminHandlers) ResolveReport(w http.ResponseWriter, r *http.Request) {
+	reportID, err := strconv.ParseInt(chi.URLParam(r, "reportID"), 10, 64)
+	if err != nil {
+		BadRequest(w, "wrong report id")
+		return
+	}
+
+	var body requestResolveReport
+	if !decodeJSONBody(w, r, &body) {
+		return
+	}
+	if body.Status != repository.ReportBlocked && body.Status != repository.ReportDismissed {
+		BadRequest(w, "status must be blocked or dismissed")
+		return
+	}
+
+	report, err := h.storage.ResolveReport(reportID, body.Status)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// Import stores links from CSV or NDJSON body with their own short IDs and owners.
+// Format is taken from "format" query parameter or Content-Type,
+// rows without user_id are owned by admin, "dry_run=true" only validates rows
+func (h *AdminHandlers) Import(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case "text/csv":
+			format = transfer.FormatCSV
+		case "application/x-ndjson":
+			format = transfer.FormatNDJSON
+		}
+	}
+	if format != transfer.FormatCSV && format != transfer.FormatNDJSON {
+		BadRequest(w, "format must be csv or ndjson")
+		return
+	}
+
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+	extendDeadlines(w)
+	result, err := transfer.Import(r.Context(), h.storage, http.MaxBytesReader(w, r.Body, maxImportSize),
+		transfer.ImportOptions{
+			Format:        format,
+			DryRun:        r.URL.Query().Get("dry_run") == "true",
+			DefaultUserID: userID,
+			Checker:       h.Checker,
+		})
+	if err != nil {
+		// chunks stored before the error are kept, so they are reported too
+		log.Printf("ERROR: import is interrupted after %d links: %v", result.Imported, err)
+		resp := importErrorResponse{Error: http.StatusText(http.StatusInternalServerError), ImportResult: result}
+		status := http.StatusInternalServerError
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesErr):
+			status = http.StatusRequestEntityTooLarge
+			resp.Error = http.StatusText(status)
+		case errors.Is(err, transfer.ErrBadInput):
+			status = http.StatusBadRequest
+			resp.Error = err.Error()
+		}
+		writeJSON(w, status, resp)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// importErrorResponse is a result of interrupted import
+type importErrorResponse struct {
+	Error string `json:"error"`
+	transfer.ImportResult
+}
+
+func (h *AdminHandlers) schemaVersion() (uint, error) {
+	if h.SchemaVersion == nil {
+		return 0, errors.New("schema version is not set")
+	}
+	return h.SchemaVersion()
+}
+
+// Backup streams archive of all links, server keeps working meanwhile
+func (h *AdminHandlers) Backup(w http.ResponseWriter, r *http.Request) {
+	version, err := h.schemaVersion()
+	if err != nil {
+		log.Printf("ERROR: cannot get schema version: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	extendDeadlines(w)
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition",
+		`attachment; filename="backup-`+time.Now().UTC().Format("20060102T150405Z")+`.ndjson.gz"`)
+	w.WriteHeader(http.StatusOK)
+
+	// status is already sent, so client detects failure by missing end of archive
+	if n, err := transfer.Backup(r.Context(), h.storage, w, version); err != nil {
+		log.Printf("ERROR: backup is interrupted after %d links: %v", n, err)
+	}
+}
+
+// Restore puts links from archive into empty storage
+func (h *AdminHandlers) Restore(w http.ResponseWriter, r *http.Request) {
+	version, err := h.schemaVersion()
+	if err != nil {
+		log.Printf("ERROR: cannot get schema version: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	extendDeadlines(w)
+	n, err := transfer.Restore(r.Context(), h.storage, http.MaxBytesReader(w, r.Body, maxRestoreSize), version, 0)
+	if err != nil {
+		log.Printf("ERROR: restore failed, nothing is stored: %v", err)
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesErr):
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		case errors.Is(err, transfer.ErrNotEmpty), errors.Is(err, transfer.ErrSchemaMismatch):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, transfer.ErrBadArchive):
+			BadRequest(w, err.Error())
+		default:
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Restored int64 `json:"restored"`
+	}{Restored: n})
+}
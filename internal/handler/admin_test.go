@@ -0,0 +1,315 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bissquit/url-shortener/internal/auth"
+	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/bissquit/url-shortener/internal/repository/memory"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newAdminRouter(storage repository.URLRepository) http.Handler {
+	a := NewAdminHandlers(storage, "http://localhost:8080")
+	a.SchemaVersion = func() (uint, error) { return 11, nil }
+	r := chi.NewRouter()
+	r.Use(auth.JWTAuth)
+	r.Route("/api/admin", func(r chi.Router) {
+		r.Use(auth.RequireAdmin)
+		r.Get("/urls", a.SearchLinks)
+		r.Get("/urls/{id}", a.GetLink)
+		r.Delete("/urls/{id}", a.ForceDelete)
+		r.Post("/urls/{id}/ban", a.Ban)
+		r.Delete("/urls/{id}/ban", a.Unban)
+		r.Post("/users/{userID}/disable", a.DisableUser)
+		r.Get("/stats", a.Stats)
+		r.Get("/reports", a.GetReports)
+		r.Post("/reports/{reportID}/resolve", a.ResolveReport)
+		r.Post("/import", a.Import)
+		r.Get("/backup", a.Backup)
+		r.Post("/restore", a.Restore)
+	})
+	return r
+}
+
+func Test_AdminHandlers(t *testing.T) {
+	adminToken, err := auth.BuildToken("admin", auth.RoleAdmin, 0)
+	require.NoError(t, err)
+	userToken, err := auth.BuildToken("user", "", 0)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		token      string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "regular user is forbidden",
+			method:     http.MethodGet,
+			target:     "/api/admin/urls",
+			token:      userToken,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "anonymous is forbidden",
+			method:     http.MethodGet,
+			target:     "/api/admin/stats",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "search by url substring",
+			method:     http.MethodGet,
+			target:     "/api/admin/urls?q=EXAMPLE.org",
+			token:      adminToken,
+			wantStatus: http.StatusOK,
+			wantBody: `[{"short_id":"admin0002","short_url":"http://localhost:8080/admin0002",` +
+				`"original_url":"https://example.org","user_id":"owner2","is_deleted":false,"is_banned":false}]`,
+		},
+		{
+			name:       "search by user",
+			method:     http.MethodGet,
+			target:     "/api/admin/urls?user_id=owner1&limit=1",
+			token:      adminToken,
+			wantStatus: http.StatusOK,
+			wantBody: `[{"short_id":"admin0001","short_url":"http://localhost:8080/admin0001",` +
+				`"original_url":"https://example.com","user_id":"owner1","is_deleted":false,"is_banned":false}]`,
+		},
+		{
+			name:       "invalid limit",
+			method:     http.MethodGet,
+			target:     "/api/admin/urls?limit=100000",
+			token:      adminToken,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "get link of any user",
+			method:     http.MethodGet,
+			target:     "/api/admin/urls/admin0002",
+			token:      adminToken,
+			wantStatus: http.StatusOK,
+			wantBody: `{"short_id":"admin0002","short_url":"http://localhost:8080/admin0002",` +
+				`"original_url":"https://example.org","user_id":"owner2","is_deleted":false,"is_banned":false}`,
+		},
+		{
+			name:       "get unexisted link",
+			method:     http.MethodGet,
+			target:     "/api/admin/urls/unexisted",
+			token:      adminToken,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "force delete",
+			method:     http.MethodDelete,
+			target:     "/api/admin/urls/admin0001",
+			token:      adminToken,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "ban",
+			method:     http.MethodPost,
+			target:     "/api/admin/urls/admin0001/ban",
+			token:      adminToken,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "ban unexisted link",
+			method:     http.MethodPost,
+			target:     "/api/admin/urls/unexisted/ban",
+			token:      adminToken,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "disable user",
+			method:     http.MethodPost,
+			target:     "/api/admin/users/owner1/disable",
+			token:      adminToken,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"disabled":2}`,
+		},
+		{
+			name:       "stats",
+			method:     http.MethodGet,
+			target:     "/api/admin/stats",
+			token:      adminToken,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"total":3,"live":3,"deleted":0,"banned":0,"users":2}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := memory.NewURLStorage()
+			require.NoError(t, storage.Create("admin0001", "https://example.com", "owner1", repository.LinkSettings{}))
+			require.NoError(t, storage.Create("admin0002", "https://example.org", "owner2", repository.LinkSettings{}))
+			require.NoError(t, storage.Create("admin0003", "https://example.net", "owner1", repository.LinkSettings{}))
+
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			newAdminRouter(storage).ServeHTTP(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, tt.wantStatus, res.StatusCode)
+			if tt.wantBody != "" {
+				assert.JSONEq(t, tt.wantBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func Test_AdminBanBlocksRedirect(t *testing.T) {
+	adminToken, err := auth.BuildToken("admin", auth.RoleAdmin, 0)
+	require.NoError(t, err)
+
+	storage := memory.NewURLStorage()
+	require.NoError(t, storage.Create("banned001", "https://example.com", "owner", repository.LinkSettings{}))
+
+	r := httptest.NewRequest(http.MethodPost, "/api/admin/urls/banned001/ban", nil)
+	r.Header.Set("Authorization", "Bearer "+adminToken)
+	w := httptest.NewRecorder()
+	newAdminRouter(storage).ServeHTTP(w, r)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	link, err := storage.GetLink("banned001")
+	require.NoError(t, err)
+	assert.True(t, link.Banned)
+
+	r = httptest.NewRequest(http.MethodGet, "/api/admin/urls?q=banned001", nil)
+	r.Header.Set("Authorization", "Bearer "+adminToken)
+	w = httptest.NewRecorder()
+	newAdminRouter(storage).ServeHTTP(w, r)
+	var links []adminLinkResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &links))
+	require.Len(t, links, 1)
+	assert.True(t, links[0].Banned)
+}
+
+func Test_AdminImport(t *testing.T) {
+	adminToken, err := auth.BuildToken("admin", auth.RoleAdmin, 0)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		target      string
+		contentType string
+		body        string
+		wantStatus  int
+		wantBody    string
+		wantStored  bool
+	}{
+		{
+			name:        "csv by content type",
+			target:      "/api/admin/import",
+			contentType: "text/csv",
+			body:        "short_id,original_url,user_id\nimport01,https://example.org,user1\nimport02,https://example.com,\n",
+			wantStatus:  http.StatusOK,
+			wantBody: `{"dry_run":false,"total":2,"imported":1,"failed":1,` +
+				`"errors":[{"row":2,"short_id":"import02","error":"URL already exists: https://example.com"}]}`,
+			wantStored: true,
+		},
+		{
+			name:       "ndjson dry run",
+			target:     "/api/admin/import?format=ndjson&dry_run=true",
+			body:       `{"short_id":"import01","original_url":"https://example.org"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"dry_run":true,"total":1,"imported":1,"failed":0}`,
+		},
+		{
+			name:       "unknown format",
+			target:     "/api/admin/import",
+			body:       "short_id,original_url\n",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "broken ndjson reports result",
+			target:     "/api/admin/import?format=ndjson",
+			body:       `{"short_id":"bad id","original_url":"https://example.org"}` + "\n" + `{"short_id"`,
+			wantStatus: http.StatusBadRequest,
+			wantBody: `{"error":"bad input: row 2: unexpected EOF","dry_run":false,"total":1,"imported":0,"failed":1,` +
+				`"errors":[{"row":1,"short_id":"bad id","error":"short_id must be up to 64 letters, digits, '-' or '_'"}]}`,
+		},
+		{
+			name:        "broken header",
+			target:      "/api/admin/import?format=csv",
+			contentType: "text/csv",
+			body:        "id,url\n",
+			wantStatus:  http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := memory.NewURLStorage()
+			require.NoError(t, storage.Create("admin0001", "https://example.com", "owner1", repository.LinkSettings{}))
+
+			r := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			r.Header.Set("Authorization", "Bearer "+adminToken)
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			newAdminRouter(storage).ServeHTTP(w, r)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantBody != "" {
+				assert.JSONEq(t, tt.wantBody, w.Body.String())
+			}
+			_, err := storage.GetLink("import01")
+			assert.Equal(t, tt.wantStored, err == nil)
+		})
+	}
+}
+
+func Test_AdminBackupRestore(t *testing.T) {
+	adminToken, err := auth.BuildToken("admin", auth.RoleAdmin, 0)
+	require.NoError(t, err)
+
+	source := memory.NewURLStorage()
+	require.NoError(t, source.Create("backup001", "https://example.com", "owner1", repository.LinkSettings{}))
+	require.NoError(t, source.SetBanned("backup001", true))
+	require.NoError(t, source.Create("backup002", "https://example.org", "owner2", repository.LinkSettings{}))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/admin/backup", nil)
+	r.Header.Set("Authorization", "Bearer "+adminToken)
+	w := httptest.NewRecorder()
+	newAdminRouter(source).ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/gzip", w.Header().Get("Content-Type"))
+	archive := w.Body.Bytes()
+
+	target := memory.NewURLStorage()
+	for _, wantStatus := range []int{http.StatusOK, http.StatusConflict} {
+		r = httptest.NewRequest(http.MethodPost, "/api/admin/restore", bytes.NewReader(archive))
+		r.Header.Set("Authorization", "Bearer "+adminToken)
+		w = httptest.NewRecorder()
+		newAdminRouter(target).ServeHTTP(w, r)
+		require.Equal(t, wantStatus, w.Code)
+	}
+
+	link, err := target.GetLink("backup001")
+	require.NoError(t, err)
+	assert.Equal(t, "owner1", link.UserID)
+	assert.True(t, link.Banned)
+	_, err = target.GetLink("backup002")
+	assert.NoError(t, err)
+
+	// not an archive
+	r = httptest.NewRequest(http.MethodPost, "/api/admin/restore", strings.NewReader("backup"))
+	r.Header.Set("Authorization", "Bearer "+adminToken)
+	w = httptest.NewRecorder()
+	newAdminRouter(memory.NewURLStorage()).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -2,15 +2,15 @@ package handler
 
 import (
 	"encoding/json"
-	"errors"
 	"io"
 	"log"
 	"mime"
 	"net/http"
-	"net/url"
+	"strings"
 
 	"github.com/bissquit/url-shortener/internal/auth"
 	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/bissquit/url-shortener/internal/service"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -31,26 +31,27 @@ func (h *URLHandlers) CreateJSON(w http.ResponseWriter, r *http.Request) {
 		BadRequest(w, "Cannot read request body")
 		return
 	}
-	if err := validateURL(body.URL); err != nil {
-		BadRequest(w, err.Error())
-		return
+	settings := repository.LinkSettings{
+		RedirectCode: body.RedirectCode,
+		Preview:      body.Preview,
+		QueryPolicy:  body.QueryPolicy,
+		UTM:          body.UTM,
 	}
 
 	// MiddleWare guarantees userID is always set
 	userID, _ := auth.GetUserIDFromContext(r.Context())
-	shortURL, created, err := generateAndStoreShortURL(body.URL, h, userID)
+	resp, err := h.Shortener.Shorten(service.ShortenRequest{UserID: userID, URL: body.URL, Settings: settings})
 	if err != nil {
-		log.Println(err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeServiceError(w, err)
 		return
 	}
 
 	status := http.StatusConflict
-	if created {
+	if resp.Created {
 		status = http.StatusCreated
 	}
 
-	payload := responseURL{Result: shortURL}
+	payload := responseURL{Result: resp.ShortURL}
 	b, err := json.Marshal(payload)
 	if err != nil {
 		log.Printf("ERROR: cannot marshal response payload: %v", err)
@@ -83,119 +84,26 @@ func (h *URLHandlers) CreateBatch(w http.ResponseWriter, r *http.Request) {
 		BadRequest(w, "Cannot read request body")
 		return
 	}
-	if len(body) == 0 {
-		BadRequest(w, "Empty request body")
+	// MiddleWare guarantees userID is always set
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+	resp, err := h.Shortener.ShortenBatch(service.ShortenBatchRequest{UserID: userID, Items: body})
+	if err != nil {
+		writeServiceError(w, err)
 		return
 	}
 
-	// return if even one url is invalid
-	for _, item := range body {
-		if err := validateURL(item.OriginalURL); err != nil {
-			BadRequest(w, "invalid URL in a batch: "+item.OriginalURL)
-			return
-		}
+	b, err := json.Marshal(resp.Items)
+	if err != nil {
+		log.Printf("ERROR: cannot marshal response payload: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
 	}
 
-	const (
-		maxBatchAttempts = 10
-		maxIDAttempts    = 10
-	)
-
-	for attempt := 0; attempt < maxBatchAttempts; attempt++ {
-		// 1) Собираем batch + payload (только генерация, без обращений к storage)
-		seenIDs := make(map[string]struct{}, len(body))
-
-		batch := make([]repository.URLItem, 0, len(body))
-		payload := make([]repository.BatchItemOutput, 0, len(body))
-
-		for _, item := range body {
-			// генерируем id, уникальный внутри запроса
-			var id string
-			unique := false
-
-			for i := 0; i < maxIDAttempts; i++ {
-				id, err = h.generator.GenerateShortID()
-				if err != nil {
-					log.Printf("cannot generate shorten ID: %v", err)
-					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-					return
-				}
-				if id == "" {
-					log.Printf("ERROR: generator returned empty id")
-					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-					return
-				}
-				if _, ok := seenIDs[id]; ok {
-					continue
-				}
-				unique = true
-				break
-			}
-
-			if !unique {
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-				return
-			}
-
-			seenIDs[id] = struct{}{}
-
-			shortURL, err := url.JoinPath(h.baseURL, id)
-			if err != nil {
-				log.Printf("cannot build shorten URL (baseURL=%q, id=%q): %v", h.baseURL, id, err)
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-				return
-			}
-
-			payload = append(payload, repository.BatchItemOutput{
-				CorrelationID: item.CorrelationID,
-				ShortURL:      shortURL,
-			})
-			batch = append(batch, repository.URLItem{
-				ID:          id,
-				OriginalURL: item.OriginalURL,
-			})
-		}
-
-		// MiddleWare guarantees userID is always set
-		userID, _ := auth.GetUserIDFromContext(r.Context())
-
-		// 2) Пытаемся вставить целиком
-		err = h.storage.CreateBatch(batch, userID)
-
-		switch {
-		case err == nil:
-			b, err := json.Marshal(payload)
-			if err != nil {
-				log.Printf("ERROR: cannot marshal response payload: %v", err)
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-				return
-			}
-
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusCreated)
-			if _, err := w.Write(b); err != nil {
-				log.Printf("ERROR: cannot write response body: %v", err)
-			}
-			return
-
-		case errors.Is(err, repository.ErrIDAlreadyExists):
-			// коллизия short_id → просто повторяем весь батч с новыми id
-			log.Printf("INFO: short_id collision in batch attempt %d/%d: %v", attempt+1, maxBatchAttempts, err)
-			continue
-
-		case errors.Is(err, repository.ErrURLAlreadyExists):
-			// уже существующий original_url → не ретраим, иначе будет вечный цикл
-			http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
-			return
-
-		default:
-			log.Printf("ERROR: cannot insert batch: %v", err)
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			return
-		}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if _, err := w.Write(b); err != nil {
+		log.Printf("ERROR: cannot write response body: %v", err)
 	}
-
-	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
 
 func (h *URLHandlers) Create(w http.ResponseWriter, r *http.Request) {
@@ -215,28 +123,22 @@ func (h *URLHandlers) Create(w http.ResponseWriter, r *http.Request) {
 		BadRequest(w, "Cannot read request body")
 		return
 	}
-	if err := validateURL(string(body)); err != nil {
-		BadRequest(w, err.Error())
-		return
-	}
-
 	// MiddleWare guarantees userID is always set
 	userID, _ := auth.GetUserIDFromContext(r.Context())
-	shortURL, created, err := generateAndStoreShortURL(string(body), h, userID)
+	resp, err := h.Shortener.Shorten(service.ShortenRequest{UserID: userID, URL: string(body)})
 	if err != nil {
-		log.Println(err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeServiceError(w, err)
 		return
 	}
 
 	status := http.StatusConflict
-	if created {
+	if resp.Created {
 		status = http.StatusCreated
 	}
 
 	w.Header().Set("Content-Type", "text/plain")
 	w.WriteHeader(status)
-	w.Write([]byte(shortURL))
+	w.Write([]byte(resp.ShortURL))
 }
 
 func (h *URLHandlers) Redirect(w http.ResponseWriter, r *http.Request) {
@@ -249,23 +151,103 @@ func (h *URLHandlers) Redirect(w http.ResponseWriter, r *http.Request) {
 		id = r.URL.Path[1:]
 	}
 
+	// "/{id}+" asks for a preview page instead of redirect
+	preview := strings.HasSuffix(id, "+")
+	id = strings.TrimSuffix(id, "+")
+
 	if id == "" {
 		BadRequest(w, "Invalid Path")
 		return
 	}
 
-	originalURL, err := h.storage.GetURLByID(id)
-	if err == repository.ErrDeleted {
+	link, err := h.storage.GetLink(id)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if link.Deleted {
 		http.Error(w, http.StatusText(http.StatusGone), http.StatusGone)
 		return
 	}
+	if link.Banned {
+		renderBlocked(w)
+		return
+	}
+
+	policy := h.QueryPolicy
+	if link.Settings.QueryPolicy != "" {
+		policy = link.Settings.QueryPolicy
+	}
+	// rules go first, then traffic split, and original URL is a fallback
+	var (
+		variant  *repository.Variant
+		assigned bool
+	)
+	destination, ok := h.matchRule(r, link)
+	if !ok {
+		destination = link.OriginalURL
+		variant, assigned = h.pickVariant(r, link)
+		if variant != nil {
+			destination = variant.URL
+		}
+	}
+	location, err := buildRedirectURL(destination, r.URL.Query(), policy, link.Settings.UTM, id)
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		// stored URL is always valid, so just log and redirect as is
+		log.Printf("ERROR: cannot build redirect URL for %s: %v", id, err)
+		location = destination
+	}
+
+	if preview || link.Settings.Preview {
+		renderPreview(w, location)
 		return
 	}
+	// preview isn't a visit, so only redirect is counted
+	h.countVariant(w, id, variant, assigned)
 
-	w.Header().Set("Location", originalURL)
-	w.WriteHeader(http.StatusTemporaryRedirect)
+	code := h.RedirectCode
+	if link.Settings.RedirectCode != 0 {
+		code = link.Settings.RedirectCode
+	}
+
+	w.Header().Set("Location", location)
+	w.WriteHeader(code)
+}
+
+func (h *URLHandlers) GetLinkSettings(w http.ResponseWriter, r *http.Request) {
+	link, _, ok := h.ownedLink(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(link.Settings); err != nil {
+		log.Printf("ERROR: cannot encode link settings: %v", err)
+	}
+}
+
+func (h *URLHandlers) UpdateLinkSettings(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	userID, ok := getUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var settings repository.LinkSettings
+	if !decodeJSONBody(w, r, &settings) {
+		return
+	}
+	if err := service.ValidateLinkSettings(settings); err != nil {
+		BadRequest(w, err.Error())
+		return
+	}
+
+	if err := h.storage.UpdateLinkSettings(chi.URLParam(r, "id"), userID, settings); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (h *URLHandlers) GetUserURLs(w http.ResponseWriter, r *http.Request) {
@@ -275,32 +257,18 @@ func (h *URLHandlers) GetUserURLs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	items, err := h.storage.GetURLsByUserID(userID)
+	resp, err := h.Shortener.UserURLs(userID)
 	if err != nil {
-		log.Printf("ERROR: cannot get urls by user %s: %v", userID, err)
+		log.Printf("ERROR: %v", err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	if len(items) == 0 {
+	if len(resp) == 0 {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
-	resp := make([]userURLResponseItem, 0, len(items))
-	for _, it := range items {
-		shortURL, err := url.JoinPath(h.baseURL, it.ShortID)
-		if err != nil {
-			log.Printf("ERROR: cannot build short url for %s: %v", it.ShortID, err)
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			return
-		}
-		resp = append(resp, userURLResponseItem{
-			ShortURL:    shortURL,
-			OriginalURL: it.OriginalURL,
-		})
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
 		log.Printf("ERROR: cannot encode user urls: %v", err)
@@ -331,13 +299,7 @@ func (h *URLHandlers) DeleteUserURLs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len(ids) != 0 {
-		go func(userID string, ids []string) {
-			if err := h.storage.DeleteBatch(userID, ids); err != nil {
-				log.Printf("delete batch failed: user=%s, ids=%v, err=%v", userID, ids, err)
-			}
-		}(userID, ids)
-	}
+	h.Shortener.DeleteURLs(userID, ids)
 
 	w.WriteHeader(http.StatusAccepted)
 }
@@ -55,7 +55,7 @@ func Test_generateAndStoreShortURL(t *testing.T) {
 				err: nil,
 			},
 			setupStorage: func(s repository.URLRepository, userID string) {
-				require.NoError(t, s.Create(testID, testURL+"/original-url?", testUserID))
+				require.NoError(t, s.Create(testID, testURL+"/original-url?", testUserID, repository.LinkSettings{}))
 			},
 			wantStatus: http.StatusInternalServerError,
 		},
@@ -66,7 +66,7 @@ func Test_generateAndStoreShortURL(t *testing.T) {
 				err: nil,
 			},
 			setupStorage: func(s repository.URLRepository, userID string) {
-				require.NoError(t, s.Create("existing-id", testURL, testUserID))
+				require.NoError(t, s.Create("existing-id", testURL, testUserID, repository.LinkSettings{}))
 			},
 			wantStatus: http.StatusConflict,
 		},
@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/bissquit/url-shortener/internal/repository"
+)
+
+const (
+	ExportCSV    = "csv"
+	ExportJSON   = "json"
+	ExportNDJSON = "ndjson"
+)
+
+// exportFlushEvery is how many records are written before response is flushed
+const exportFlushEvery = 100
+
+type exportRecord struct {
+	ShortID     string    `json:"short_id"`
+	ShortURL    string    `json:"short_url"`
+	OriginalURL string    `json:"original_url"`
+	CreatedAt   time.Time `json:"created_at,omitzero"`
+	Deleted     bool      `json:"is_deleted"`
+}
+
+var exportCSVHeader = []string{"short_id", "short_url", "original_url", "created_at", "is_deleted"}
+
+func (r exportRecord) csv() []string {
+	var createdAt string
+	if !r.CreatedAt.IsZero() {
+		createdAt = r.CreatedAt.Format(time.RFC3339)
+	}
+	return []string{r.ShortID, r.ShortURL, r.OriginalURL, createdAt, strconv.FormatBool(r.Deleted)}
+}
+
+// exportEncoder writes records of one format
+type exportEncoder interface {
+	begin() error
+	encode(r exportRecord) error
+	// flush passes buffered records to underlying writer
+	flush() error
+	end() error
+}
+
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvEncoder) begin() error {
+	return e.w.Write(exportCSVHeader)
+}
+
+func (e *csvEncoder) encode(r exportRecord) error {
+	return e.w.Write(r.csv())
+}
+
+func (e *csvEncoder) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvEncoder) end() error {
+	return e.flush()
+}
+
+// jsonEncoder writes a JSON array (or NDJSON) record by record
+type jsonEncoder struct {
+	w       io.Writer
+	enc     *json.Encoder
+	ndjson  bool
+	written bool
+}
+
+func (e *jsonEncoder) begin() error {
+	if e.ndjson {
+		return nil
+	}
+	_, err := io.WriteString(e.w, "[")
+	return err
+}
+
+func (e *jsonEncoder) encode(r exportRecord) error {
+	if e.written && !e.ndjson {
+		if _, err := io.WriteString(e.w, ","); err != nil {
+			return err
+		}
+	}
+	e.written = true
+	// Encode adds new line after every record
+	return e.enc.Encode(r)
+}
+
+func (e *jsonEncoder) flush() error {
+	return nil
+}
+
+func (e *jsonEncoder) end() error {
+	if e.ndjson {
+		return nil
+	}
+	_, err := io.WriteString(e.w, "]\n")
+	return err
+}
+
+// ExportUserURLs streams all user links including deleted ones,
+// format is set by "format" query parameter (csv by default).
+// Status is sent before links are read, so connection is aborted on error
+// and client gets incomplete response instead of a truncated but valid one
+func (h *URLHandlers) ExportUserURLs(w http.ResponseWriter, r *http.Request) {
+	userID, ok := getUserID(w, r)
+	if !ok {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = ExportCSV
+	}
+
+	var (
+		contentType string
+		enc         exportEncoder
+	)
+	switch format {
+	case ExportCSV:
+		contentType = "text/csv; charset=utf-8"
+		enc = &csvEncoder{w: csv.NewWriter(w)}
+	case ExportJSON:
+		contentType = "application/json"
+		enc = &jsonEncoder{w: w, enc: json.NewEncoder(w)}
+	case ExportNDJSON:
+		contentType = "application/x-ndjson"
+		enc = &jsonEncoder{w: w, enc: json.NewEncoder(w), ndjson: true}
+	default:
+		BadRequest(w, "format must be csv, json or ndjson")
+		return
+	}
+
+	extendDeadlines(w)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="urls.`+format+`"`)
+	w.WriteHeader(http.StatusOK)
+
+	if err := enc.begin(); err != nil {
+		log.Printf("ERROR: cannot write export: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+
+	rc := http.NewResponseController(w)
+	var n int
+	err := h.storage.IterateUserURLs(r.Context(), userID, func(u repository.UserURL) error {
+		shortURL, err := url.JoinPath(h.baseURL, u.ShortID)
+		if err != nil {
+			return err
+		}
+		err = enc.encode(exportRecord{
+			ShortID:     u.ShortID,
+			ShortURL:    shortURL,
+			OriginalURL: u.OriginalURL,
+			CreatedAt:   u.CreatedAt,
+			Deleted:     u.Deleted,
+		})
+		if err != nil {
+			return err
+		}
+
+		n++
+		if n%exportFlushEvery == 0 {
+			if err = enc.flush(); err != nil {
+				return err
+			}
+			// not every writer supports flushing, data is sent on buffer overflow then
+			_ = rc.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("ERROR: cannot export urls of user %s: %v", userID, err)
+		panic(http.ErrAbortHandler)
+	}
+
+	if err = enc.end(); err != nil {
+		log.Printf("ERROR: cannot write export: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+}
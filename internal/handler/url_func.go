@@ -2,106 +2,137 @@ package handler
 
 import (
 	"errors"
-	"fmt"
 	"log"
 	"net/http"
-	"net/url"
+	"time"
 
+	"github.com/bissquit/url-shortener/internal/auth"
+	"github.com/bissquit/url-shortener/internal/clientip"
+	"github.com/bissquit/url-shortener/internal/config"
+	"github.com/bissquit/url-shortener/internal/ratelimit"
 	"github.com/bissquit/url-shortener/internal/repository"
 	"github.com/bissquit/url-shortener/internal/service"
+	"github.com/bissquit/url-shortener/internal/service/urlcheck"
 )
 
 type URLHandlers struct {
-	storage   repository.URLRepository
-	baseURL   string
-	generator service.IDGenerator
+	storage repository.URLRepository
+	baseURL string
+	// Shortener creates and deletes links, its Checker validates URLs of rules and variants too
+	Shortener *service.Shortener
+	// RedirectCode is used for links without their own redirect code
+	RedirectCode int
+	// QueryPolicy is used for links without their own query policy
+	QueryPolicy string
+	// GeoIP is optional, country rules never match without it
+	GeoIP GeoLocator
+	// ClientIP honors forwarding headers of trusted proxies only, nil resolver uses remote address
+	ClientIP *clientip.Resolver
+	// ReportLimiter limits reports per client IP, nil means no limit
+	ReportLimiter *ratelimit.Limiter
 }
 
 func NewURLHandlers(storage repository.URLRepository, baseURL string, generator service.IDGenerator) *URLHandlers {
+	checker := urlcheck.New(baseURL, urlcheck.DefaultSchemes, urlcheck.DefaultMaxLength)
 	return &URLHandlers{
-		storage:   storage,
-		baseURL:   baseURL,
-		generator: generator,
+		storage:      storage,
+		baseURL:      baseURL,
+		Shortener:    service.NewShortener(storage, baseURL, generator, checker),
+		RedirectCode: http.StatusTemporaryRedirect,
+		QueryPolicy:  config.QueryPolicyIgnore,
 	}
 }
 
+// longTransferTimeout replaces server read and write timeouts for exports, imports and backups
+const longTransferTimeout = 30 * time.Minute
+
+// extendDeadlines lets long transfers outlive server timeouts, it's a no-op if writer doesn't support deadlines
+func extendDeadlines(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(longTransferTimeout)
+	_ = rc.SetReadDeadline(deadline)
+	_ = rc.SetWriteDeadline(deadline)
+}
+
 type requestURL struct {
-	URL string `json:"url"`
+	URL          string            `json:"url"`
+	RedirectCode int               `json:"redirect_code,omitempty"`
+	Preview      bool              `json:"preview,omitempty"`
+	QueryPolicy  string            `json:"query_policy,omitempty"`
+	UTM          map[string]string `json:"utm,omitempty"`
 }
 
 type responseURL struct {
 	Result string `json:"result"`
 }
 
-type userURLResponseItem struct {
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
+// checkURL returns normalized URL which should be stored instead of the raw one
+func (h *URLHandlers) checkURL(u string) (string, error) {
+	return h.Shortener.Checker.Check(u)
 }
 
-func validateURL(u string) error {
-	if u == "" {
-		return errors.New("empty URL value in request body")
-	}
-
-	if _, err := url.ParseRequestURI(u); err != nil {
-		return errors.New("invalid URL")
+// writeURLError responds with 422 when URL is well-formed but not allowed
+// and with 400 when it's malformed
+func writeURLError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, urlcheck.ErrSchemeNotAllowed),
+		errors.Is(err, urlcheck.ErrSelfReference),
+		errors.Is(err, urlcheck.ErrBlockedDomain):
+		log.Printf("rejected URL: %v", err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		BadRequest(w, err.Error())
 	}
-	return nil
 }
 
-var (
-	ErrIDGenerationExhausted = errors.New("id generation exhausted")
-)
-
-func generateAndStoreShortURL(originalURL string, h *URLHandlers, userID string) (string, bool, error) {
-	const maxAttempts = 10
-
-	for i := 0; i < maxAttempts; i++ {
-		// trying to generate short ID
-		id, err := h.generator.GenerateShortID()
-		if err != nil {
-			return "", false, fmt.Errorf("cannot generate shorten ID: %w", err)
-		}
-		if id == "" {
-			return "", false, fmt.Errorf("generator returned empty id")
-		}
-
-		// trying to save ID
-		err = h.storage.Create(id, originalURL, userID)
-		switch {
-		case err == nil:
-			shortURL, err := url.JoinPath(h.baseURL, id)
-			if err != nil {
-				return "", false, fmt.Errorf("cannot build shorten URL (baseURL=%q, id=%q): %w", h.baseURL, id, err)
-			}
-			return shortURL, true, nil
-
-		case errors.Is(err, repository.ErrIDAlreadyExists):
-			// short_id collision --> trying another id
-			log.Printf("INFO: short_id collision (attempt %d/%d): %v", i+1, maxAttempts, err)
-			continue
-
-		case errors.Is(err, repository.ErrURLAlreadyExists):
-			// URL already exist --> make additional request to return existing short_url
-			existingID, err2 := h.storage.GetIDByURL(originalURL)
-			if err2 != nil {
-				return "", false, fmt.Errorf("url exists but cannot get id by url: %w", err2)
-			}
-			shortURL, err2 := url.JoinPath(h.baseURL, existingID)
-			if err2 != nil {
-				return "", false, fmt.Errorf("cannot build existing shorten URL (baseURL=%q, id=%q): %w", h.baseURL, existingID, err2)
-			}
-			return shortURL, false, nil
-
-		default:
-			return "", false, fmt.Errorf("unknown storage error: %w", err)
-		}
+// writeServiceError responds to errors of service.Shortener: 400 or 422 for invalid request
+// (see writeURLError), 409 for already stored URL and 500 otherwise
+func writeServiceError(w http.ResponseWriter, err error) {
+	var invalid *service.ValidationError
+	switch {
+	case errors.As(err, &invalid):
+		writeURLError(w, err)
+	case errors.Is(err, service.ErrURLConflict):
+		http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+	default:
+		log.Printf("ERROR: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
-
-	return "", false, fmt.Errorf("%w: attempts=%d", ErrIDGenerationExhausted, maxAttempts)
 }
 
 func BadRequest(w http.ResponseWriter, message string) {
 	log.Printf("bad request: %s", message)
 	http.Error(w, message, http.StatusBadRequest)
 }
+
+// writeStorageError maps repository errors of a single link lookup to http statuses
+func writeStorageError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	case errors.Is(err, repository.ErrForbidden):
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	case errors.Is(err, repository.ErrDeleted):
+		http.Error(w, http.StatusText(http.StatusGone), http.StatusGone)
+	case errors.Is(err, repository.ErrReportResolved):
+		http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+	case errors.Is(err, repository.ErrTooManyRules):
+		BadRequest(w, err.Error())
+	case errors.Is(err, repository.ErrTooManyReports):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	default:
+		log.Printf("ERROR: storage error: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// getUserID returns user from context set by auth middleware.
+// Response is already written when false is returned
+func getUserID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if userID == "" || !ok {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return "", false
+	}
+	return userID, true
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bissquit/url-shortener/internal/auth"
+	"github.com/bissquit/url-shortener/internal/config"
+	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/bissquit/url-shortener/internal/repository/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sequenceGenerator returns ids one by one
+type sequenceGenerator struct {
+	ids   []string
+	calls int
+}
+
+func (g *sequenceGenerator) GenerateShortID() (string, error) {
+	id := g.ids[g.calls%len(g.ids)]
+	g.calls++
+	return id, nil
+}
+
+func Test_HandlersCreateBatch(t *testing.T) {
+	const defaultBody = `[
+		{"correlation_id": "1", "original_url": "https://example.com/1"},
+		{"correlation_id": "2", "original_url": "https://example.com/2"}
+	]`
+
+	tests := []struct {
+		name      string
+		ids       []string
+		body      string
+		wantCode  int
+		wantCalls int
+	}{
+		{
+			name:      "no collisions",
+			ids:       []string{"a", "b"},
+			wantCode:  http.StatusCreated,
+			wantCalls: 2,
+		},
+		{
+			name: "only colliding id is regenerated",
+			// stored id is returned first for one of items
+			ids:       []string{"taken", "a", "b"},
+			wantCode:  http.StatusCreated,
+			wantCalls: 3,
+		},
+		{
+			name:      "stored URL",
+			ids:       []string{"a", "b"},
+			body:      `[{"correlation_id": "1", "original_url": "https://example.com/taken"}]`,
+			wantCode:  http.StatusConflict,
+			wantCalls: 1,
+		},
+	}
+
+	cfg := config.GetDefaultConfig()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := memory.NewURLStorage()
+			require.NoError(t, storage.Create("taken", "https://example.com/taken", "someone", repository.LinkSettings{}))
+			gen := &sequenceGenerator{ids: tt.ids}
+			body := defaultBody
+			if tt.body != "" {
+				body = tt.body
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(body))
+			r.Header.Set("Content-Type", "application/json")
+			r = r.WithContext(context.WithValue(r.Context(), auth.UserIDKey, "user"))
+			w := httptest.NewRecorder()
+
+			NewURLHandlers(storage, cfg.BaseURL, gen).CreateBatch(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, tt.wantCode, res.StatusCode)
+			assert.Equal(t, tt.wantCalls, gen.calls)
+
+			if tt.wantCode != http.StatusCreated {
+				return
+			}
+			var out []repository.BatchItemOutput
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&out))
+			require.Len(t, out, 2)
+			for _, item := range out {
+				id := item.ShortURL[strings.LastIndex(item.ShortURL, "/")+1:]
+				assert.NotEqual(t, "taken", id)
+				stored, err := storage.GetURLByID(id)
+				require.NoError(t, err)
+				assert.Equal(t, "https://example.com/"+item.CorrelationID, stored)
+			}
+		})
+	}
+}
@@ -68,6 +68,16 @@ func Test_HandlersCreate(t *testing.T) {
 				cookieName:  "auth_token",
 			},
 		},
+		{
+			name: "not allowed URL scheme",
+			input: input{
+				body:        "javascript:alert(1)",
+				contentType: "text/plain",
+			},
+			want: want{
+				code: http.StatusUnprocessableEntity,
+			},
+		},
 		{
 			name: "incorrect URL format",
 			input: input{
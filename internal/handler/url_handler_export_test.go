@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bissquit/url-shortener/internal/auth"
+	"github.com/bissquit/url-shortener/internal/config"
+	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/bissquit/url-shortener/internal/repository/memory"
+	"github.com/bissquit/url-shortener/internal/service/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_HandlersExportUserURLs(t *testing.T) {
+	const testUserID = "export-user"
+
+	tests := []struct {
+		name            string
+		format          string
+		wantStatus      int
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name:            "csv by default",
+			format:          "",
+			wantStatus:      http.StatusOK,
+			wantContentType: "text/csv; charset=utf-8",
+			wantBody: "short_id,short_url,original_url,created_at,is_deleted\n" +
+				"export001,http://localhost:8080/export001,https://example.com,2025-01-02T03:04:05Z,false\n" +
+				"export002,http://localhost:8080/export002,https://example.org/a?b=1,2025-01-03T03:04:05Z,true\n",
+		},
+		{
+			name:            "json array",
+			format:          "json",
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/json",
+			wantBody: `[{"short_id":"export001","short_url":"http://localhost:8080/export001",` +
+				`"original_url":"https://example.com","created_at":"2025-01-02T03:04:05Z","is_deleted":false}` + "\n" +
+				`,{"short_id":"export002","short_url":"http://localhost:8080/export002",` +
+				`"original_url":"https://example.org/a?b=1","created_at":"2025-01-03T03:04:05Z","is_deleted":true}` + "\n]\n",
+		},
+		{
+			name:            "ndjson",
+			format:          "ndjson",
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/x-ndjson",
+			wantBody: `{"short_id":"export001","short_url":"http://localhost:8080/export001",` +
+				`"original_url":"https://example.com","created_at":"2025-01-02T03:04:05Z","is_deleted":false}` + "\n" +
+				`{"short_id":"export002","short_url":"http://localhost:8080/export002",` +
+				`"original_url":"https://example.org/a?b=1","created_at":"2025-01-03T03:04:05Z","is_deleted":true}` + "\n",
+		},
+		{
+			name:       "unknown format",
+			format:     "xml",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	cfg := config.GetDefaultConfig()
+	storage := memory.NewURLStorage()
+	// the second link is older in map order but newer by creation time
+	require.NoError(t, storage.PutLinks([]repository.Link{
+		{
+			ShortID:     "export002",
+			OriginalURL: "https://example.org/a?b=1",
+			UserID:      testUserID,
+			CreatedAt:   mustParseTime(t, "2025-01-03T03:04:05Z"),
+			Deleted:     true,
+		},
+		{
+			ShortID:     "export001",
+			OriginalURL: "https://example.com",
+			UserID:      testUserID,
+			CreatedAt:   mustParseTime(t, "2025-01-02T03:04:05Z"),
+		},
+		{
+			ShortID:     "export003",
+			OriginalURL: "https://example.net",
+			UserID:      "another-user",
+		},
+	}))
+	handlers := NewURLHandlers(storage, cfg.BaseURL, crypto.NewRandomGenerator())
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/user/urls/export?format="+tt.format, nil)
+			r = r.WithContext(context.WithValue(r.Context(), auth.UserIDKey, testUserID))
+			w := httptest.NewRecorder()
+
+			handlers.ExportUserURLs(w, r)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			assert.Equal(t, tt.wantContentType, w.Header().Get("Content-Type"))
+			assert.Contains(t, w.Header().Get("Content-Disposition"), "attachment")
+			assert.Equal(t, tt.wantBody, w.Body.String())
+		})
+	}
+}
+
+// failingIterator fails after the first user link
+type failingIterator struct {
+	*memory.URLStorage
+}
+
+func (failingIterator) IterateUserURLs(_ context.Context, _ string, fn func(repository.UserURL) error) error {
+	if err := fn(repository.UserURL{ShortID: "export001", OriginalURL: "https://example.com"}); err != nil {
+		return err
+	}
+	return errors.New("connection reset")
+}
+
+func Test_HandlersExportUserURLsAbort(t *testing.T) {
+	cfg := config.GetDefaultConfig()
+	handlers := NewURLHandlers(failingIterator{memory.NewURLStorage()}, cfg.BaseURL, crypto.NewRandomGenerator())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlers.ExportUserURLs(w, r.WithContext(context.WithValue(r.Context(), auth.UserIDKey, "export-user")))
+	}))
+	defer srv.Close()
+
+	// connection is closed before or after headers depending on buffering,
+	// anyway truncated JSON must not look complete
+	res, err := http.Get(srv.URL + "/api/user/urls/export?format=json")
+	if err == nil {
+		defer res.Body.Close()
+		_, err = io.ReadAll(res.Body)
+	}
+	assert.Error(t, err)
+}
+
+func mustParseTime(t *testing.T, value string) time.Time {
+	t.Helper()
+	parsed, err := time.Parse(time.RFC3339, value)
+	require.NoError(t, err)
+	return parsed
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/bissquit/url-shortener/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_buildRedirectURL(t *testing.T) {
+	const testID = "abc123"
+
+	tests := []struct {
+		name        string
+		originalURL string
+		incoming    string
+		policy      string
+		utm         map[string]string
+		want        string
+	}{
+		{
+			name:        "ignore policy keeps url as is",
+			originalURL: "https://example.com/a?b=1&a=2",
+			incoming:    "utm_source=x",
+			policy:      config.QueryPolicyIgnore,
+			want:        "https://example.com/a?b=1&a=2",
+		},
+		{
+			name:        "append adds only new parameters",
+			originalURL: "https://example.com/a?a=1",
+			incoming:    "a=2&utm_source=x",
+			policy:      config.QueryPolicyAppend,
+			want:        "https://example.com/a?a=1&utm_source=x",
+		},
+		{
+			name:        "override replaces existing parameters",
+			originalURL: "https://example.com/a?a=1&b=1",
+			incoming:    "a=2",
+			policy:      config.QueryPolicyOverride,
+			want:        "https://example.com/a?a=2&b=1",
+		},
+		{
+			name:        "empty incoming query keeps url as is",
+			originalURL: "https://example.com/a?b=1&a=2",
+			policy:      config.QueryPolicyOverride,
+			want:        "https://example.com/a?b=1&a=2",
+		},
+		{
+			name:        "utm template fills missing parameters",
+			originalURL: "https://example.com/a?utm_source=own",
+			policy:      config.QueryPolicyIgnore,
+			utm: map[string]string{
+				"utm_source":   "shortener",
+				"utm_campaign": "link-{id}",
+			},
+			want: "https://example.com/a?utm_campaign=link-abc123&utm_source=own",
+		},
+		{
+			name:        "incoming parameters win over utm template",
+			originalURL: "https://example.com/a",
+			incoming:    "utm_source=mail",
+			policy:      config.QueryPolicyAppend,
+			utm:         map[string]string{"utm_source": "shortener"},
+			want:        "https://example.com/a?utm_source=mail",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			incoming, err := url.ParseQuery(tt.incoming)
+			require.NoError(t, err)
+
+			got, err := buildRedirectURL(tt.originalURL, incoming, tt.policy, tt.utm, testID)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -9,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/bissquit/url-shortener/internal/config"
+	"github.com/bissquit/url-shortener/internal/repository"
 	"github.com/bissquit/url-shortener/internal/repository/memory"
 	"github.com/bissquit/url-shortener/internal/service/crypto"
 	"github.com/stretchr/testify/assert"
@@ -25,8 +27,12 @@ func Test_HandlersRedirect(t *testing.T) {
 	)
 
 	type want struct {
-		code     int
-		location string
+		code        int
+		location    string
+		contentType string
+		// bodyContains and bodyExcludes are checked for preview pages
+		bodyContains string
+		bodyExcludes string
 	}
 	tests := []struct {
 		name    string
@@ -49,6 +55,47 @@ func Test_HandlersRedirect(t *testing.T) {
 				location: testLongURL,
 			},
 		},
+		{
+			name:    "redirect with per-link code",
+			shortID: "permanent0001",
+			want: want{
+				code:     http.StatusMovedPermanently,
+				location: testShortURL + "/permanent",
+			},
+		},
+		{
+			name:    "preview page by path suffix",
+			shortID: "skfjnvoe34nk+",
+			want: want{
+				code:         http.StatusOK,
+				contentType:  "text/html",
+				bodyContains: `http-equiv="refresh"`,
+			},
+		},
+		{
+			name:    "preview page doesn't forward to unsafe scheme",
+			shortID: "script000001+",
+			want: want{
+				code:         http.StatusOK,
+				contentType:  "text/html",
+				bodyExcludes: `http-equiv="refresh"`,
+			},
+		},
+		{
+			name:    "preview page by link settings",
+			shortID: "preview00001",
+			want: want{
+				code:        http.StatusOK,
+				contentType: "text/html",
+			},
+		},
+		{
+			name:    "banned link",
+			shortID: "banned000001",
+			want: want{
+				code: http.StatusUnavailableForLegalReasons,
+			},
+		},
 		{
 			name:    "empty short ID",
 			shortID: "",
@@ -78,8 +125,15 @@ func Test_HandlersRedirect(t *testing.T) {
 	gen := crypto.NewRandomGenerator()
 	// prepare test data
 	const testUserID = "test-redirect-user"
-	storage.Create("skfjnvoe34nk", testShortURL, testUserID) // ← добавить
-	storage.Create("kjsdfbj4t9bb", testLongURL, testUserID)
+	storage.Create("skfjnvoe34nk", testShortURL, testUserID, repository.LinkSettings{}) // ← добавить
+	storage.Create("kjsdfbj4t9bb", testLongURL, testUserID, repository.LinkSettings{})
+	storage.Create("permanent0001", testShortURL+"/permanent", testUserID,
+		repository.LinkSettings{RedirectCode: http.StatusMovedPermanently})
+	storage.Create("preview00001", testShortURL+"/preview", testUserID, repository.LinkSettings{Preview: true})
+	// scheme may be allowed by config, but browser isn't forwarded to it
+	storage.Create("script000001", "javascript:alert(1)", testUserID, repository.LinkSettings{})
+	storage.Create("banned000001", testShortURL+"/banned", testUserID, repository.LinkSettings{})
+	require.NoError(t, storage.SetBanned("banned000001", true))
 	handlers := NewURLHandlers(storage, cfg.BaseURL, gen)
 
 	for _, tt := range tests {
@@ -106,7 +160,17 @@ func Test_HandlersRedirect(t *testing.T) {
 			// check status code
 			assert.Equal(t, tt.want.code, res.StatusCode)
 
-			if tt.want.code == http.StatusTemporaryRedirect {
+			if tt.want.contentType != "" {
+				assert.Contains(t, res.Header.Get("Content-Type"), tt.want.contentType)
+			}
+			if tt.want.bodyContains != "" {
+				assert.Contains(t, string(resBody), tt.want.bodyContains)
+			}
+			if tt.want.bodyExcludes != "" {
+				assert.NotContains(t, string(resBody), tt.want.bodyExcludes)
+			}
+
+			if tt.want.code >= 300 && tt.want.code < 400 {
 				// check if there is a valid url in the location
 				location := res.Header.Get("Location")
 				assert.Equal(t, tt.want.location, location)
@@ -118,3 +182,21 @@ func Test_HandlersRedirect(t *testing.T) {
 		})
 	}
 }
+
+// brokenStorage fails every link lookup
+type brokenStorage struct {
+	*memory.URLStorage
+}
+
+func (brokenStorage) GetLink(string) (repository.Link, error) {
+	return repository.Link{}, errors.New("connection refused")
+}
+
+func Test_HandlersRedirectStorageError(t *testing.T) {
+	handlers := NewURLHandlers(brokenStorage{memory.NewURLStorage()}, config.GetDefaultConfig().BaseURL,
+		crypto.NewRandomGenerator())
+
+	w := httptest.NewRecorder()
+	handlers.Redirect(w, httptest.NewRequest(http.MethodGet, "/abc123", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
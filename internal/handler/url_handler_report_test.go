@@ -0,0 +1,196 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bissquit/url-shortener/internal/auth"
+	"github.com/bissquit/url-shortener/internal/config"
+	"github.com/bissquit/url-shortener/internal/ratelimit"
+	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/bissquit/url-shortener/internal/repository/memory"
+	"github.com/bissquit/url-shortener/internal/service/crypto"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_HandlersReportLink(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+		body string
+		// anonymous request gets a new user
+		anonymous  bool
+		wantStatus int
+	}{
+		{
+			name:       "report existing link",
+			id:         "report00001",
+			body:       `{"reason":"phishing"}`,
+			wantStatus: http.StatusAccepted,
+		},
+		{
+			name:       "report without reason",
+			id:         "report00001",
+			body:       `{}`,
+			wantStatus: http.StatusAccepted,
+		},
+		{
+			name:       "too long reason",
+			id:         "report00001",
+			body:       `{"reason":"` + strings.Repeat("a", maxReportReasonLength+1) + `"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unexisted link",
+			id:         "unexisted",
+			body:       `{"reason":"phishing"}`,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "reporter without session",
+			id:         "report00001",
+			body:       `{"reason":"phishing"}`,
+			anonymous:  true,
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	cfg := config.GetDefaultConfig()
+	reporterToken, err := auth.BuildToken("reporter", "", 0)
+	require.NoError(t, err)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := memory.NewURLStorage()
+			require.NoError(t, storage.Create("report00001", "https://example.com", "owner", repository.LinkSettings{}))
+			h := NewURLHandlers(storage, cfg.BaseURL, crypto.NewRandomGenerator())
+
+			router := chi.NewRouter()
+			router.Use(auth.JWTAuth)
+			router.Post("/api/report/{id}", h.ReportLink)
+
+			r := httptest.NewRequest(http.MethodPost, "/api/report/"+tt.id, strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+			if !tt.anonymous {
+				r.Header.Set("Authorization", "Bearer "+reporterToken)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
+
+func Test_HandlersReportLinkLimits(t *testing.T) {
+	cfg := config.GetDefaultConfig()
+	storage := memory.NewURLStorage()
+	require.NoError(t, storage.Create("report00001", "https://example.com", "owner", repository.LinkSettings{}))
+	h := NewURLHandlers(storage, cfg.BaseURL, crypto.NewRandomGenerator())
+	h.ReportLimiter = ratelimit.New(maxOpenReports+1, time.Minute)
+
+	router := chi.NewRouter()
+	router.Use(auth.JWTAuth)
+	router.Post("/api/report/{id}", h.ReportLink)
+
+	report := func(reporter string) int {
+		token, err := auth.BuildToken(reporter, "", 0)
+		require.NoError(t, err)
+		r := httptest.NewRequest(http.MethodPost, "/api/report/report00001", strings.NewReader(`{}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for i := range maxOpenReports {
+		require.Equal(t, http.StatusAccepted, report(fmt.Sprintf("reporter%d", i)))
+	}
+	assert.Equal(t, http.StatusTooManyRequests, report("one-more"), "open reports of link are limited")
+	assert.Equal(t, http.StatusTooManyRequests, report("reporter0"), "client IP is limited")
+}
+
+func Test_ModerationWorkflow(t *testing.T) {
+	adminToken, err := auth.BuildToken("admin", auth.RoleAdmin, 0)
+	require.NoError(t, err)
+
+	cfg := config.GetDefaultConfig()
+	storage := memory.NewURLStorage()
+	require.NoError(t, storage.Create("moderate001", "https://example.com", "owner", repository.LinkSettings{}))
+	h := NewURLHandlers(storage, cfg.BaseURL, crypto.NewRandomGenerator())
+
+	router := chi.NewRouter()
+	router.Use(auth.JWTAuth)
+	router.Post("/api/report/{id}", h.ReportLink)
+	router.Get("/{id}", h.Redirect)
+	router.Mount("/", newAdminRouter(storage))
+
+	// public report
+	reporterToken, err := auth.BuildToken("reporter", "", 0)
+	require.NoError(t, err)
+	r := httptest.NewRequest(http.MethodPost, "/api/report/moderate001", strings.NewReader(`{"reason":"malware"}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Authorization", "Bearer "+reporterToken)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	// admin sees it in the queue
+	r = httptest.NewRequest(http.MethodGet, "/api/admin/reports", nil)
+	r.Header.Set("Authorization", "Bearer "+adminToken)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	var reports []repository.Report
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &reports))
+	require.Len(t, reports, 1)
+	assert.Equal(t, "moderate001", reports[0].ShortID)
+	assert.Equal(t, "malware", reports[0].Reason)
+
+	// unknown status
+	r = httptest.NewRequest(http.MethodPost, "/api/admin/reports/1/resolve", strings.NewReader(`{"status":"open"}`))
+	r.Header.Set("Authorization", "Bearer "+adminToken)
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// and blocks the link
+	r = httptest.NewRequest(http.MethodPost, "/api/admin/reports/1/resolve", strings.NewReader(`{"status":"blocked"}`))
+	r.Header.Set("Authorization", "Bearer "+adminToken)
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// the queue is empty
+	r = httptest.NewRequest(http.MethodGet, "/api/admin/reports", nil)
+	r.Header.Set("Authorization", "Bearer "+adminToken)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.JSONEq(t, `[]`, w.Body.String())
+
+	// visitors get warning page
+	r = httptest.NewRequest(http.MethodGet, "/moderate001", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnavailableForLegalReasons, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+	assert.NotContains(t, w.Body.String(), "example.com")
+
+	// resolved report can't be resolved again
+	r = httptest.NewRequest(http.MethodPost, "/api/admin/reports/1/resolve", strings.NewReader(`{"status":"dismissed"}`))
+	r.Header.Set("Authorization", "Bearer "+adminToken)
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
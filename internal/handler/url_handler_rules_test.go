@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/bissquit/url-shortener/internal/clientip"
+	"github.com/bissquit/url-shortener/internal/config"
+	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/bissquit/url-shortener/internal/repository/memory"
+	"github.com/bissquit/url-shortener/internal/service/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type dummyGeoLocator map[string]string
+
+func (g dummyGeoLocator) Country(ip netip.Addr) string {
+	return g[ip.String()]
+}
+
+func Test_HandlersRedirectRules(t *testing.T) {
+	const (
+		testID     = "rules0000001"
+		testUserID = "rules-owner"
+		website    = "https://example.com"
+		appStore   = "https://apps.apple.com/app/example"
+		playStore  = "https://play.google.com/store/apps/details?id=example"
+		germanSite = "https://example.de"
+
+		iphoneUA  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)"
+		androidUA = "Mozilla/5.0 (Linux; Android 14; Pixel 8)"
+		desktopUA = "Mozilla/5.0 (X11; Linux x86_64)"
+	)
+
+	tests := []struct {
+		name      string
+		userAgent string
+		realIP    string
+		// remoteAddr is a trusted proxy by default
+		remoteAddr string
+		want       string
+	}{
+		{name: "ios goes to app store", userAgent: iphoneUA, want: appStore},
+		{name: "android goes to play", userAgent: androidUA, want: playStore},
+		{name: "desktop from germany", userAgent: desktopUA, realIP: "2.16.0.1", want: germanSite},
+		{name: "ios from germany matches device first", userAgent: iphoneUA, realIP: "2.16.0.1", want: appStore},
+		{name: "desktop falls back to original url", userAgent: desktopUA, realIP: "1.1.1.1", want: website},
+		{
+			name:       "header of untrusted client is ignored",
+			userAgent:  desktopUA,
+			realIP:     "2.16.0.1",
+			remoteAddr: "203.0.113.5:1234",
+			want:       website,
+		},
+	}
+
+	cfg := config.GetDefaultConfig()
+	storage := memory.NewURLStorage()
+	require.NoError(t, storage.Create(testID, website, testUserID, repository.LinkSettings{}))
+	require.NoError(t, storage.UpdateRedirectRules(testID, testUserID, []repository.RedirectRule{
+		{Device: DeviceIOS, URL: appStore},
+		{Device: DeviceAndroid, URL: playStore},
+		{Country: "DE", URL: germanSite},
+	}))
+
+	handlers := NewURLHandlers(storage, cfg.BaseURL, crypto.NewRandomGenerator())
+	handlers.GeoIP = dummyGeoLocator{"2.16.0.1": "DE", "203.0.113.5": "FR"}
+	handlers.ClientIP = clientip.New([]netip.Prefix{netip.MustParsePrefix("192.0.2.1/32")})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/"+testID, nil)
+			r.Header.Set("User-Agent", tt.userAgent)
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if tt.remoteAddr != "" {
+				r.RemoteAddr = tt.remoteAddr
+			}
+			w := httptest.NewRecorder()
+
+			handlers.Redirect(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, http.StatusTemporaryRedirect, res.StatusCode)
+			assert.Equal(t, tt.want, res.Header.Get("Location"))
+		})
+	}
+}
+
+func Test_HandlersRedirectDefaultTrustedProxies(t *testing.T) {
+	const (
+		testID     = "rules0000002"
+		website    = "https://example.com"
+		germanSite = "https://example.de"
+	)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		want       string
+	}{
+		{name: "header of local proxy is honored", remoteAddr: "127.0.0.1:1234", want: germanSite},
+		{name: "header of local ipv6 proxy is honored", remoteAddr: "[::1]:1234", want: germanSite},
+		{name: "header of remote client is ignored", remoteAddr: "203.0.113.5:1234", want: website},
+	}
+
+	cfg := config.GetDefaultConfig()
+	storage := memory.NewURLStorage()
+	require.NoError(t, storage.Create(testID, website, "owner", repository.LinkSettings{}))
+	require.NoError(t, storage.UpdateRedirectRules(testID, "owner", []repository.RedirectRule{
+		{Country: "DE", URL: germanSite},
+	}))
+
+	handlers := NewURLHandlers(storage, cfg.BaseURL, crypto.NewRandomGenerator())
+	handlers.GeoIP = dummyGeoLocator{"2.16.0.1": "DE"}
+	proxies, err := cfg.TrustedProxyNetworks()
+	require.NoError(t, err)
+	handlers.ClientIP = clientip.New(proxies)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/"+testID, nil)
+			r.Header.Set("X-Real-IP", "2.16.0.1")
+			r.RemoteAddr = tt.remoteAddr
+			w := httptest.NewRecorder()
+
+			handlers.Redirect(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, http.StatusTemporaryRedirect, res.StatusCode)
+			assert.Equal(t, tt.want, res.Header.Get("Location"))
+		})
+	}
+}
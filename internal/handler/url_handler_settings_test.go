@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bissquit/url-shortener/internal/auth"
+	"github.com/bissquit/url-shortener/internal/config"
+	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/bissquit/url-shortener/internal/repository/memory"
+	"github.com/bissquit/url-shortener/internal/service/crypto"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_HandlersUpdateLinkSettings(t *testing.T) {
+	const (
+		testID     = "settings0001"
+		testURL    = "https://example.com"
+		testUserID = "settings-owner"
+	)
+
+	tests := []struct {
+		name       string
+		id         string
+		userID     string
+		body       string
+		wantStatus int
+		wantCode   int
+	}{
+		{
+			name:       "owner sets permanent redirect",
+			id:         testID,
+			userID:     testUserID,
+			body:       `{"redirect_code":308}`,
+			wantStatus: http.StatusNoContent,
+			wantCode:   http.StatusPermanentRedirect,
+		},
+		{
+			name:       "unsupported redirect code",
+			id:         testID,
+			userID:     testUserID,
+			body:       `{"redirect_code":200}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "another user",
+			id:         testID,
+			userID:     "another-user",
+			body:       `{"preview":true}`,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "unexisted link",
+			id:         "unexisted",
+			userID:     testUserID,
+			body:       `{"preview":true}`,
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	cfg := config.GetDefaultConfig()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := memory.NewURLStorage()
+			require.NoError(t, storage.Create(testID, testURL, testUserID, repository.LinkSettings{}))
+			handlers := NewURLHandlers(storage, cfg.BaseURL, crypto.NewRandomGenerator())
+
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("id", tt.id)
+			ctx := context.WithValue(context.Background(), chi.RouteCtxKey, rctx)
+			ctx = context.WithValue(ctx, auth.UserIDKey, tt.userID)
+
+			r := httptest.NewRequest(http.MethodPut, "/api/user/urls/"+tt.id+"/settings", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+			r = r.WithContext(ctx)
+			w := httptest.NewRecorder()
+
+			handlers.UpdateLinkSettings(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, tt.wantStatus, res.StatusCode)
+
+			if tt.wantStatus == http.StatusNoContent {
+				link, err := storage.GetLink(testID)
+				require.NoError(t, err)
+				assert.Equal(t, repository.LinkSettings{RedirectCode: tt.wantCode}, link.Settings)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bissquit/url-shortener/internal/config"
+	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/bissquit/url-shortener/internal/repository/memory"
+	"github.com/bissquit/url-shortener/internal/service/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_HandlersRedirectVariants(t *testing.T) {
+	const (
+		testID     = "split0000001"
+		testUserID = "split-owner"
+		website    = "https://example.com"
+		variantA   = "https://example.com/a"
+		variantB   = "https://example.com/b"
+		visits     = 200
+	)
+
+	cfg := config.GetDefaultConfig()
+	storage := memory.NewURLStorage()
+	require.NoError(t, storage.Create(testID, website, testUserID, repository.LinkSettings{}))
+	require.NoError(t, storage.UpdateVariants(testID, testUserID, []repository.Variant{
+		{ID: "a", URL: variantA, Weight: 1},
+		{ID: "b", URL: variantB, Weight: 1},
+		{ID: "off", URL: website + "/off", Weight: 0},
+	}))
+	handlers := NewURLHandlers(storage, cfg.BaseURL, crypto.NewRandomGenerator())
+
+	redirect := func(cookie *http.Cookie) *http.Response {
+		r := httptest.NewRequest(http.MethodGet, "/"+testID, nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		handlers.Redirect(w, r)
+		return w.Result()
+	}
+
+	t.Run("new visitors are split by weight", func(t *testing.T) {
+		seen := map[string]int{}
+		for i := 0; i < visits; i++ {
+			res := redirect(nil)
+			res.Body.Close()
+			require.Equal(t, http.StatusTemporaryRedirect, res.StatusCode)
+			seen[res.Header.Get("Location")]++
+
+			cookies := res.Cookies()
+			require.Len(t, cookies, 1)
+			assert.Equal(t, variantCookiePrefix+testID, cookies[0].Name)
+		}
+		assert.Positive(t, seen[variantA])
+		assert.Positive(t, seen[variantB])
+		assert.Equal(t, visits, seen[variantA]+seen[variantB])
+	})
+
+	t.Run("assignment is sticky", func(t *testing.T) {
+		cookie := &http.Cookie{Name: variantCookiePrefix + testID, Value: "b"}
+		for i := 0; i < 10; i++ {
+			res := redirect(cookie)
+			res.Body.Close()
+			assert.Equal(t, variantB, res.Header.Get("Location"))
+			assert.Empty(t, res.Cookies())
+		}
+	})
+
+	t.Run("preview is not counted", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			w := httptest.NewRecorder()
+			handlers.Redirect(w, httptest.NewRequest(http.MethodGet, "/"+testID+"+", nil))
+			res := w.Result()
+			res.Body.Close()
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+			assert.Empty(t, res.Cookies())
+		}
+	})
+
+	t.Run("clicks are counted per variant", func(t *testing.T) {
+		link, err := storage.GetLink(testID)
+		require.NoError(t, err)
+		require.Len(t, link.Variants, 3)
+		assert.Equal(t, int64(visits+10), link.Variants[0].Clicks+link.Variants[1].Clicks)
+		assert.Zero(t, link.Variants[2].Clicks)
+	})
+
+	t.Run("update keeps clicks of existing variants", func(t *testing.T) {
+		before, err := storage.GetLink(testID)
+		require.NoError(t, err)
+
+		require.NoError(t, storage.UpdateVariants(testID, testUserID, []repository.Variant{
+			{ID: "a", URL: variantA, Weight: 3},
+			{ID: "c", URL: website + "/c", Weight: 1},
+		}))
+
+		after, err := storage.GetLink(testID)
+		require.NoError(t, err)
+		require.Len(t, after.Variants, 2)
+		assert.Equal(t, before.Variants[0].Clicks, after.Variants[0].Clicks)
+		assert.Zero(t, after.Variants[1].Clicks)
+	})
+}
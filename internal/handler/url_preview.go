@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// previewDelay is how many seconds the preview page is shown before forwarding
+const previewDelay = 5
+
+// html/template only escapes the meta refresh content, it doesn't check the URL scheme there,
+// so Forward must be set for http(s) destinations only (see canForward)
+var previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+{{if .Forward}}<meta http-equiv="refresh" content="{{.Delay}};url={{.URL}}">
+{{end}}<title>Redirect preview</title>
+</head>
+<body>
+<p>This short link leads to:</p>
+<p><strong>{{.URL}}</strong></p>
+{{if .Forward}}<p>You will be forwarded in {{.Delay}} seconds. <a href="{{.URL}}">Continue now</a></p>
+{{else}}<p>This destination can't be opened from the browser automatically.</p>
+{{end}}</body>
+</html>
+`))
+
+// canForward reports whether browser may be sent to destination from the preview page
+func canForward(destination string) bool {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return false
+	}
+	scheme := strings.ToLower(u.Scheme)
+	return (scheme == "http" || scheme == "https") && u.Host != ""
+}
+
+func renderPreview(w http.ResponseWriter, originalURL string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	err := previewTemplate.Execute(w, struct {
+		URL     string
+		Delay   int
+		Forward bool
+	}{
+		URL:     originalURL,
+		Delay:   previewDelay,
+		Forward: canForward(originalURL),
+	})
+	if err != nil {
+		log.Printf("ERROR: cannot render preview page: %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/bissquit/url-shortener/internal/config"
+)
+
+// buildRedirectURL merges incoming query parameters into original URL according to policy
+// and fills missing UTM parameters. "{id}" placeholder in UTM values is replaced with short ID.
+// Original URL is returned byte-to-byte when there is nothing to change
+func buildRedirectURL(originalURL string, incoming url.Values, policy string, utm map[string]string, id string) (string, error) {
+	if (policy == config.QueryPolicyIgnore || len(incoming) == 0) && len(utm) == 0 {
+		return originalURL, nil
+	}
+
+	u, err := url.Parse(originalURL)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	changed := false
+
+	for k, vs := range incoming {
+		switch policy {
+		case config.QueryPolicyAppend:
+			if _, ok := query[k]; ok {
+				continue
+			}
+		case config.QueryPolicyOverride:
+		default:
+			continue
+		}
+		query[k] = vs
+		changed = true
+	}
+
+	for k, v := range utm {
+		if query.Get(k) != "" {
+			continue
+		}
+		query.Set(k, strings.ReplaceAll(v, "{id}", id))
+		changed = true
+	}
+
+	if !changed {
+		return originalURL, nil
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/bissquit/url-shortener/internal/auth"
+	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	maxReportReasonLength = 1000
+	// maxOpenReports limits reports of a link waiting for moderation
+	maxOpenReports = 100
+)
+
+type requestReport struct {
+	Reason string `json:"reason"`
+}
+
+type responseReport struct {
+	ReportID int64 `json:"report_id"`
+}
+
+// ReportLink puts a link into moderation queue, anyone may report any link.
+// Reporter must already have a session, so repeated reports of the same client are deduplicated
+func (h *URLHandlers) ReportLink(w http.ResponseWriter, r *http.Request) {
+	userID, ok := getUserID(w, r)
+	if !ok {
+		return
+	}
+	if auth.IsNewUser(r.Context()) {
+		http.Error(w, "report requires auth_token issued by a previous request", http.StatusUnauthorized)
+		return
+	}
+	var clientKey string
+	if ip, ok := h.ClientIP.ClientIP(r); ok {
+		clientKey = ip.String()
+	}
+	if !h.ReportLimiter.Allow(clientKey) {
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
+
+	var body requestReport
+	if !decodeJSONBody(w, r, &body) {
+		return
+	}
+	if utf8.RuneCountInString(body.Reason) > maxReportReasonLength {
+		BadRequest(w, "reason is too long")
+		return
+	}
+
+	report, err := h.storage.CreateReport(repository.Report{
+		ShortID:    chi.URLParam(r, "id"),
+		Reason:     body.Reason,
+		ReporterID: userID,
+	}, maxOpenReports)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, responseReport{ReportID: report.ID})
+}
+
+var blockedTemplate = template.Must(template.New("blocked").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Link blocked</title>
+</head>
+<body>
+<p><strong>Warning!</strong> This short link has been blocked by moderators.</p>
+<p>It was reported as malicious and its destination is not available.</p>
+</body>
+</html>
+`))
+
+func renderBlocked(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusUnavailableForLegalReasons)
+	if err := blockedTemplate.Execute(w, nil); err != nil {
+		log.Printf("ERROR: cannot render blocked page: %v", err)
+	}
+}
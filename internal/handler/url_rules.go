@@ -0,0 +1,230 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// devices which can be targeted by redirect rules
+const (
+	DeviceIOS     = "ios"
+	DeviceAndroid = "android"
+	DeviceOther   = "other"
+)
+
+// maxRedirectRules limits rules count per link
+const maxRedirectRules = 50
+
+// GeoLocator resolves client IP to ISO 3166-1 alpha-2 country code
+type GeoLocator interface {
+	Country(ip netip.Addr) string
+}
+
+func detectDevice(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"), strings.Contains(ua, "ipod"):
+		return DeviceIOS
+	case strings.Contains(ua, "android"):
+		return DeviceAndroid
+	default:
+		return DeviceOther
+	}
+}
+
+// matchRule returns URL of the first rule matching the request
+func (h *URLHandlers) matchRule(r *http.Request, link repository.Link) (string, bool) {
+	if len(link.Rules) == 0 {
+		return "", false
+	}
+
+	device := detectDevice(r.UserAgent())
+	country := ""
+	if h.GeoIP != nil {
+		if ip, ok := h.ClientIP.ClientIP(r); ok {
+			country = h.GeoIP.Country(ip)
+		}
+	}
+
+	for _, rule := range link.Rules {
+		if rule.Device != "" && rule.Device != device {
+			continue
+		}
+		if rule.Country != "" && !strings.EqualFold(rule.Country, country) {
+			continue
+		}
+		return rule.URL, true
+	}
+	return "", false
+}
+
+// validateRedirectRule normalizes rule in place
+func (h *URLHandlers) validateRedirectRule(rule *repository.RedirectRule) error {
+	switch rule.Device {
+	case "", DeviceIOS, DeviceAndroid, DeviceOther:
+	default:
+		return fmt.Errorf("unsupported device: %q", rule.Device)
+	}
+	if rule.Country != "" && len(rule.Country) != 2 {
+		return fmt.Errorf("country must be ISO 3166-1 alpha-2 code: %q", rule.Country)
+	}
+	rule.Country = strings.ToUpper(rule.Country)
+	u, err := h.checkURL(rule.URL)
+	if err != nil {
+		return fmt.Errorf("rule url: %w", err)
+	}
+	rule.URL = u
+	return nil
+}
+
+func (h *URLHandlers) validateRedirectRules(rules []repository.RedirectRule) error {
+	if len(rules) > maxRedirectRules {
+		return fmt.Errorf("too many rules: max %d", maxRedirectRules)
+	}
+	for i := range rules {
+		if err := h.validateRedirectRule(&rules[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ownedLink loads link from {id} path param and checks it belongs to current user.
+// Response is already written when false is returned
+func (h *URLHandlers) ownedLink(w http.ResponseWriter, r *http.Request) (repository.Link, string, bool) {
+	userID, ok := getUserID(w, r)
+	if !ok {
+		return repository.Link{}, "", false
+	}
+
+	link, err := h.storage.GetLink(chi.URLParam(r, "id"))
+	if err != nil {
+		writeStorageError(w, err)
+		return repository.Link{}, "", false
+	}
+	if link.UserID != userID {
+		writeStorageError(w, repository.ErrForbidden)
+		return repository.Link{}, "", false
+	}
+	if link.Deleted {
+		writeStorageError(w, repository.ErrDeleted)
+		return repository.Link{}, "", false
+	}
+	return link, userID, true
+}
+
+func writeRules(w http.ResponseWriter, status int, rules []repository.RedirectRule) {
+	if rules == nil {
+		rules = []repository.RedirectRule{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(rules); err != nil {
+		log.Printf("ERROR: cannot encode redirect rules: %v", err)
+	}
+}
+
+func (h *URLHandlers) GetRedirectRules(w http.ResponseWriter, r *http.Request) {
+	link, _, ok := h.ownedLink(w, r)
+	if !ok {
+		return
+	}
+	writeRules(w, http.StatusOK, link.Rules)
+}
+
+// ReplaceRedirectRules sets the whole list of rules, empty list removes all rules
+func (h *URLHandlers) ReplaceRedirectRules(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	link, userID, ok := h.ownedLink(w, r)
+	if !ok {
+		return
+	}
+
+	var rules []repository.RedirectRule
+	if !decodeJSONBody(w, r, &rules) {
+		return
+	}
+	if err := h.validateRedirectRules(rules); err != nil {
+		writeURLError(w, err)
+		return
+	}
+
+	if err := h.storage.UpdateRedirectRules(link.ShortID, userID, rules); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AddRedirectRule appends a rule to the end of the list, ownership and limit are checked by storage
+func (h *URLHandlers) AddRedirectRule(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	userID, ok := getUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var rule repository.RedirectRule
+	if !decodeJSONBody(w, r, &rule) {
+		return
+	}
+	if err := h.validateRedirectRule(&rule); err != nil {
+		writeURLError(w, err)
+		return
+	}
+
+	rules, err := h.storage.AddRedirectRule(chi.URLParam(r, "id"), userID, rule, maxRedirectRules)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeRules(w, http.StatusCreated, rules)
+}
+
+// DeleteRedirectRule removes a rule by its position in the list
+func (h *URLHandlers) DeleteRedirectRule(w http.ResponseWriter, r *http.Request) {
+	userID, ok := getUserID(w, r)
+	if !ok {
+		return
+	}
+
+	index, err := strconv.Atoi(chi.URLParam(r, "index"))
+	if err != nil || index < 0 {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	if err := h.storage.DeleteRedirectRule(chi.URLParam(r, "id"), userID, index); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeJSONBody checks Content-Type and decodes body into v.
+// Response is already written when false is returned
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		BadRequest(w, "wrong Content-Type")
+		return false
+	}
+	if mediaType != "application/json" {
+		BadRequest(w, "Content-Type must be application/json")
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		BadRequest(w, "Cannot read request body")
+		return false
+	}
+	return true
+}
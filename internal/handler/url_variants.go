@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/bissquit/url-shortener/internal/repository"
+)
+
+const (
+	// maxVariants limits destinations count per link
+	maxVariants = 10
+	// variantCookiePrefix + short ID is the name of cookie keeping assigned variant
+	variantCookiePrefix = "ab_"
+	variantCookieTTL    = 30 * 24 * time.Hour
+)
+
+// pickVariant returns variant of a visitor for split link, nil means original URL.
+// Assignment is sticky: it's kept in cookie, so the same visitor gets the same variant.
+// assigned is true when visitor has got variant just now.
+// Nothing is recorded here, see countVariant
+func (h *URLHandlers) pickVariant(r *http.Request, link repository.Link) (variant *repository.Variant, assigned bool) {
+	if len(link.Variants) == 0 {
+		return nil, false
+	}
+
+	if cookie, err := r.Cookie(variantCookiePrefix + link.ShortID); err == nil {
+		for i := range link.Variants {
+			if link.Variants[i].ID == cookie.Value && link.Variants[i].Weight > 0 {
+				return &link.Variants[i], false
+			}
+		}
+	}
+	variant = weightedChoice(link.Variants)
+	return variant, variant != nil
+}
+
+// countVariant records click of variant and keeps new assignment in cookie,
+// it's called for real redirects only
+func (h *URLHandlers) countVariant(w http.ResponseWriter, shortID string, variant *repository.Variant, assigned bool) {
+	if variant == nil {
+		return
+	}
+	if assigned {
+		http.SetCookie(w, &http.Cookie{
+			Name:     variantCookiePrefix + shortID,
+			Value:    variant.ID,
+			Path:     "/" + shortID,
+			Expires:  time.Now().Add(variantCookieTTL),
+			HttpOnly: true,
+		})
+	}
+
+	if err := h.storage.AddVariantClick(shortID, variant.ID); err != nil {
+		// don't break redirect because of statistics
+		log.Printf("ERROR: cannot count click of variant %s/%s: %v", shortID, variant.ID, err)
+	}
+}
+
+// weightedChoice returns nil if there is no variant with positive weight
+func weightedChoice(variants []repository.Variant) *repository.Variant {
+	total := 0
+	for _, v := range variants {
+		total += v.Weight
+	}
+	if total <= 0 {
+		return nil
+	}
+
+	n := rand.IntN(total)
+	for i := range variants {
+		n -= variants[i].Weight
+		if n < 0 {
+			return &variants[i]
+		}
+	}
+	return nil
+}
+
+// validateVariants normalizes variants in place
+func (h *URLHandlers) validateVariants(variants []repository.Variant) error {
+	if len(variants) > maxVariants {
+		return fmt.Errorf("too many variants: max %d", maxVariants)
+	}
+
+	seen := make(map[string]struct{}, len(variants))
+	total := 0
+	for i, v := range variants {
+		if v.ID == "" {
+			return fmt.Errorf("empty variant id")
+		}
+		if _, ok := seen[v.ID]; ok {
+			return fmt.Errorf("duplicated variant id: %q", v.ID)
+		}
+		seen[v.ID] = struct{}{}
+		if v.Weight < 0 {
+			return fmt.Errorf("negative weight of variant %q", v.ID)
+		}
+		total += v.Weight
+		u, err := h.checkURL(v.URL)
+		if err != nil {
+			return fmt.Errorf("variant %q url: %w", v.ID, err)
+		}
+		variants[i].URL = u
+	}
+	if len(variants) > 0 && total == 0 {
+		return fmt.Errorf("at least one variant must have positive weight")
+	}
+	return nil
+}
+
+// GetVariants returns variants with their click counts
+func (h *URLHandlers) GetVariants(w http.ResponseWriter, r *http.Request) {
+	link, _, ok := h.ownedLink(w, r)
+	if !ok {
+		return
+	}
+
+	variants := link.Variants
+	if variants == nil {
+		variants = []repository.Variant{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(variants); err != nil {
+		log.Printf("ERROR: cannot encode variants: %v", err)
+	}
+}
+
+// ReplaceVariants sets the whole list of variants, empty list turns split off
+func (h *URLHandlers) ReplaceVariants(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	link, userID, ok := h.ownedLink(w, r)
+	if !ok {
+		return
+	}
+
+	var variants []repository.Variant
+	if !decodeJSONBody(w, r, &variants) {
+		return
+	}
+	if err := h.validateVariants(variants); err != nil {
+		writeURLError(w, err)
+		return
+	}
+
+	if err := h.storage.UpdateVariants(link.ShortID, userID, variants); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -6,10 +6,24 @@ import (
 	"time"
 
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 var sugar *zap.SugaredLogger
 
+// level may be changed at runtime by SetLevel
+var level = zap.NewAtomicLevelAt(zapcore.InfoLevel)
+
+// SetLevel changes level of request logs: debug, info, warn or error
+func SetLevel(name string) error {
+	l, err := zapcore.ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	level.SetLevel(l)
+	return nil
+}
+
 type (
 	// create struct to store data from response
 	responseData struct {
@@ -51,6 +65,11 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	}
 }
 
+// Unwrap lets http.ResponseController reach Flush and other optional methods
+func (r *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
 func WithLogging(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -86,7 +105,9 @@ func WithLogging(h http.Handler) http.Handler {
 // - executed before the main() function
 // - used to initialize global state
 func init() {
-	logger, err := zap.NewDevelopment()
+	cfg := zap.NewDevelopmentConfig()
+	cfg.Level = level
+	logger, err := cfg.Build()
 	if err != nil {
 		log.Printf("Failed to create zap logger: %v", err)
 		logger = zap.NewNop()
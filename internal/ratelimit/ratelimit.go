@@ -0,0 +1,54 @@
+// Package ratelimit limits events per key (e.g. client IP) in fixed time windows
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter allows up to limit events per key in every window.
+// All counters are dropped when window ends, so memory is bounded
+// by keys seen during one window. A nil Limiter allows everything
+type Limiter struct {
+	limit  int
+	window time.Duration
+	// now is replaced in tests
+	now func() time.Time
+
+	mux    sync.Mutex
+	start  time.Time
+	counts map[string]int
+}
+
+// New returns nil (no limit) when limit is not positive
+func New(limit int, window time.Duration) *Limiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &Limiter{
+		limit:  limit,
+		window: window,
+		now:    time.Now,
+		counts: make(map[string]int),
+	}
+}
+
+// Allow counts event of key and reports whether it's within the limit
+func (l *Limiter) Allow(key string) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if now := l.now(); now.Sub(l.start) >= l.window {
+		l.start = now
+		clear(l.counts)
+	}
+	if l.counts[key] >= l.limit {
+		return false
+	}
+	l.counts[key]++
+	return true
+}
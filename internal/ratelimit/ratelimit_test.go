@@ -0,0 +1,30 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Limiter(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := New(2, time.Minute)
+	l.now = func() time.Time { return now }
+
+	assert.True(t, l.Allow("a"))
+	assert.True(t, l.Allow("a"))
+	assert.False(t, l.Allow("a"), "limit is reached")
+	assert.True(t, l.Allow("b"), "keys are counted apart")
+
+	now = now.Add(time.Minute)
+	assert.True(t, l.Allow("a"), "counters are dropped with window")
+}
+
+func Test_LimiterNil(t *testing.T) {
+	l := New(0, time.Minute)
+	assert.Nil(t, l)
+	for range 10 {
+		assert.True(t, l.Allow("a"))
+	}
+}
@@ -0,0 +1,217 @@
+// Package cache provides read-through cache decorator for any repository.URLRepository
+package cache
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bissquit/url-shortener/internal/repository"
+)
+
+// Storage caches GetLink (and GetURLByID based on it) results in size-bounded LRU.
+// ErrNotFound is cached too with its own TTL. Own write methods invalidate
+// affected links, changes made by other instances are visible after TTL only
+// (see Invalidate and Purge for external invalidation).
+// Variant clicks are not invalidated, so they may be stale for TTL
+type Storage struct {
+	repository.URLRepository
+
+	mux   sync.Mutex
+	items map[string]*list.Element
+	// front is the most recently used
+	order *list.List
+	// loading tracks links being loaded from storage, so a value loaded
+	// before invalidation of its link is not put into cache
+	loading map[string]*load
+	// epoch is changed by Purge, which invalidates all links
+	epoch uint64
+
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	// now is replaced in tests
+	now func() time.Time
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+}
+
+// load is kept while there are loaders of a link, so the map doesn't grow
+type load struct {
+	loaders int
+	// version is changed by invalidation of the link
+	version uint64
+}
+
+// loadTicket is taken by loader before reading from storage
+type loadTicket struct {
+	id      string
+	version uint64
+	epoch   uint64
+}
+
+type entry struct {
+	id      string
+	link    repository.Link
+	err     error
+	expires time.Time
+}
+
+// Stats are hit/miss metrics of cache
+type Stats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+	Size      int   `json:"size"`
+}
+
+// New wraps storage with cache of size links, negativeTTL <= 0 turns negative caching off
+func New(storage repository.URLRepository, size int, ttl, negativeTTL time.Duration) *Storage {
+	return &Storage{
+		URLRepository: storage,
+		items:         make(map[string]*list.Element),
+		loading:       make(map[string]*load),
+		order:         list.New(),
+		size:          size,
+		ttl:           ttl,
+		negativeTTL:   negativeTTL,
+		now:           time.Now,
+	}
+}
+
+func (s *Storage) GetLink(id string) (repository.Link, error) {
+	s.mux.Lock()
+	if el, ok := s.items[id]; ok {
+		e := el.Value.(*entry)
+		if s.now().Before(e.expires) {
+			s.order.MoveToFront(el)
+			s.mux.Unlock()
+			s.hits.Add(1)
+			return copyLink(e.link), e.err
+		}
+		s.remove(el)
+	}
+	ticket := s.startLoad(id)
+	s.mux.Unlock()
+	s.misses.Add(1)
+
+	link, err := s.URLRepository.GetLink(id)
+	switch {
+	case err == nil:
+		s.put(ticket, &entry{id: id, link: copyLink(link), expires: s.now().Add(s.ttl)})
+	case errors.Is(err, repository.ErrNotFound) && s.negativeTTL > 0:
+		s.put(ticket, &entry{id: id, err: err, expires: s.now().Add(s.negativeTTL)})
+	default:
+		s.put(ticket, nil)
+	}
+	return link, err
+}
+
+// startLoad registers a loader of link
+// be careful: Lock is required but not acquired here
+func (s *Storage) startLoad(id string) loadTicket {
+	l, ok := s.loading[id]
+	if !ok {
+		l = &load{}
+		s.loading[id] = l
+	}
+	l.loaders++
+	return loadTicket{id: id, version: l.version, epoch: s.epoch}
+}
+
+// GetURLByID is served by cached GetLink
+func (s *Storage) GetURLByID(id string) (string, error) {
+	link, err := s.GetLink(id)
+	if err != nil {
+		return "", err
+	}
+	if link.Deleted {
+		return "", repository.ErrDeleted
+	}
+	if link.Banned {
+		return "", repository.ErrBanned
+	}
+	return link.OriginalURL, nil
+}
+
+// put finishes load of ticket, nil e is not cached
+func (s *Storage) put(ticket loadTicket, e *entry) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	l := s.loading[ticket.id]
+	l.loaders--
+	if l.loaders == 0 {
+		delete(s.loading, ticket.id)
+	}
+	// the link might be changed while it was loading
+	if e == nil || l.version != ticket.version || s.epoch != ticket.epoch {
+		return
+	}
+	if el, ok := s.items[e.id]; ok {
+		s.remove(el)
+	}
+	s.items[e.id] = s.order.PushFront(e)
+
+	for s.order.Len() > s.size {
+		s.remove(s.order.Back())
+		s.evictions.Add(1)
+	}
+}
+
+// remove deletes element from cache
+// be careful: Lock is required but not acquired here
+func (s *Storage) remove(el *list.Element) {
+	s.order.Remove(el)
+	delete(s.items, el.Value.(*entry).id)
+}
+
+// Invalidate evicts links from cache
+func (s *Storage) Invalidate(ids ...string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for _, id := range ids {
+		if l, ok := s.loading[id]; ok {
+			l.version++
+		}
+		if el, ok := s.items[id]; ok {
+			s.remove(el)
+		}
+	}
+}
+
+// Purge evicts all links from cache
+func (s *Storage) Purge() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.epoch++
+	s.items = make(map[string]*list.Element)
+	s.order.Init()
+}
+
+func (s *Storage) Stats() Stats {
+	s.mux.Lock()
+	size := s.order.Len()
+	s.mux.Unlock()
+
+	return Stats{
+		Hits:      s.hits.Load(),
+		Misses:    s.misses.Load(),
+		Evictions: s.evictions.Load(),
+		Size:      size,
+	}
+}
+
+// copyLink protects cached value from changes made by caller
+func copyLink(link repository.Link) repository.Link {
+	link.Settings = link.Settings.Clone()
+	link.Rules = append([]repository.RedirectRule(nil), link.Rules...)
+	link.Variants = append([]repository.Variant(nil), link.Variants...)
+	return link
+}
@@ -0,0 +1,215 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/bissquit/url-shortener/internal/repository/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStorage counts GetLink calls which reach storage
+type countingStorage struct {
+	*memory.URLStorage
+	calls int
+	// onGet is called before reading link
+	onGet func()
+}
+
+func (s *countingStorage) GetLink(id string) (repository.Link, error) {
+	s.calls++
+	if s.onGet != nil {
+		s.onGet()
+	}
+	return s.URLStorage.GetLink(id)
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestCache(t *testing.T, size int) (*Storage, *countingStorage, *fakeClock) {
+	t.Helper()
+	inner := &countingStorage{URLStorage: memory.NewURLStorage()}
+	require.NoError(t, inner.Create("id1", "https://example.com", "user", repository.LinkSettings{}))
+	require.NoError(t, inner.Create("id2", "https://example.org", "user", repository.LinkSettings{}))
+	require.NoError(t, inner.Create("id3", "https://example.net", "user", repository.LinkSettings{}))
+
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c := New(inner, size, time.Minute, 10*time.Second)
+	c.now = clock.Now
+	return c, inner, clock
+}
+
+func Test_CacheHitAndTTL(t *testing.T) {
+	c, inner, clock := newTestCache(t, 10)
+
+	for range 3 {
+		link, err := c.GetLink("id1")
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com", link.OriginalURL)
+	}
+	assert.Equal(t, 1, inner.calls)
+	assert.Equal(t, Stats{Hits: 2, Misses: 1, Size: 1}, c.Stats())
+
+	// expired
+	clock.now = clock.now.Add(time.Minute)
+	_, err := c.GetLink("id1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, inner.calls)
+}
+
+func Test_CacheNegative(t *testing.T) {
+	c, inner, clock := newTestCache(t, 10)
+
+	for range 2 {
+		_, err := c.GetURLByID("unexisted")
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+	}
+	assert.Equal(t, 1, inner.calls)
+
+	// negative TTL is shorter
+	clock.now = clock.now.Add(10 * time.Second)
+	_, err := c.GetLink("unexisted")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	assert.Equal(t, 2, inner.calls)
+
+	// creating evicts cached "not found"
+	require.NoError(t, c.Create("unexisted", "https://example.info", "user", repository.LinkSettings{}))
+	u, err := c.GetURLByID("unexisted")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.info", u)
+}
+
+func Test_CacheInvalidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Storage) error
+		check  func(t *testing.T, link repository.Link, err error)
+	}{
+		{
+			name: "delete batch",
+			change: func(c *Storage) error {
+				return c.DeleteBatch("user", []string{"id1"})
+			},
+			check: func(t *testing.T, link repository.Link, err error) {
+				require.NoError(t, err)
+				assert.True(t, link.Deleted)
+			},
+		},
+		{
+			name: "settings update",
+			change: func(c *Storage) error {
+				return c.UpdateLinkSettings("id1", "user", repository.LinkSettings{Preview: true})
+			},
+			check: func(t *testing.T, link repository.Link, err error) {
+				require.NoError(t, err)
+				assert.True(t, link.Settings.Preview)
+			},
+		},
+		{
+			name: "ban",
+			change: func(c *Storage) error {
+				return c.SetBanned("id1", true)
+			},
+			check: func(t *testing.T, link repository.Link, err error) {
+				require.NoError(t, err)
+				assert.True(t, link.Banned)
+			},
+		},
+		{
+			name: "user disabled",
+			change: func(c *Storage) error {
+				_, err := c.DeleteByUserID("user")
+				return err
+			},
+			check: func(t *testing.T, link repository.Link, err error) {
+				require.NoError(t, err)
+				assert.True(t, link.Deleted)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _, _ := newTestCache(t, 10)
+			_, err := c.GetLink("id1")
+			require.NoError(t, err)
+
+			require.NoError(t, tt.change(c))
+
+			link, err := c.GetLink("id1")
+			tt.check(t, link, err)
+		})
+	}
+}
+
+func Test_CacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c, inner, _ := newTestCache(t, 2)
+
+	for _, id := range []string{"id1", "id2", "id1", "id3"} {
+		_, err := c.GetLink(id)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 3, inner.calls)
+	assert.Equal(t, int64(1), c.Stats().Evictions)
+
+	// id2 is evicted, id1 is still cached
+	_, err := c.GetLink("id1")
+	require.NoError(t, err)
+	assert.Equal(t, 3, inner.calls)
+	_, err = c.GetLink("id2")
+	require.NoError(t, err)
+	assert.Equal(t, 4, inner.calls)
+}
+
+func Test_CacheSkipsValueChangedWhileLoading(t *testing.T) {
+	tests := []struct {
+		name string
+		// change is made by another instance (or goroutine) while id1 is loading
+		change   func(c *Storage)
+		wantSize int
+	}{
+		{
+			name:     "link is invalidated",
+			change:   func(c *Storage) { c.Invalidate("id1") },
+			wantSize: 0,
+		},
+		{
+			name:     "cache is purged",
+			change:   func(c *Storage) { c.Purge() },
+			wantSize: 0,
+		},
+		{
+			name:     "another link is invalidated",
+			change:   func(c *Storage) { c.Invalidate("id2") },
+			wantSize: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, inner, _ := newTestCache(t, 10)
+			inner.onGet = func() {
+				inner.onGet = nil
+				tt.change(c)
+			}
+
+			_, err := c.GetLink("id1")
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantSize, c.Stats().Size)
+			assert.Empty(t, c.loading)
+
+			// the next load is cached
+			_, err = c.GetLink("id1")
+			require.NoError(t, err)
+			assert.Equal(t, 1, c.Stats().Size)
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/bissquit/url-shortener/internal/repository"
+)
+
+// write methods evict links after successful change, even negative cache
+// must be evicted on create
+
+func (s *Storage) Create(id, originalURL, userID string, settings repository.LinkSettings) error {
+	if err := s.URLRepository.Create(id, originalURL, userID, settings); err != nil {
+		return err
+	}
+	s.Invalidate(id)
+	return nil
+}
+
+func (s *Storage) CreateBatch(items []repository.URLItem, userID string) error {
+	if err := s.URLRepository.CreateBatch(items, userID); err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	s.Invalidate(ids...)
+	return nil
+}
+
+func (s *Storage) PutLinks(links []repository.Link) error {
+	if err := s.URLRepository.PutLinks(links); err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(links))
+	for _, link := range links {
+		ids = append(ids, link.ShortID)
+	}
+	s.Invalidate(ids...)
+	return nil
+}
+
+// RestoreLinks purges the whole cache, because negative cache may have any of restored IDs
+func (s *Storage) RestoreLinks(ctx context.Context, fill func(put func([]repository.Link) error) error) error {
+	if err := s.URLRepository.RestoreLinks(ctx, fill); err != nil {
+		return err
+	}
+	s.Purge()
+	return nil
+}
+
+func (s *Storage) UpdateLinkSettings(id, userID string, settings repository.LinkSettings) error {
+	if err := s.URLRepository.UpdateLinkSettings(id, userID, settings); err != nil {
+		return err
+	}
+	s.Invalidate(id)
+	return nil
+}
+
+func (s *Storage) UpdateRedirectRules(id, userID string, rules []repository.RedirectRule) error {
+	if err := s.URLRepository.UpdateRedirectRules(id, userID, rules); err != nil {
+		return err
+	}
+	s.Invalidate(id)
+	return nil
+}
+
+func (s *Storage) AddRedirectRule(id, userID string, rule repository.RedirectRule, maxRules int) ([]repository.RedirectRule, error) {
+	rules, err := s.URLRepository.AddRedirectRule(id, userID, rule, maxRules)
+	if err != nil {
+		return nil, err
+	}
+	s.Invalidate(id)
+	return rules, nil
+}
+
+func (s *Storage) DeleteRedirectRule(id, userID string, index int) error {
+	if err := s.URLRepository.DeleteRedirectRule(id, userID, index); err != nil {
+		return err
+	}
+	s.Invalidate(id)
+	return nil
+}
+
+func (s *Storage) UpdateVariants(id, userID string, variants []repository.Variant) error {
+	if err := s.URLRepository.UpdateVariants(id, userID, variants); err != nil {
+		return err
+	}
+	s.Invalidate(id)
+	return nil
+}
+
+func (s *Storage) DeleteBatch(userID string, ids []string) error {
+	if err := s.URLRepository.DeleteBatch(userID, ids); err != nil {
+		return err
+	}
+	s.Invalidate(ids...)
+	return nil
+}
+
+func (s *Storage) SetBanned(id string, banned bool) error {
+	if err := s.URLRepository.SetBanned(id, banned); err != nil {
+		return err
+	}
+	s.Invalidate(id)
+	return nil
+}
+
+func (s *Storage) ForceDelete(id string) error {
+	if err := s.URLRepository.ForceDelete(id); err != nil {
+		return err
+	}
+	s.Invalidate(id)
+	return nil
+}
+
+// DeleteByUserID purges the whole cache, because deleted IDs are unknown
+func (s *Storage) DeleteByUserID(userID string) (int64, error) {
+	n, err := s.URLRepository.DeleteByUserID(userID)
+	if err != nil {
+		return n, err
+	}
+	if n > 0 {
+		s.Purge()
+	}
+	return n, nil
+}
+
+func (s *Storage) ResolveReport(reportID int64, status string) (repository.Report, error) {
+	report, err := s.URLRepository.ResolveReport(reportID, status)
+	if err != nil {
+		return report, err
+	}
+	s.Invalidate(report.ShortID)
+	return report, nil
+}
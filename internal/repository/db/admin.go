@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/jackc/pgx/v5"
+)
+
+func (s *PGStorage) SearchLinks(filter repository.LinkFilter) ([]repository.Link, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// NULL limit means no limit
+	var limit *int
+	if filter.Limit > 0 {
+		limit = &filter.Limit
+	}
+
+	rows, err := s.pool.Query(ctx, "SELECT "+linkColumns+` FROM urls
+		WHERE ($1 = '' OR short_id = $1 OR strpos(lower(original_url), lower($1)) > 0)
+			AND ($2 = '' OR user_id = $2)
+			AND ($3 OR NOT is_deleted)
+		ORDER BY short_id
+		LIMIT $4 OFFSET $5`,
+		filter.Query, filter.UserID, filter.IncludeDeleted, limit, filter.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []repository.Link
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func (s *PGStorage) SetBanned(id string, banned bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var owner string
+	err := s.pool.QueryRow(ctx,
+		"UPDATE urls SET is_banned = $1 WHERE short_id = $2 RETURNING COALESCE(user_id, '')", banned, id,
+	).Scan(&owner)
+	if err == pgx.ErrNoRows {
+		return repository.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	s.notifyChangedAfter(id)
+	s.wrote(owner)
+	return nil
+}
+
+func (s *PGStorage) ForceDelete(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var owner string
+	err := s.pool.QueryRow(ctx,
+		"UPDATE urls SET is_deleted = TRUE WHERE short_id = $1 RETURNING COALESCE(user_id, '')", id,
+	).Scan(&owner)
+	if err == pgx.ErrNoRows {
+		return repository.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	s.notifyChangedAfter(id)
+	s.wrote(owner)
+	return nil
+}
+
+func (s *PGStorage) DeleteByUserID(userID string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tag, err := s.pool.Exec(ctx,
+		"UPDATE urls SET is_deleted = TRUE WHERE user_id = $1 AND NOT is_deleted", userID)
+	if err != nil {
+		return 0, err
+	}
+	// deleted IDs are not known
+	if tag.RowsAffected() > 0 {
+		s.notifyPurge()
+		s.wrote(userID)
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (s *PGStorage) GetStats() (repository.Stats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var stats repository.Stats
+	err := s.pool.QueryRow(ctx, `
+		SELECT
+			count(*),
+			count(*) FILTER (WHERE NOT is_deleted AND NOT is_banned),
+			count(*) FILTER (WHERE is_deleted),
+			count(*) FILTER (WHERE is_banned),
+			count(DISTINCT user_id) FILTER (WHERE NOT is_deleted AND NOT is_banned)
+		FROM urls`,
+	).Scan(&stats.Total, &stats.Live, &stats.Deleted, &stats.Banned, &stats.Users)
+	return stats, err
+}
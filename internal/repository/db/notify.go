@@ -0,0 +1,179 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ChangesChannel gets short IDs of changed links, so other instances may evict them from cache
+const ChangesChannel = "url_changes"
+
+const (
+	// purgePayload means that changed IDs are unknown
+	purgePayload = "*"
+	// NOTIFY payload must be shorter than 8000 bytes
+	maxPayloadLength = 7900
+)
+
+// Invalidator is a local cache of links (see cache.Storage)
+type Invalidator interface {
+	Invalidate(ids ...string)
+	Purge()
+}
+
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// changesPayloads packs comma separated IDs into as few payloads as possible
+func changesPayloads(ids []string) []string {
+	var (
+		payloads []string
+		b        strings.Builder
+	)
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+		if b.Len() > 0 && b.Len()+1+len(id) > maxPayloadLength {
+			payloads = append(payloads, b.String())
+			b.Reset()
+		}
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(id)
+	}
+	if b.Len() > 0 {
+		payloads = append(payloads, b.String())
+	}
+	return payloads
+}
+
+// parseChangesPayload returns nil IDs and true if everything should be evicted
+func parseChangesPayload(payload string) ([]string, bool) {
+	if payload == purgePayload || payload == "" {
+		return nil, true
+	}
+	return strings.Split(payload, ","), false
+}
+
+// NotifyChanges turns on notifications about changed links. They are needed by
+// instances which cache links only, so instances sharing database should turn cache on alike:
+// changes made by an instance without cache are seen by others after cache TTL
+func (s *PGStorage) NotifyChanges() {
+	s.notify.Store(true)
+}
+
+// notifyChanged is called inside transaction of change when there is one,
+// then notification is sent on commit only
+func (s *PGStorage) notifyChanged(ctx context.Context, q execer, ids ...string) error {
+	if !s.notify.Load() {
+		return nil
+	}
+	for _, payload := range changesPayloads(ids) {
+		if _, err := q.Exec(ctx, "SELECT pg_notify($1, $2)", ChangesChannel, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifyChangedAfter is used by single statement changes which are already committed.
+// Failed notification is only logged: change is done and other instances see it after cache TTL
+func (s *PGStorage) notifyChangedAfter(ids ...string) {
+	if !s.notify.Load() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := s.notifyChanged(ctx, s.pool, ids...); err != nil {
+		log.Printf("cannot notify about changed links: %v", err)
+	}
+}
+
+func (s *PGStorage) notifyPurge() {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := s.notifyPurgeIn(ctx, s.pool); err != nil {
+		log.Printf("cannot notify about changed links: %v", err)
+	}
+}
+
+// notifyPurgeIn asks to evict all links, it's sent on commit when q is transaction
+func (s *PGStorage) notifyPurgeIn(ctx context.Context, q execer) error {
+	if !s.notify.Load() {
+		return nil
+	}
+	_, err := q.Exec(ctx, "SELECT pg_notify($1, $2)", ChangesChannel, purgePayload)
+	return err
+}
+
+const (
+	listenMinBackoff = time.Second
+	listenMaxBackoff = 30 * time.Second
+)
+
+// ListenChanges evicts links changed by any instance from cache until ctx is done.
+// Notifications are lost while connection is down, so cache is purged on every (re)connect
+func (s *PGStorage) ListenChanges(ctx context.Context, cache Invalidator) {
+	backoff := listenMinBackoff
+	for {
+		err := s.listen(ctx, cache, func() { backoff = listenMinBackoff })
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("listening of %s is interrupted, reconnect in %s: %v", ChangesChannel, backoff, err)
+		cache.Purge()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, listenMaxBackoff)
+	}
+}
+
+// listen holds one pool connection and returns on its failure
+func (s *PGStorage) listen(ctx context.Context, cache Invalidator, connected func()) error {
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// connection in LISTEN state must not be reused by pool
+	defer func() {
+		conn.Hijack().Close(context.Background())
+	}()
+
+	if _, err = conn.Exec(ctx, "LISTEN "+ChangesChannel); err != nil {
+		return err
+	}
+	// changes made before LISTEN are unknown
+	cache.Purge()
+	connected()
+
+	for {
+		n, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		ids, purge := parseChangesPayload(n.Payload)
+		if purge {
+			cache.Purge()
+			continue
+		}
+		cache.Invalidate(ids...)
+	}
+}
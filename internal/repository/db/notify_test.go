@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_changesPayloads(t *testing.T) {
+	// 12 characters as generated IDs
+	long := make([]string, 1000)
+	for i := range long {
+		long[i] = strings.Repeat("a", 12)
+	}
+
+	tests := []struct {
+		name         string
+		ids          []string
+		wantPayloads int
+	}{
+		{
+			name:         "nothing changed",
+			ids:          nil,
+			wantPayloads: 0,
+		},
+		{
+			name:         "single id",
+			ids:          []string{"abc"},
+			wantPayloads: 1,
+		},
+		{
+			name:         "empty ids are skipped",
+			ids:          []string{"", "abc", ""},
+			wantPayloads: 1,
+		},
+		{
+			name:         "ids exceed payload limit",
+			ids:          long,
+			wantPayloads: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payloads := changesPayloads(tt.ids)
+			assert.Len(t, payloads, tt.wantPayloads)
+
+			var got []string
+			for _, payload := range payloads {
+				assert.LessOrEqual(t, len(payload), maxPayloadLength)
+				ids, purge := parseChangesPayload(payload)
+				assert.False(t, purge)
+				got = append(got, ids...)
+			}
+			var want []string
+			for _, id := range tt.ids {
+				if id != "" {
+					want = append(want, id)
+				}
+			}
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+func Test_parseChangesPayloadPurge(t *testing.T) {
+	ids, purge := parseChangesPayload(purgePayload)
+	assert.True(t, purge)
+	assert.Nil(t, ids)
+}
+
+// countingExecer counts statements instead of sending them
+type countingExecer struct {
+	calls int
+}
+
+func (e *countingExecer) Exec(context.Context, string, ...any) (pgconn.CommandTag, error) {
+	e.calls++
+	return pgconn.CommandTag{}, nil
+}
+
+func Test_notifyChangedIsOffByDefault(t *testing.T) {
+	s := &PGStorage{}
+	q := &countingExecer{}
+	require.NoError(t, s.notifyChanged(context.Background(), q, "abc"))
+	require.NoError(t, s.notifyPurgeIn(context.Background(), q))
+	assert.Zero(t, q.calls)
+
+	s.NotifyChanges()
+	require.NoError(t, s.notifyChanged(context.Background(), q, "abc"))
+	require.NoError(t, s.notifyPurgeIn(context.Background(), q))
+	assert.Equal(t, 2, q.calls)
+}
@@ -0,0 +1,209 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// readYourWritesWindow is how long reads of a user who has changed links go to primary,
+// it should be longer than usual replication lag. Writers are remembered by this process only,
+// so reads served by another instance may still go to a lagging replica
+const readYourWritesWindow = 10 * time.Second
+
+// replica is a read-only pool, unhealthy replicas are skipped until the next successful ping
+type replica struct {
+	name    string
+	pool    *pgxpool.Pool
+	healthy atomic.Bool
+}
+
+// querier is implemented by both primary and replica pools
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// PoolHealth is a result of a single pool ping
+type PoolHealth struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// recentWriters remembers users who have changed their links recently
+type recentWriters struct {
+	mux   sync.Mutex
+	users map[string]time.Time
+	// lastSweep limits how often expired users are removed
+	lastSweep time.Time
+}
+
+func (w *recentWriters) add(userIDs ...string) {
+	now := time.Now()
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if w.users == nil {
+		w.users = make(map[string]time.Time)
+	}
+	if now.Sub(w.lastSweep) > readYourWritesWindow {
+		for userID, at := range w.users {
+			if now.Sub(at) > readYourWritesWindow {
+				delete(w.users, userID)
+			}
+		}
+		w.lastSweep = now
+	}
+	for _, userID := range userIDs {
+		if userID != "" {
+			w.users[userID] = now
+		}
+	}
+}
+
+func (w *recentWriters) has(userID string) bool {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	at, ok := w.users[userID]
+	return ok && time.Since(at) <= readYourWritesWindow
+}
+
+// wrote makes reads of users go to primary for a while
+func (s *PGStorage) wrote(userIDs ...string) {
+	if len(s.replicas) > 0 {
+		s.writers.add(userIDs...)
+	}
+}
+
+// pickReplica returns the next healthy replica or nil if reads of userID must go to primary
+func (s *PGStorage) pickReplica(userID string) *replica {
+	if len(s.replicas) == 0 || (userID != "" && s.writers.has(userID)) {
+		return nil
+	}
+	start := s.nextReplica.Add(1)
+	for i := range s.replicas {
+		r := s.replicas[(int(start)+i)%len(s.replicas)]
+		if r.healthy.Load() {
+			return r
+		}
+	}
+	return nil
+}
+
+// readReplica runs read on a replica falling back to primary.
+// Not found on replica is checked on primary too, because link may be not replicated yet.
+// Any other replica failure marks it unhealthy
+func readReplica[T any](s *PGStorage, userID string, read func(ctx context.Context, q querier) (T, error)) (T, error) {
+	if r := s.pickReplica(userID); r != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		v, err := read(ctx, r.pool)
+		cancel()
+
+		switch {
+		case err == nil, errors.Is(err, repository.ErrDeleted), errors.Is(err, repository.ErrBanned):
+			return v, err
+		case !errors.Is(err, repository.ErrNotFound) && isReplicaFailure(err):
+			log.Printf("replica %s is unhealthy, reading from primary: %v", r.name, err)
+			r.healthy.Store(false)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return read(ctx, s.pool)
+}
+
+// readLink reads link like readReplica, but link of a user who has changed links recently
+// is read again on primary, so owner changes are seen at once by anyone reading the link
+func readLink(s *PGStorage, read func(ctx context.Context, q querier) (repository.Link, error)) (repository.Link, error) {
+	link, err := readReplica(s, "", read)
+	if err != nil || link.UserID == "" || !s.writers.has(link.UserID) {
+		return link, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return read(ctx, s.pool)
+}
+
+// isReplicaFailure separates broken replica from errors primary would return too.
+// Canceled or timed out read doesn't mean replica is down, monitor pings it anyway
+func isReplicaFailure(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || pgconn.Timeout(err) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// e.g. query canceled by conflict with recovery, or schema is not replicated yet
+		return pgerrcode.IsConnectionException(pgErr.Code) ||
+			pgerrcode.IsOperatorIntervention(pgErr.Code) ||
+			pgErr.Code == pgerrcode.SerializationFailure ||
+			pgErr.Code == pgerrcode.UndefinedTable ||
+			pgErr.Code == pgerrcode.UndefinedColumn
+	}
+	return true
+}
+
+// CheckPools pings primary and every replica. Replica health used for routing is updated too
+func (s *PGStorage) CheckPools(ctx context.Context) []PoolHealth {
+	health := []PoolHealth{ping(ctx, "primary", s.pool)}
+	for _, r := range s.replicas {
+		h := ping(ctx, r.name, r.pool)
+		if r.healthy.Swap(h.Healthy) != h.Healthy {
+			log.Printf("replica %s healthy: %t", r.name, h.Healthy)
+		}
+		health = append(health, h)
+	}
+	return health
+}
+
+func ping(ctx context.Context, name string, pool *pgxpool.Pool) PoolHealth {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	// raw errors may have hosts and users of DSN, they are logged only
+	if err := pool.Ping(ctx); err != nil {
+		log.Printf("ping of %s failed: %v", name, err)
+		if errors.Is(err, context.DeadlineExceeded) || pgconn.Timeout(err) {
+			return PoolHealth{Name: name, Error: "timeout"}
+		}
+		return PoolHealth{Name: name, Error: "unavailable"}
+	}
+	return PoolHealth{Name: name, Healthy: true}
+}
+
+// MonitorReplicas pings pools every interval until ctx is done,
+// so unhealthy replicas return to rotation once they are back
+func (s *PGStorage) MonitorReplicas(ctx context.Context, interval time.Duration) {
+	if len(s.replicas) == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.CheckPools(ctx)
+		}
+	}
+}
+
+func replicaName(i int) string {
+	return fmt.Sprintf("replica-%d", i+1)
+}
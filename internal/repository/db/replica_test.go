@@ -0,0 +1,127 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_pickReplica(t *testing.T) {
+	// pools are not used to pick a replica
+	s := NewDBStorage(nil, nil, nil)
+	require.Len(t, s.replicas, 2)
+
+	// replicas are used in turn
+	first, second := s.pickReplica(""), s.pickReplica("")
+	require.NotNil(t, first)
+	require.NotNil(t, second)
+	assert.NotEqual(t, first.name, second.name)
+
+	// unhealthy replica is skipped
+	s.replicas[0].healthy.Store(false)
+	for range 3 {
+		assert.Equal(t, "replica-2", s.pickReplica("").name)
+	}
+
+	// primary is used when all replicas are down
+	s.replicas[1].healthy.Store(false)
+	assert.Nil(t, s.pickReplica(""))
+}
+
+func Test_pickReplicaReadYourWrites(t *testing.T) {
+	s := NewDBStorage(nil, nil)
+
+	s.wrote("writer")
+	assert.Nil(t, s.pickReplica("writer"))
+	assert.NotNil(t, s.pickReplica("reader"))
+	// reads not bound to user are not affected
+	assert.NotNil(t, s.pickReplica(""))
+}
+
+func Test_pickReplicaWithoutReplicas(t *testing.T) {
+	s := NewDBStorage(nil)
+
+	s.wrote("writer")
+	assert.Nil(t, s.pickReplica(""))
+	// nothing is remembered without replicas
+	assert.Empty(t, s.writers.users)
+}
+
+func Test_readLinkReadYourWrites(t *testing.T) {
+	s := NewDBStorage(nil, nil)
+	s.wrote("writer")
+
+	tests := []struct {
+		name  string
+		owner string
+		reads int
+	}{
+		{name: "link of another user is read on replica", owner: "reader", reads: 1},
+		{name: "link of recent writer is read again on primary", owner: "writer", reads: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reads := 0
+			link, err := readLink(s, func(context.Context, querier) (repository.Link, error) {
+				reads++
+				return repository.Link{ShortID: "id", UserID: tt.owner}, nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, "id", link.ShortID)
+			assert.Equal(t, tt.reads, reads)
+		})
+	}
+}
+
+func Test_isReplicaFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "connection lost",
+			err:  &pgconn.PgError{Code: pgerrcode.ConnectionFailure},
+			want: true,
+		},
+		{
+			name: "conflict with recovery",
+			err:  &pgconn.PgError{Code: pgerrcode.SerializationFailure},
+			want: true,
+		},
+		{
+			name: "error primary would return too",
+			err:  &pgconn.PgError{Code: pgerrcode.InvalidTextRepresentation},
+			want: false,
+		},
+		{
+			name: "read is canceled",
+			err:  fmt.Errorf("query: %w", context.Canceled),
+			want: false,
+		},
+		{
+			name: "read is timed out",
+			err:  fmt.Errorf("query: %w", context.DeadlineExceeded),
+			want: false,
+		},
+		{
+			name: "network error",
+			err:  errors.New("dial tcp: connection refused"),
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isReplicaFailure(tt.err))
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/jackc/pgx/v5"
+)
+
+const reportColumns = "id, short_id, reason, reporter_id, status, created_at, resolved_at"
+
+func scanReport(row pgx.Row) (repository.Report, error) {
+	var (
+		report     repository.Report
+		resolvedAt *time.Time
+	)
+	err := row.Scan(&report.ID, &report.ShortID, &report.Reason, &report.ReporterID,
+		&report.Status, &report.CreatedAt, &resolvedAt)
+	if err != nil {
+		return repository.Report{}, err
+	}
+	if resolvedAt != nil {
+		report.ResolvedAt = resolvedAt.UTC()
+	}
+	report.CreatedAt = report.CreatedAt.UTC()
+	return report, nil
+}
+
+func (s *PGStorage) CreateReport(report repository.Report, maxOpen int) (repository.Report, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return repository.Report{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	// link row lock serializes reports of the link, so open reports are counted reliably
+	var deleted bool
+	err = tx.QueryRow(ctx, "SELECT is_deleted FROM urls WHERE short_id = $1 FOR NO KEY UPDATE",
+		report.ShortID).Scan(&deleted)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return repository.Report{}, repository.ErrNotFound
+	}
+	if err != nil {
+		return repository.Report{}, err
+	}
+	if deleted {
+		return repository.Report{}, repository.ErrDeleted
+	}
+
+	// reporter has already reported the link
+	existing, err := scanReport(tx.QueryRow(ctx, "SELECT "+reportColumns+
+		" FROM reports WHERE short_id = $1 AND reporter_id = $2 AND status = 'open'",
+		report.ShortID, report.ReporterID))
+	if err == nil {
+		return existing, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return repository.Report{}, err
+	}
+
+	var open int
+	err = tx.QueryRow(ctx, "SELECT count(*) FROM reports WHERE short_id = $1 AND status = 'open'",
+		report.ShortID).Scan(&open)
+	if err != nil {
+		return repository.Report{}, err
+	}
+	if open >= maxOpen {
+		return repository.Report{}, fmt.Errorf("%w: max %d", repository.ErrTooManyReports, maxOpen)
+	}
+
+	created, err := scanReport(tx.QueryRow(ctx,
+		"INSERT INTO reports (short_id, reason, reporter_id) VALUES ($1, $2, $3) RETURNING "+reportColumns,
+		report.ShortID, report.Reason, report.ReporterID))
+	if err != nil {
+		return repository.Report{}, err
+	}
+
+	return created, tx.Commit(ctx)
+}
+
+func (s *PGStorage) GetReports(status string) ([]repository.Report, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := s.pool.Query(ctx, "SELECT "+reportColumns+
+		" FROM reports WHERE $1 = '' OR status = $1 ORDER BY id", status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := make([]repository.Report, 0)
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
+
+func (s *PGStorage) ResolveReport(reportID int64, status string) (repository.Report, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return repository.Report{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	report, err := scanReport(tx.QueryRow(ctx,
+		"UPDATE reports SET status = $1, resolved_at = now() WHERE id = $2 AND status = 'open' RETURNING "+reportColumns,
+		status, reportID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		var exists bool
+		if err = tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM reports WHERE id = $1)", reportID).Scan(&exists); err != nil {
+			return repository.Report{}, err
+		}
+		if exists {
+			return repository.Report{}, fmt.Errorf("%w: %d", repository.ErrReportResolved, reportID)
+		}
+		return repository.Report{}, repository.ErrNotFound
+	}
+	if err != nil {
+		return repository.Report{}, err
+	}
+
+	var owner string
+	if status == repository.ReportBlocked {
+		err = tx.QueryRow(ctx,
+			"UPDATE urls SET is_banned = TRUE WHERE short_id = $1 RETURNING COALESCE(user_id, '')", report.ShortID,
+		).Scan(&owner)
+		// link row may be gone, the report is resolved anyway
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return repository.Report{}, err
+		}
+		if err = s.notifyChanged(ctx, tx, report.ShortID); err != nil {
+			return repository.Report{}, err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return repository.Report{}, err
+	}
+	s.wrote(owner)
+	return report, nil
+}
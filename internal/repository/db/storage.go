@@ -4,27 +4,44 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/bissquit/url-shortener/pkg/urlnorm"
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/lib/pq"
 )
 
 type PGStorage struct {
+	// pool is primary, all writes and most reads go there
 	pool *pgxpool.Pool
+	// replicas serve GetURLByID, GetIDByURL and GetURLsByUserID
+	replicas    []*replica
+	nextReplica atomic.Uint64
+	writers     recentWriters
+	// notify is set by NotifyChanges
+	notify atomic.Bool
 }
 
-func NewDBStorage(p *pgxpool.Pool) *PGStorage {
-	return &PGStorage{
+// NewDBStorage uses p as primary and optional read-only replica pools
+func NewDBStorage(p *pgxpool.Pool, replicas ...*pgxpool.Pool) *PGStorage {
+	s := &PGStorage{
 		pool: p,
 	}
+	for i, pool := range replicas {
+		r := &replica{name: replicaName(i), pool: pool}
+		r.healthy.Store(true)
+		s.replicas = append(s.replicas, r)
+	}
+	return s
 }
 
-func (s *PGStorage) Create(id string, originalURL, userID string) error {
+func (s *PGStorage) Create(id string, originalURL, userID string, settings repository.LinkSettings) error {
 	if id == "" {
 		return fmt.Errorf("%w", repository.ErrEmptyID)
 	}
@@ -33,16 +50,19 @@ func (s *PGStorage) Create(id string, originalURL, userID string) error {
 	defer cancel()
 
 	_, err := s.pool.Exec(ctx,
-		"INSERT INTO urls (short_id, original_url, user_id) VALUES ($1, $2, $3)",
-		id, originalURL, userID,
+		"INSERT INTO urls (short_id, original_url, canonical_url, user_id, settings) VALUES ($1, $2, $3, $4, $5)",
+		id, originalURL, urlnorm.Canonical(originalURL), userID, settings,
 	)
 	if err == nil {
+		// "not found" may be cached by other instances
+		s.notifyChangedAfter(id)
+		s.wrote(userID)
 		return nil
 	}
 
 	var pgErr *pgconn.PgError
 	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
-		if pgErr.ConstraintName == "idx_original_url" {
+		if pgErr.ConstraintName == "idx_canonical_url" {
 			return fmt.Errorf("%w: %s", repository.ErrURLAlreadyExists, originalURL)
 		}
 		// UNIQUE/PK by short_id
@@ -52,8 +72,49 @@ func (s *PGStorage) Create(id string, originalURL, userID string) error {
 	return err
 }
 
+// bulkTimeout limits operations which write many rows at once
+const bulkTimeout = 30 * time.Second
+
+// CreateBatch inserts all items with a single statement.
+// Conflicting rows are skipped by ON CONFLICT, then looked up to report them all,
+// and the transaction is rolled back
 func (s *PGStorage) CreateBatch(items []repository.URLItem, userID string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ids := make([]string, 0, len(items))
+	urls := make([]string, 0, len(items))
+	canonicalURLs := make([]string, 0, len(items))
+	owners := make([]string, 0, len(items))
+	// invalid time means default creation time
+	createdAt := make([]pgtype.Timestamptz, 0, len(items))
+
+	// duplicates inside the batch are not reported by ON CONFLICT
+	seenIDs := make(map[string]struct{}, len(items))
+	seenURLs := make(map[string]struct{}, len(items))
+	conflict := &repository.BatchConflictError{}
+	for _, item := range items {
+		if item.ID == "" {
+			return fmt.Errorf("%w", repository.ErrEmptyID)
+		}
+		canonicalURL := urlnorm.Canonical(item.OriginalURL)
+		if _, ok := seenIDs[item.ID]; ok {
+			conflict.IDs = append(conflict.IDs, item.ID)
+		}
+		if _, ok := seenURLs[canonicalURL]; ok {
+			conflict.URLs = append(conflict.URLs, item.OriginalURL)
+		}
+		seenIDs[item.ID] = struct{}{}
+		seenURLs[canonicalURL] = struct{}{}
+
+		ids = append(ids, item.ID)
+		urls = append(urls, item.OriginalURL)
+		canonicalURLs = append(canonicalURLs, canonicalURL)
+		owners = append(owners, item.Owner(userID))
+		createdAt = append(createdAt, pgtype.Timestamptz{Time: item.CreatedAt, Valid: !item.CreatedAt.IsZero()})
+	}
+	if !conflict.Empty() {
+		return conflict
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), bulkTimeout)
 	defer cancel()
 
 	tx, err := s.pool.Begin(ctx)
@@ -62,115 +123,489 @@ func (s *PGStorage) CreateBatch(items []repository.URLItem, userID string) error
 	}
 	defer tx.Rollback(ctx)
 
-	for _, item := range items {
-		if item.ID == "" {
-			return fmt.Errorf("%w", repository.ErrEmptyID)
+	rows, err := tx.Query(ctx,
+		`INSERT INTO urls (short_id, original_url, canonical_url, user_id, created_at)
+		SELECT id, url, canonical, owner, COALESCE(created, now())
+		FROM unnest($1::text[], $2::text[], $3::text[], $4::text[], $5::timestamptz[])
+			AS t(id, url, canonical, owner, created)
+		ON CONFLICT DO NOTHING
+		RETURNING short_id`,
+		pq.Array(ids), pq.Array(urls), pq.Array(canonicalURLs), pq.Array(owners), createdAt,
+	)
+	if err != nil {
+		return err
+	}
+	inserted, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+
+	if len(inserted) < len(items) {
+		return batchConflict(ctx, tx, items, canonicalURLs, inserted)
+	}
+
+	if err = s.notifyChanged(ctx, tx, ids...); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return err
+	}
+	s.wrote(owners...)
+	return nil
+}
+
+// batchConflict finds out why items missing in inserted were skipped
+func batchConflict(ctx context.Context, tx pgx.Tx, items []repository.URLItem, canonicalURLs, inserted []string) error {
+	isInserted := toSet(inserted)
+	var skipped []int
+	var skippedIDs, skippedURLs []string
+	for i, item := range items {
+		if _, ok := isInserted[item.ID]; !ok {
+			skipped = append(skipped, i)
+			skippedIDs = append(skippedIDs, item.ID)
+			skippedURLs = append(skippedURLs, canonicalURLs[i])
 		}
+	}
 
-		_, err = tx.Exec(ctx,
-			"INSERT INTO urls (short_id, original_url, user_id) VALUES ($1, $2, $3)",
-			item.ID, item.OriginalURL, userID,
-		)
+	// skipped rows may only clash with rows stored before, batch duplicates are rejected earlier
+	rows, err := tx.Query(ctx, "SELECT short_id FROM urls WHERE short_id = ANY($1)", pq.Array(skippedIDs))
+	if err != nil {
+		return err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+	takenIDs := toSet(ids)
+	rows, err = tx.Query(ctx, "SELECT canonical_url FROM urls WHERE canonical_url = ANY($1)", pq.Array(skippedURLs))
+	if err != nil {
+		return err
+	}
+	urls, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+	takenURLs := toSet(urls)
+
+	conflict := &repository.BatchConflictError{}
+	for _, i := range skipped {
+		if _, ok := takenIDs[items[i].ID]; ok {
+			conflict.IDs = append(conflict.IDs, items[i].ID)
+		}
+		if _, ok := takenURLs[canonicalURLs[i]]; ok {
+			conflict.URLs = append(conflict.URLs, items[i].OriginalURL)
+		}
+	}
+	if conflict.Empty() {
+		// conflicting row is gone already (e.g. force deleted), new IDs are safe to retry with
+		conflict.IDs = skippedIDs
+	}
+	return conflict
+}
+
+func toSet(values []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		set[v] = struct{}{}
+	}
+	return set
+}
+
+func (s *PGStorage) GetURLByID(id string) (string, error) {
+	return readReplica(s, "", func(ctx context.Context, q querier) (string, error) {
+		row := q.QueryRow(ctx,
+			"SELECT original_url, is_deleted, is_banned FROM urls WHERE short_id = $1", id)
+
+		var originalURL string
+		var deleted, banned bool
+		err := row.Scan(&originalURL, &deleted, &banned)
+		if err == pgx.ErrNoRows {
+			return "", repository.ErrNotFound
+		}
+		if err != nil {
+			return "", err
+		}
+		if deleted {
+			return "", repository.ErrDeleted
+		}
+		if banned {
+			return "", repository.ErrBanned
+		}
+
+		return originalURL, nil
+	})
+}
+
+func (s *PGStorage) GetIDByURL(url string) (string, error) {
+	return readReplica(s, "", func(ctx context.Context, q querier) (string, error) {
+		row := q.QueryRow(ctx,
+			"SELECT short_id, is_deleted FROM urls WHERE canonical_url = $1", urlnorm.Canonical(url))
+
+		var id string
+		var deleted bool
+		err := row.Scan(&id, &deleted)
+		if err == pgx.ErrNoRows {
+			return "", repository.ErrNotFound
+		}
+		if err != nil {
+			return "", err
+		}
+		if deleted {
+			return "", repository.ErrDeleted
+		}
+
+		return id, nil
+	})
+}
+
+// GetURLsByUserID is served by primary for a while after user's changes (read-your-writes)
+func (s *PGStorage) GetURLsByUserID(userID string) ([]repository.UserURL, error) {
+	return readReplica(s, userID, func(ctx context.Context, q querier) ([]repository.UserURL, error) {
+		rows, err := q.Query(ctx,
+			"SELECT short_id, original_url, is_deleted FROM urls WHERE user_id = $1", userID)
 		if err != nil {
-			var pgErr *pgconn.PgError
-			if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
-				if pgErr.ConstraintName == "idx_original_url" {
-					return fmt.Errorf("%w: %s", repository.ErrURLAlreadyExists, item.OriginalURL)
-				}
-				return fmt.Errorf("%w: %s", repository.ErrIDAlreadyExists, item.ID)
+			return nil, err
+		}
+		defer rows.Close()
+
+		var items []repository.UserURL
+		for rows.Next() {
+			var shortID, originalURL string
+			var deleted bool
+			if err = rows.Scan(&shortID, &originalURL, &deleted); err != nil {
+				return nil, err
 			}
+			if !deleted {
+				items = append(items, repository.UserURL{
+					ShortID:     shortID,
+					OriginalURL: originalURL,
+				})
+			}
+		}
+
+		return items, rows.Err()
+	})
+}
+
+// userURLsPageSize is how many user links are read at once by IterateUserURLs
+const userURLsPageSize = 500
+
+// IterateUserURLs reads links by pages and calls fn after connection is released,
+// so slow consumer (e.g. export to slow client) doesn't hold pool connection
+func (s *PGStorage) IterateUserURLs(ctx context.Context, userID string, fn func(repository.UserURL) error) error {
+	// links without creation time go first, as if they were created at -infinity
+	after := pgtype.Timestamptz{InfinityModifier: pgtype.NegativeInfinity, Valid: true}
+	var afterID string
+	for {
+		page, err := s.userURLsPage(ctx, userID, after, afterID)
+		if err != nil {
 			return err
 		}
+		for _, u := range page {
+			if err = fn(u); err != nil {
+				return err
+			}
+		}
+		if len(page) < userURLsPageSize {
+			return nil
+		}
+
+		last := page[len(page)-1]
+		afterID = last.ShortID
+		if !last.CreatedAt.IsZero() {
+			after = pgtype.Timestamptz{Time: last.CreatedAt, Valid: true}
+		}
+	}
+}
+
+// userURLsPage returns links following (after, afterID) in order of IterateUserURLs,
+// the order is served by idx_user_id_created_at_short_id (see migrations)
+func (s *PGStorage) userURLsPage(ctx context.Context, userID string, after pgtype.Timestamptz, afterID string) ([]repository.UserURL, error) {
+	rows, err := s.pool.Query(ctx, `SELECT short_id, original_url, created_at, is_deleted FROM urls
+		WHERE user_id = $1 AND (COALESCE(created_at, '-infinity'), short_id) > ($2, $3)
+		ORDER BY COALESCE(created_at, '-infinity'), short_id LIMIT $4`,
+		userID, after, afterID, userURLsPageSize)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	return tx.Commit(ctx)
+	page := make([]repository.UserURL, 0, userURLsPageSize)
+	for rows.Next() {
+		var (
+			u         repository.UserURL
+			createdAt *time.Time
+		)
+		if err = rows.Scan(&u.ShortID, &u.OriginalURL, &createdAt, &u.Deleted); err != nil {
+			return nil, err
+		}
+		if createdAt != nil {
+			u.CreatedAt = createdAt.UTC()
+		}
+		page = append(page, u)
+	}
+	return page, rows.Err()
 }
 
-func (s *PGStorage) GetURLByID(id string) (string, error) {
+func (s *PGStorage) DeleteBatch(userID string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	row := s.pool.QueryRow(ctx,
-		"SELECT original_url, is_deleted FROM urls WHERE short_id = $1", id)
-
-	var originalURL string
-	var deleted bool
-	err := row.Scan(&originalURL, &deleted)
-	if err == pgx.ErrNoRows {
-		return "", repository.ErrNotFound
-	}
+	_, err := s.pool.Exec(ctx,
+		"UPDATE urls SET is_deleted = TRUE WHERE user_id = $1 AND short_id = ANY($2)",
+		userID, pq.Array(ids))
 	if err != nil {
-		return "", err
+		return err
 	}
-	if deleted {
-		return "", repository.ErrDeleted
+	s.notifyChangedAfter(ids...)
+	s.wrote(userID)
+	return nil
+}
+
+// linkColumns are scanned by scanLink
+const linkColumns = `short_id, original_url, COALESCE(user_id, ''), created_at, is_deleted, is_banned, settings, rules,
+	COALESCE((
+		SELECT json_agg(json_build_object(
+			'id', v.variant_id, 'url', v.url, 'weight', v.weight, 'clicks', v.clicks
+		) ORDER BY v.position)
+		FROM url_variants v WHERE v.short_id = urls.short_id
+	), '[]')`
+
+func scanLink(row pgx.Row) (repository.Link, error) {
+	var (
+		link      repository.Link
+		createdAt *time.Time
+	)
+	err := row.Scan(&link.ShortID, &link.OriginalURL, &link.UserID, &createdAt, &link.Deleted, &link.Banned,
+		&link.Settings, &link.Rules, &link.Variants)
+	// created_at is NULL for links created before it was tracked
+	if createdAt != nil {
+		link.CreatedAt = createdAt.UTC()
 	}
+	return link, err
+}
 
-	return originalURL, nil
+func (s *PGStorage) GetLink(id string) (repository.Link, error) {
+	return readLink(s, func(ctx context.Context, q querier) (repository.Link, error) {
+		return getLink(ctx, q, id)
+	})
 }
 
-func (s *PGStorage) GetIDByURL(url string) (string, error) {
+// primaryLink reads link on primary, it's used to explain just failed writes
+func (s *PGStorage) primaryLink(id string) (repository.Link, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	row := s.pool.QueryRow(ctx,
-		"SELECT short_id, is_deleted FROM urls WHERE original_url = $1", url)
+	return getLink(ctx, s.pool, id)
+}
 
-	var id string
-	var deleted bool
-	err := row.Scan(&id, &deleted)
+func getLink(ctx context.Context, q querier, id string) (repository.Link, error) {
+	row := q.QueryRow(ctx, "SELECT "+linkColumns+" FROM urls WHERE short_id = $1", id)
+
+	link, err := scanLink(row)
 	if err == pgx.ErrNoRows {
-		return "", repository.ErrNotFound
+		return repository.Link{}, repository.ErrNotFound
 	}
 	if err != nil {
-		return "", err
+		return repository.Link{}, err
 	}
-	if deleted {
-		return "", repository.ErrDeleted
+
+	return link, nil
+}
+
+func (s *PGStorage) UpdateLinkSettings(id, userID string, settings repository.LinkSettings) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tag, err := s.pool.Exec(ctx,
+		"UPDATE urls SET settings = $1 WHERE short_id = $2 AND user_id = $3 AND NOT is_deleted",
+		settings, id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() > 0 {
+		s.notifyChangedAfter(id)
+		s.wrote(userID)
+		return nil
 	}
+	return s.explainNotUpdated(id, userID)
+}
+
+func (s *PGStorage) UpdateRedirectRules(id, userID string, rules []repository.RedirectRule) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
-	return id, nil
+	if rules == nil {
+		// keep column value a JSON array
+		rules = []repository.RedirectRule{}
+	}
+	tag, err := s.pool.Exec(ctx,
+		"UPDATE urls SET rules = $1 WHERE short_id = $2 AND user_id = $3 AND NOT is_deleted",
+		rules, id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() > 0 {
+		s.notifyChangedAfter(id)
+		s.wrote(userID)
+		return nil
+	}
+	return s.explainNotUpdated(id, userID)
 }
 
-func (s *PGStorage) GetURLsByUserID(userID string) ([]repository.UserURL, error) {
+func (s *PGStorage) AddRedirectRule(id, userID string, rule repository.RedirectRule, maxRules int) ([]repository.RedirectRule, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := s.pool.Query(ctx,
-		"SELECT short_id, original_url, is_deleted FROM urls WHERE user_id = $1", userID)
+	// rules are appended by a single statement, so concurrent additions don't overwrite each other
+	var rules []repository.RedirectRule
+	err := s.pool.QueryRow(ctx, `
+		UPDATE urls SET rules = rules || jsonb_build_array($1::jsonb)
+		WHERE short_id = $2 AND user_id = $3 AND NOT is_deleted AND jsonb_array_length(rules) < $4
+		RETURNING rules`,
+		rule, id, userID, maxRules).Scan(&rules)
+	if err == pgx.ErrNoRows {
+		return nil, s.explainRulesNotUpdated(id, userID, fmt.Errorf("%w: max %d", repository.ErrTooManyRules, maxRules))
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	s.notifyChangedAfter(id)
+	s.wrote(userID)
+	return rules, nil
+}
 
-	var items []repository.UserURL
-	for rows.Next() {
-		var shortID, originalURL string
-		var deleted bool
-		if err = rows.Scan(&shortID, &originalURL, &deleted); err != nil {
-			return nil, err
-		}
-		if !deleted {
-			items = append(items, repository.UserURL{
-				ShortID:     shortID,
-				OriginalURL: originalURL,
-			})
-		}
+func (s *PGStorage) DeleteRedirectRule(id, userID string, index int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if index < 0 {
+		return fmt.Errorf("%w: rule %d", repository.ErrNotFound, index)
+	}
+	// "jsonb - integer" removes array element by index
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE urls SET rules = rules - $1::int
+		WHERE short_id = $2 AND user_id = $3 AND NOT is_deleted AND jsonb_array_length(rules) > $1`,
+		index, id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() > 0 {
+		s.notifyChangedAfter(id)
+		s.wrote(userID)
+		return nil
 	}
+	return s.explainRulesNotUpdated(id, userID, fmt.Errorf("%w: rule %d", repository.ErrNotFound, index))
+}
 
-	return items, rows.Err()
+// explainRulesNotUpdated returns reason when link exists, is owned by userID and is not deleted
+func (s *PGStorage) explainRulesNotUpdated(id, userID string, reason error) error {
+	link, err := s.primaryLink(id)
+	if err != nil {
+		return err
+	}
+	if link.UserID != userID {
+		return fmt.Errorf("%w: %s", repository.ErrForbidden, id)
+	}
+	if link.Deleted {
+		return repository.ErrDeleted
+	}
+	return reason
 }
 
-func (s *PGStorage) DeleteBatch(userID string, ids []string) error {
-	if len(ids) == 0 {
-		return nil
+func (s *PGStorage) UpdateVariants(id, userID string, variants []repository.Variant) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// lock link row, so concurrent updates of the same link are serialized
+	var owner string
+	var deleted bool
+	err = tx.QueryRow(ctx,
+		"SELECT COALESCE(user_id, ''), is_deleted FROM urls WHERE short_id = $1 FOR UPDATE", id,
+	).Scan(&owner, &deleted)
+	if err == pgx.ErrNoRows {
+		return repository.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if owner != userID {
+		return fmt.Errorf("%w: %s", repository.ErrForbidden, id)
+	}
+	if deleted {
+		return repository.ErrDeleted
+	}
+
+	ids := make([]string, 0, len(variants))
+	for _, v := range variants {
+		ids = append(ids, v.ID)
 	}
+	_, err = tx.Exec(ctx,
+		"DELETE FROM url_variants WHERE short_id = $1 AND NOT variant_id = ANY($2)",
+		id, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+
+	for i, v := range variants {
+		// clicks of already existing variants are kept
+		_, err = tx.Exec(ctx, `
+			INSERT INTO url_variants (short_id, variant_id, position, url, weight)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (short_id, variant_id)
+			DO UPDATE SET position = EXCLUDED.position, url = EXCLUDED.url, weight = EXCLUDED.weight`,
+			id, v.ID, i, v.URL, v.Weight)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = s.notifyChanged(ctx, tx, id); err != nil {
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return err
+	}
+	s.wrote(userID)
+	return nil
+}
 
+func (s *PGStorage) AddVariantClick(id, variantID string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := s.pool.Exec(ctx,
-		"UPDATE urls SET is_deleted = TRUE WHERE user_id = $1 AND short_id = ANY($2)",
-		userID, pq.Array(ids))
-	return err
+	tag, err := s.pool.Exec(ctx,
+		"UPDATE url_variants SET clicks = clicks + 1 WHERE short_id = $1 AND variant_id = $2",
+		id, variantID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: variant %s", repository.ErrNotFound, variantID)
+	}
+	// there is no notification, cached clicks may be stale
+	return nil
+}
+
+// explainNotUpdated finds out why an owner-scoped UPDATE hasn't touched any row
+func (s *PGStorage) explainNotUpdated(id, userID string) error {
+	link, err := s.primaryLink(id)
+	if err != nil {
+		return err
+	}
+	if link.UserID != userID {
+		return fmt.Errorf("%w: %s", repository.ErrForbidden, id)
+	}
+	return repository.ErrDeleted
 }
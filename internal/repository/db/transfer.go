@@ -0,0 +1,165 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/bissquit/url-shortener/pkg/urlnorm"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// IterateLinks streams rows, so it's limited by ctx only
+func (s *PGStorage) IterateLinks(ctx context.Context, fn func(repository.Link) error) error {
+	rows, err := s.pool.Query(ctx, "SELECT "+linkColumns+" FROM urls ORDER BY short_id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			return err
+		}
+		if err = fn(link); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// PutLinks sends all inserts as a single batch inside transaction
+func (s *PGStorage) PutLinks(links []repository.Link) error {
+	ctx, cancel := context.WithTimeout(context.Background(), bulkTimeout)
+	defer cancel()
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	ids, err := putLinks(ctx, tx, links)
+	if err != nil {
+		return err
+	}
+	if err = s.notifyChanged(ctx, tx, ids...); err != nil {
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return err
+	}
+	s.wrote(linkOwners(links)...)
+	return nil
+}
+
+// RestoreLinks puts all chunks inside a single transaction. Table is locked against
+// concurrent writes (but not reads) from the emptiness check until commit
+func (s *PGStorage) RestoreLinks(ctx context.Context, fill func(put func([]repository.Link) error) error) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	if _, err = tx.Exec(ctx, "LOCK TABLE urls IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+		return err
+	}
+	var exists bool
+	if err = tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM urls)").Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("%w", repository.ErrNotEmpty)
+	}
+
+	var restoredOwners []string
+	err = fill(func(links []repository.Link) error {
+		if _, err := putLinks(ctx, tx, links); err != nil {
+			return err
+		}
+		if len(s.replicas) > 0 {
+			restoredOwners = append(restoredOwners, linkOwners(links)...)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// restored IDs may be in negative caches of other instances
+	if err = s.notifyPurgeIn(ctx, tx); err != nil {
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return err
+	}
+	s.wrote(restoredOwners...)
+	return nil
+}
+
+func linkOwners(links []repository.Link) []string {
+	userIDs := make([]string, 0, len(links))
+	for _, link := range links {
+		userIDs = append(userIDs, link.UserID)
+	}
+	return userIDs
+}
+
+// putLinks queues all inserts as a single batch and returns IDs of links
+func putLinks(ctx context.Context, tx pgx.Tx, links []repository.Link) ([]string, error) {
+	batch := &pgx.Batch{}
+	ids := make([]string, 0, len(links))
+	for _, link := range links {
+		ids = append(ids, link.ShortID)
+		if link.ShortID == "" {
+			return nil, fmt.Errorf("%w", repository.ErrEmptyID)
+		}
+
+		var createdAt *time.Time
+		if !link.CreatedAt.IsZero() {
+			createdAt = &link.CreatedAt
+		}
+		rules := link.Rules
+		if rules == nil {
+			rules = []repository.RedirectRule{}
+		}
+		// canonical duplicates are not indexed, the same way as migration 000008 does.
+		// The check isn't atomic: link created by another transaction meanwhile fails the batch
+		batch.Queue(`
+			INSERT INTO urls (short_id, original_url, canonical_url, user_id, created_at,
+				is_deleted, is_banned, settings, rules)
+			VALUES ($1, $2,
+				CASE WHEN EXISTS (SELECT 1 FROM urls WHERE canonical_url = $3) THEN NULL ELSE $3 END,
+				$4, $5, $6, $7, $8, $9)`,
+			link.ShortID, link.OriginalURL, urlnorm.Canonical(link.OriginalURL), link.UserID, createdAt,
+			link.Deleted, link.Banned, link.Settings, rules)
+
+		for i, v := range link.Variants {
+			batch.Queue(`
+				INSERT INTO url_variants (short_id, variant_id, position, url, weight, clicks)
+				VALUES ($1, $2, $3, $4, $5, $6)`,
+				link.ShortID, v.ID, i, v.URL, v.Weight, v.Clicks)
+		}
+	}
+
+	// Close returns the first error of queued queries
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			switch pgErr.ConstraintName {
+			case "urls_pkey":
+				return nil, fmt.Errorf("%w: %s", repository.ErrIDAlreadyExists, pgErr.Detail)
+			case "idx_canonical_url":
+				return nil, fmt.Errorf("%w: %s", repository.ErrURLAlreadyExists, pgErr.Detail)
+			}
+		}
+		return nil, err
+	}
+	return ids, nil
+}
@@ -0,0 +1,32 @@
+package disk
+
+func (f *FileStorage) SetBanned(id string, banned bool) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if err := f.URLStorage.SetBanned(id, banned); err != nil {
+		return err
+	}
+	return f.save()
+}
+
+func (f *FileStorage) ForceDelete(id string) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if err := f.URLStorage.ForceDelete(id); err != nil {
+		return err
+	}
+	return f.save()
+}
+
+func (f *FileStorage) DeleteByUserID(userID string) (int64, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	n, err := f.URLStorage.DeleteByUserID(userID)
+	if err != nil || n == 0 {
+		return n, err
+	}
+	return n, f.save()
+}
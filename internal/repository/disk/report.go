@@ -0,0 +1,47 @@
+package disk
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/bissquit/url-shortener/internal/repository"
+)
+
+// reportsFilePath makes reports file name next to storage file,
+// e.g. /tmp/urls.json -> /tmp/urls.reports.json
+func reportsFilePath(filePath string) string {
+	ext := filepath.Ext(filePath)
+	return strings.TrimSuffix(filePath, ext) + ".reports" + ext
+}
+
+func restoreReportsFromFile(filename string) ([]repository.Report, error) {
+	return readFile[repository.Report](filename)
+}
+
+func (f *FileStorage) CreateReport(report repository.Report, maxOpen int) (repository.Report, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	report, err := f.URLStorage.CreateReport(report, maxOpen)
+	if err != nil {
+		return repository.Report{}, err
+	}
+	return report, saveToFile(f.URLStorage.Reports(), f.reportsPath)
+}
+
+func (f *FileStorage) ResolveReport(reportID int64, status string) (repository.Report, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	report, err := f.URLStorage.ResolveReport(reportID, status)
+	if err != nil {
+		return repository.Report{}, err
+	}
+	// link is banned by blocking report
+	if status == repository.ReportBlocked {
+		if err = f.save(); err != nil {
+			return repository.Report{}, err
+		}
+	}
+	return report, saveToFile(f.URLStorage.Reports(), f.reportsPath)
+}
@@ -1,39 +1,39 @@
 package disk
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/bissquit/url-shortener/internal/repository/memory"
 )
 
-type FileStorageItem struct {
-	OriginalURL string
-	UserID      string
-	DeletedFlag bool
-}
-
-type FileStorageItemInverted struct {
-	ID          string
-	UserID      string
-	DeletedFlag bool
-}
-
+// FileStorage keeps all data in memory (see memory.URLStorage)
+// and dumps the whole dataset to file after every change.
+// Variant clicks are the only exception: they are saved with the next change, by FlushClicks or on Close.
+// Read methods are served by embedded in-memory storage as is
 type FileStorage struct {
-	mux          sync.RWMutex
-	data         map[string]FileStorageItem
-	dataInverted map[string]FileStorageItemInverted
-	filePath     string
+	*memory.URLStorage
+	// serializes "change + save" sequences, so an older snapshot
+	// never overwrites a newer one
+	mux      sync.Mutex
+	filePath string
+	// reports are kept apart, so links file format stays the same
+	reportsPath string
+	// dirty is set when in-memory state has unsaved changes
+	dirty bool
 }
 
 func NewFileStorage(filePath string) (*FileStorage, error) {
 	fs := &FileStorage{
-		data:         make(map[string]FileStorageItem),
-		dataInverted: make(map[string]FileStorageItemInverted),
-		filePath:     filePath,
+		URLStorage:  memory.NewURLStorage(),
+		filePath:    filePath,
+		reportsPath: reportsFilePath(filePath),
 	}
 
 	items, err := restoreFromFile(filePath)
@@ -41,86 +41,100 @@ func NewFileStorage(filePath string) (*FileStorage, error) {
 		return nil, err
 	}
 
-	err = fs.loadToMemory(items)
+	if err = fs.URLStorage.PutLinks(toLinks(items)); err != nil {
+		return nil, fmt.Errorf("failed to initialize storage: %w", err)
+	}
+
+	reports, err := restoreReportsFromFile(fs.reportsPath)
 	if err != nil {
 		return nil, err
 	}
+	if err = fs.URLStorage.LoadReports(reports); err != nil {
+		return nil, fmt.Errorf("failed to initialize reports: %w", err)
+	}
 
 	return fs, nil
 }
 
 type fileStorageItem struct {
-	UUID        string `json:"uuid"`
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
-	UserID      string `json:"user_id"`
-	DeletedFlag bool   `json:"is_deleted"`
+	UUID        string                    `json:"uuid"`
+	ShortURL    string                    `json:"short_url"`
+	OriginalURL string                    `json:"original_url"`
+	UserID      string                    `json:"user_id"`
+	CreatedAt   time.Time                 `json:"created_at,omitzero"`
+	DeletedFlag bool                      `json:"is_deleted"`
+	BannedFlag  bool                      `json:"is_banned,omitempty"`
+	Settings    repository.LinkSettings   `json:"settings"`
+	Rules       []repository.RedirectRule `json:"rules,omitempty"`
+	Variants    []repository.Variant      `json:"variants,omitempty"`
+}
+
+func toLinks(items []fileStorageItem) []repository.Link {
+	links := make([]repository.Link, 0, len(items))
+	for _, item := range items {
+		links = append(links, repository.Link{
+			ShortID:     item.ShortURL,
+			OriginalURL: item.OriginalURL,
+			UserID:      item.UserID,
+			CreatedAt:   item.CreatedAt,
+			Deleted:     item.DeletedFlag,
+			Banned:      item.BannedFlag,
+			Settings:    item.Settings,
+			Rules:       item.Rules,
+			Variants:    item.Variants,
+		})
+	}
+	return links
 }
 
-// intermediate convertor from in-memory struct to json-in-file
-// be careful: Lock is required but not implemented in functions
-// solution: run function only after Lock
-func (f *FileStorage) loadFromMemory() []fileStorageItem {
-	// we don't do RLock because of possible unsupported recursive locking
-	items := make([]fileStorageItem, 0, len(f.data))
-	for shortURL, FSItem := range f.data {
+func fromLinks(links []repository.Link) []fileStorageItem {
+	items := make([]fileStorageItem, 0, len(links))
+	for _, link := range links {
 		items = append(items, fileStorageItem{
-			UUID:        shortURL,
-			ShortURL:    shortURL,
-			OriginalURL: FSItem.OriginalURL,
-			UserID:      FSItem.UserID,
-			DeletedFlag: FSItem.DeletedFlag,
+			UUID:        link.ShortID,
+			ShortURL:    link.ShortID,
+			OriginalURL: link.OriginalURL,
+			UserID:      link.UserID,
+			CreatedAt:   link.CreatedAt,
+			DeletedFlag: link.Deleted,
+			BannedFlag:  link.Banned,
+			Settings:    link.Settings,
+			Rules:       link.Rules,
+			Variants:    link.Variants,
 		})
 	}
 	return items
 }
 
-func (f *FileStorage) loadToMemory(items []fileStorageItem) error {
-	f.mux.Lock()
-	defer f.mux.Unlock()
-
-	for _, item := range items {
-		// check if id is uniq
-		_, ok := f.data[item.ShortURL]
-		if ok {
-			return fmt.Errorf("failed to initialize storage: %w: %s", repository.ErrIDAlreadyExists, item.ShortURL)
-		}
-		// check if url is uniq
-		_, ok = f.dataInverted[item.OriginalURL]
-		if ok {
-			return fmt.Errorf("failed to initialize storage: %w: %s", repository.ErrURLAlreadyExists, item.OriginalURL)
-		}
-
-		f.data[item.ShortURL] = FileStorageItem{
-			OriginalURL: item.OriginalURL,
-			UserID:      item.UserID,
-			DeletedFlag: item.DeletedFlag,
-		}
-		f.dataInverted[item.OriginalURL] = FileStorageItemInverted{
-			ID:          item.ShortURL,
-			UserID:      item.UserID,
-			DeletedFlag: item.DeletedFlag,
-		}
+// save dumps current in-memory state to file
+// be careful: f.mux is required but not acquired here
+func (f *FileStorage) save() error {
+	if err := saveToFile(fromLinks(f.URLStorage.Snapshot()), f.filePath); err != nil {
+		return err
 	}
-
+	f.dirty = false
 	return nil
 }
 
-func saveToFile(data []fileStorageItem, filename string) error {
+func saveToFile[T any](data []T, filename string) error {
 	jsonData, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	err = os.WriteFile(filename, jsonData, 0644)
-	if err != nil {
+	// file is replaced at once, so readers (e.g. backup) never see it half-written
+	tmpName := filename + ".tmp"
+	if err = os.WriteFile(tmpName, jsonData, 0644); err != nil {
 		return err
 	}
-
-	return nil
+	return os.Rename(tmpName, filename)
 }
 
 func restoreFromFile(filename string) ([]fileStorageItem, error) {
+	return readFile[fileStorageItem](filename)
+}
+
+func readFile[T any](filename string) ([]T, error) {
 	b, err := os.ReadFile(filename)
 	if err != nil {
 		// run with empty file
@@ -134,160 +148,163 @@ func restoreFromFile(filename string) ([]fileStorageItem, error) {
 		return nil, nil
 	}
 
-	var items []fileStorageItem
+	var items []T
 	if err = json.Unmarshal(b, &items); err != nil {
 		return nil, err
 	}
 	return items, nil
 }
 
-func (f *FileStorage) Create(id, originalURL, userID string) error {
-	if id == "" {
-		return fmt.Errorf("%w", repository.ErrEmptyID)
-	}
-
+func (f *FileStorage) Create(id, originalURL, userID string, settings repository.LinkSettings) error {
 	f.mux.Lock()
 	defer f.mux.Unlock()
 
-	// check if id is uniq
-	_, ok := f.data[id]
-	if ok {
-		return fmt.Errorf("%w: %s", repository.ErrIDAlreadyExists, id)
-	}
-	// check if url is uniq
-	_, ok = f.dataInverted[originalURL]
-	if ok {
-		return fmt.Errorf("%w: %s", repository.ErrURLAlreadyExists, originalURL)
+	if err := f.URLStorage.Create(id, originalURL, userID, settings); err != nil {
+		return err
 	}
+	return f.save()
+}
 
-	f.data[id] = FileStorageItem{
-		OriginalURL: originalURL,
-		UserID:      userID,
-	}
-	f.dataInverted[originalURL] = FileStorageItemInverted{
-		ID:     id,
-		UserID: userID,
-	}
+func (f *FileStorage) CreateBatch(items []repository.URLItem, userID string) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
 
-	if err := saveToFile(f.loadFromMemory(), f.filePath); err != nil {
+	if err := f.URLStorage.CreateBatch(items, userID); err != nil {
 		return err
 	}
-
-	return nil
+	return f.save()
 }
 
-func (f *FileStorage) CreateBatch(items []repository.URLItem, userID string) error {
+func (f *FileStorage) UpdateLinkSettings(id, userID string, settings repository.LinkSettings) error {
 	f.mux.Lock()
 	defer f.mux.Unlock()
 
-	for _, item := range items {
-		if item.ID == "" {
-			return fmt.Errorf("%w", repository.ErrEmptyID)
-		}
-		// check if id is uniq
-		if _, ok := f.data[item.ID]; ok {
-			return fmt.Errorf("%w: %s", repository.ErrIDAlreadyExists, item.ID)
-		}
-		// check if url is uniq
-		if _, ok := f.dataInverted[item.OriginalURL]; ok {
-			return fmt.Errorf("%w: %s", repository.ErrURLAlreadyExists, item.OriginalURL)
-		}
+	if err := f.URLStorage.UpdateLinkSettings(id, userID, settings); err != nil {
+		return err
 	}
+	return f.save()
+}
 
-	for _, item := range items {
-		f.data[item.ID] = FileStorageItem{
-			OriginalURL: item.OriginalURL,
-			UserID:      userID,
-		}
-		f.dataInverted[item.OriginalURL] = FileStorageItemInverted{
-			ID:     item.ID,
-			UserID: userID,
-		}
-	}
-	if err := saveToFile(f.loadFromMemory(), f.filePath); err != nil {
+func (f *FileStorage) UpdateRedirectRules(id, userID string, rules []repository.RedirectRule) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if err := f.URLStorage.UpdateRedirectRules(id, userID, rules); err != nil {
 		return err
 	}
-	return nil
+	return f.save()
 }
 
-func (f *FileStorage) GetURLByID(id string) (string, error) {
-	f.mux.RLock()
-	defer f.mux.RUnlock()
+func (f *FileStorage) AddRedirectRule(id, userID string, rule repository.RedirectRule, maxRules int) ([]repository.RedirectRule, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
 
-	item, ok := f.data[id]
-	if !ok {
-		return "", repository.ErrNotFound
+	rules, err := f.URLStorage.AddRedirectRule(id, userID, rule, maxRules)
+	if err != nil {
+		return nil, err
 	}
+	return rules, f.save()
+}
 
-	if item.DeletedFlag {
-		return "", repository.ErrDeleted
-	}
+func (f *FileStorage) DeleteRedirectRule(id, userID string, index int) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
 
-	return item.OriginalURL, nil
+	if err := f.URLStorage.DeleteRedirectRule(id, userID, index); err != nil {
+		return err
+	}
+	return f.save()
 }
 
-func (f *FileStorage) GetIDByURL(url string) (string, error) {
-	f.mux.RLock()
-	defer f.mux.RUnlock()
+func (f *FileStorage) UpdateVariants(id, userID string, variants []repository.Variant) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
 
-	itemInverted, ok := f.dataInverted[url]
-	if !ok {
-		return "", repository.ErrNotFound
-	}
-	if itemInverted.DeletedFlag {
-		return "", repository.ErrDeleted
+	if err := f.URLStorage.UpdateVariants(id, userID, variants); err != nil {
+		return err
 	}
+	return f.save()
+}
+
+// AddVariantClick doesn't save file, because it's called on every redirect, see FlushClicks
+func (f *FileStorage) AddVariantClick(id, variantID string) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
 
-	return itemInverted.ID, nil
+	if err := f.URLStorage.AddVariantClick(id, variantID); err != nil {
+		return err
+	}
+	f.dirty = true
+	return nil
 }
 
-func (f *FileStorage) GetURLsByUserID(userID string) ([]repository.UserURL, error) {
-	f.mux.RLock()
-	defer f.mux.RUnlock()
+// Close saves unsaved changes
+func (f *FileStorage) Close() error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
 
-	var userURLs []repository.UserURL
+	if !f.dirty {
+		return nil
+	}
+	return f.save()
+}
 
-	for id, item := range f.data {
-		if item.UserID == userID && !item.DeletedFlag {
-			userURLs = append(userURLs, repository.UserURL{
-				ShortID:     id,
-				OriginalURL: item.OriginalURL,
-			})
+// FlushClicks saves unsaved clicks every interval until ctx is done,
+// so crash loses clicks of the last interval only
+func (f *FileStorage) FlushClicks(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// it's the same as Close, but storage stays usable
+			if err := f.Close(); err != nil {
+				log.Printf("cannot save clicks to file storage: %v", err)
+			}
 		}
 	}
-
-	return userURLs, nil
 }
 
-func (f *FileStorage) DeleteBatch(userID string, ids []string) error {
+func (f *FileStorage) PutLinks(links []repository.Link) error {
 	f.mux.Lock()
 	defer f.mux.Unlock()
 
-	for _, id := range ids {
-		item, ok := f.data[id]
-		if !ok {
-			continue
-		}
-
-		if item.UserID == userID && !item.DeletedFlag {
-			itemInverted, ok := f.dataInverted[item.OriginalURL]
-			if !ok {
-				// in case of damaged inverted dataset
-				log.Printf("inconsistent inverted dataset for item: %s", id)
-				continue
-			}
+	if err := f.URLStorage.PutLinks(links); err != nil {
+		return err
+	}
+	return f.save()
+}
 
-			item.DeletedFlag = true
-			f.data[id] = item
+// RestoreLinks holds writers off until restored links are saved
+func (f *FileStorage) RestoreLinks(ctx context.Context, fill func(put func([]repository.Link) error) error) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
 
-			itemInverted.DeletedFlag = true
-			f.dataInverted[item.OriginalURL] = itemInverted
-		}
+	if err := f.URLStorage.RestoreLinks(ctx, fill); err != nil {
+		return err
 	}
+	return f.save()
+}
 
-	if err := saveToFile(f.loadFromMemory(), f.filePath); err != nil {
+func (f *FileStorage) DeleteBatch(userID string, ids []string) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if err := f.URLStorage.DeleteBatch(userID, ids); err != nil {
 		return err
 	}
+	return f.save()
+}
 
-	return nil
+// IterateLinks walks links of the same state that is saved to file,
+// fn is called without the lock, so writers aren't held off by a slow consumer
+func (f *FileStorage) IterateLinks(ctx context.Context, fn func(repository.Link) error) error {
+	f.mux.Lock()
+	links := f.URLStorage.Snapshot()
+	f.mux.Unlock()
+
+	return memory.RangeLinks(ctx, links, fn)
 }
@@ -0,0 +1,92 @@
+package memory
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/bissquit/url-shortener/internal/repository"
+)
+
+func (s *URLStorage) SearchLinks(filter repository.LinkFilter) ([]repository.Link, error) {
+	type found struct {
+		id   string
+		item *URLStorageItem
+	}
+
+	query := strings.ToLower(filter.Query)
+	matched := make([]found, 0)
+	s.rangeItems(func(id string, item *URLStorageItem) bool {
+		if filter.UserID != "" && item.UserID != filter.UserID {
+			return true
+		}
+		if !filter.IncludeDeleted && item.DeletedFlag {
+			return true
+		}
+		if query != "" && id != filter.Query && !strings.Contains(strings.ToLower(item.OriginalURL), query) {
+			return true
+		}
+		matched = append(matched, found{id: id, item: item})
+		return true
+	})
+	sort.Slice(matched, func(i, j int) bool { return matched[i].id < matched[j].id })
+
+	if filter.Offset >= len(matched) {
+		return nil, nil
+	}
+	matched = matched[filter.Offset:]
+	if filter.Limit > 0 && filter.Limit < len(matched) {
+		matched = matched[:filter.Limit]
+	}
+
+	links := make([]repository.Link, 0, len(matched))
+	for _, m := range matched {
+		links = append(links, m.item.link(m.id))
+	}
+	return links, nil
+}
+
+func (s *URLStorage) SetBanned(id string, banned bool) error {
+	return s.update(id, func(item *URLStorageItem) error {
+		item.BannedFlag = banned
+		return nil
+	})
+}
+
+func (s *URLStorage) ForceDelete(id string) error {
+	if _, ok := s.load(id); !ok {
+		return repository.ErrNotFound
+	}
+	// links are never removed, so the link exists and may be deleted already
+	s.deleteOwned([]string{id}, anyOwner)
+	return nil
+}
+
+func (s *URLStorage) DeleteByUserID(userID string) (int64, error) {
+	// user index keeps only links of userID
+	return s.deleteOwned(s.userLinks(userID), anyOwner), nil
+}
+
+func anyOwner(*URLStorageItem) bool {
+	return true
+}
+
+func (s *URLStorage) GetStats() (repository.Stats, error) {
+	var stats repository.Stats
+	users := make(map[string]struct{})
+	s.rangeItems(func(_ string, item *URLStorageItem) bool {
+		stats.Total++
+		if item.DeletedFlag {
+			stats.Deleted++
+		}
+		if item.BannedFlag {
+			stats.Banned++
+		}
+		if !item.DeletedFlag && !item.BannedFlag {
+			stats.Live++
+			users[item.UserID] = struct{}{}
+		}
+		return true
+	})
+	stats.Users = int64(len(users))
+	return stats, nil
+}
@@ -0,0 +1,134 @@
+package memory
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/bissquit/url-shortener/internal/repository"
+)
+
+type reportKey struct {
+	shortID    string
+	reporterID string
+}
+
+func (s *URLStorage) CreateReport(report repository.Report, maxOpen int) (repository.Report, error) {
+	item, ok := s.load(report.ShortID)
+	if !ok {
+		return repository.Report{}, repository.ErrNotFound
+	}
+	if item.DeletedFlag {
+		return repository.Report{}, repository.ErrDeleted
+	}
+
+	s.reportsMux.Lock()
+	defer s.reportsMux.Unlock()
+
+	key := reportKey{shortID: report.ShortID, reporterID: report.ReporterID}
+	if i, ok := s.openReports[key]; ok {
+		return s.reports[i], nil
+	}
+	if s.openReportsCount[report.ShortID] >= maxOpen {
+		return repository.Report{}, fmt.Errorf("%w: max %d", repository.ErrTooManyReports, maxOpen)
+	}
+
+	s.lastReportID++
+	report.ID = s.lastReportID
+	report.Status = repository.ReportOpen
+	report.CreatedAt = time.Now().UTC()
+	report.ResolvedAt = time.Time{}
+	s.reports = append(s.reports, report)
+	s.openReports[key] = len(s.reports) - 1
+	s.openReportsCount[report.ShortID]++
+	return report, nil
+}
+
+func (s *URLStorage) GetReports(status string) ([]repository.Report, error) {
+	s.reportsMux.RLock()
+	defer s.reportsMux.RUnlock()
+
+	reports := make([]repository.Report, 0)
+	for _, r := range s.reports {
+		if status == "" || r.Status == status {
+			reports = append(reports, r)
+		}
+	}
+	return reports, nil
+}
+
+func (s *URLStorage) ResolveReport(reportID int64, status string) (repository.Report, error) {
+	s.reportsMux.Lock()
+	defer s.reportsMux.Unlock()
+
+	i, ok := slices.BinarySearchFunc(s.reports, reportID, func(r repository.Report, id int64) int {
+		return cmp.Compare(r.ID, id)
+	})
+	if !ok {
+		return repository.Report{}, repository.ErrNotFound
+	}
+	if s.reports[i].Status != repository.ReportOpen {
+		return repository.Report{}, fmt.Errorf("%w: %d", repository.ErrReportResolved, reportID)
+	}
+
+	if status == repository.ReportBlocked {
+		// the link may not exist anymore only in case of damaged data
+		if err := s.SetBanned(s.reports[i].ShortID, true); err != nil {
+			log.Printf("cannot ban link %s of report %d: %v", s.reports[i].ShortID, reportID, err)
+		}
+	}
+	s.reports[i].Status = status
+	s.reports[i].ResolvedAt = time.Now().UTC()
+	s.closeReport(s.reports[i])
+	return s.reports[i], nil
+}
+
+// closeReport removes resolved report from indexes of open ones
+func (s *URLStorage) closeReport(report repository.Report) {
+	delete(s.openReports, reportKey{shortID: report.ShortID, reporterID: report.ReporterID})
+	if s.openReportsCount[report.ShortID]--; s.openReportsCount[report.ShortID] <= 0 {
+		delete(s.openReportsCount, report.ShortID)
+	}
+}
+
+// Reports returns a copy of all reports
+func (s *URLStorage) Reports() []repository.Report {
+	s.reportsMux.RLock()
+	defer s.reportsMux.RUnlock()
+
+	return append([]repository.Report(nil), s.reports...)
+}
+
+// LoadReports puts already existing reports into storage as is
+func (s *URLStorage) LoadReports(reports []repository.Report) error {
+	s.reportsMux.Lock()
+	defer s.reportsMux.Unlock()
+
+	seenIDs := make(map[int64]struct{}, len(s.reports)+len(reports))
+	for _, r := range s.reports {
+		seenIDs[r.ID] = struct{}{}
+	}
+	for _, r := range reports {
+		if _, ok := seenIDs[r.ID]; ok {
+			return fmt.Errorf("duplicated report id: %d", r.ID)
+		}
+		seenIDs[r.ID] = struct{}{}
+	}
+
+	s.reports = append(s.reports, reports...)
+	sort.Slice(s.reports, func(i, j int) bool { return s.reports[i].ID < s.reports[j].ID })
+	// positions are changed by sort, so indexes are rebuilt
+	clear(s.openReports)
+	clear(s.openReportsCount)
+	for i, r := range s.reports {
+		s.lastReportID = max(s.lastReportID, r.ID)
+		if r.Status == repository.ReportOpen {
+			s.openReports[reportKey{shortID: r.ShortID, reporterID: r.ReporterID}] = i
+			s.openReportsCount[r.ShortID]++
+		}
+	}
+	return nil
+}
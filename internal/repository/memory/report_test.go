@@ -0,0 +1,79 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_URLStorageReports(t *testing.T) {
+	const maxOpen = 2
+	s := NewURLStorage()
+	require.NoError(t, s.Create("id", "http://example.com", "owner", repository.LinkSettings{}))
+
+	// unexisted link
+	_, err := s.CreateReport(repository.Report{ShortID: "unexisted", ReporterID: "reporter"}, maxOpen)
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+
+	first, err := s.CreateReport(repository.Report{ShortID: "id", ReporterID: "reporter", Reason: "phishing"}, maxOpen)
+	require.NoError(t, err)
+	assert.Equal(t, repository.ReportOpen, first.Status)
+
+	// the same reporter doesn't duplicate open report
+	again, err := s.CreateReport(repository.Report{ShortID: "id", ReporterID: "reporter"}, maxOpen)
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, again.ID)
+
+	second, err := s.CreateReport(repository.Report{ShortID: "id", ReporterID: "another"}, maxOpen)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.ID, second.ID)
+
+	_, err = s.CreateReport(repository.Report{ShortID: "id", ReporterID: "third"}, maxOpen)
+	assert.ErrorIs(t, err, repository.ErrTooManyReports)
+
+	dismissed, err := s.ResolveReport(second.ID, repository.ReportDismissed)
+	require.NoError(t, err)
+	assert.Equal(t, repository.ReportDismissed, dismissed.Status)
+	assert.False(t, dismissed.ResolvedAt.IsZero())
+	link, err := s.GetLink("id")
+	require.NoError(t, err)
+	assert.False(t, link.Banned)
+
+	_, err = s.ResolveReport(first.ID, repository.ReportBlocked)
+	require.NoError(t, err)
+	link, err = s.GetLink("id")
+	require.NoError(t, err)
+	assert.True(t, link.Banned)
+
+	// resolved reports can't be changed
+	_, err = s.ResolveReport(first.ID, repository.ReportDismissed)
+	assert.ErrorIs(t, err, repository.ErrReportResolved)
+	_, err = s.ResolveReport(100, repository.ReportDismissed)
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+
+	open, err := s.GetReports(repository.ReportOpen)
+	require.NoError(t, err)
+	assert.Empty(t, open)
+	all, err := s.GetReports("")
+	require.NoError(t, err)
+	assert.Len(t, all, 2)
+
+	// ids continue after loaded reports
+	restored := NewURLStorage()
+	require.NoError(t, restored.Create("id", "http://example.com", "owner", repository.LinkSettings{}))
+	require.NoError(t, restored.LoadReports(s.Reports()))
+	third, err := restored.CreateReport(repository.Report{ShortID: "id", ReporterID: "reporter"}, maxOpen)
+	require.NoError(t, err)
+	assert.Equal(t, second.ID+1, third.ID)
+	// open reports are indexed after load too
+	require.NoError(t, restored.LoadReports([]repository.Report{
+		{ID: 100, ShortID: "id", ReporterID: "loaded", Status: repository.ReportOpen},
+	}))
+	again, err = restored.CreateReport(repository.Report{ShortID: "id", ReporterID: "loaded"}, maxOpen)
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), again.ID)
+	_, err = restored.CreateReport(repository.Report{ShortID: "id", ReporterID: "fourth"}, maxOpen)
+	assert.ErrorIs(t, err, repository.ErrTooManyReports)
+}
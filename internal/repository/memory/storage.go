@@ -1,17 +1,44 @@
 package memory
 
 import (
+	"context"
 	"fmt"
+	"hash/maphash"
 	"log"
+	"slices"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/bissquit/url-shortener/pkg/urlnorm"
 )
 
 type URLStorageItem struct {
 	OriginalURL string
 	UserID      string
+	CreatedAt   time.Time
 	DeletedFlag bool
+	BannedFlag  bool
+	Settings    repository.LinkSettings
+	Rules       []repository.RedirectRule
+	Variants    []repository.Variant
+}
+
+func (item URLStorageItem) link(id string) repository.Link {
+	return repository.Link{
+		ShortID:     id,
+		OriginalURL: item.OriginalURL,
+		UserID:      item.UserID,
+		CreatedAt:   item.CreatedAt,
+		Deleted:     item.DeletedFlag,
+		Banned:      item.BannedFlag,
+		// copies, so caller can't change stored item
+		Settings: item.Settings.Clone(),
+		Rules:    slices.Clone(item.Rules),
+		Variants: append([]repository.Variant(nil), item.Variants...),
+	}
 }
 
 type URLStorageItemInverted struct {
@@ -20,88 +47,326 @@ type URLStorageItemInverted struct {
 	DeletedFlag bool
 }
 
-// in-memory url storage
+// shard is picked by low shardBits of hash, bucket of shard table by the next bits
+const (
+	shardBits  = 6
+	shardCount = 1 << shardBits
+	// minBuckets is an initial size of shard table
+	minBuckets = 8
+)
+
+// shard keeps a part of links keyed by short ID in a hash table which readers don't lock.
+// Bucket chains are immutable: writers build a changed chain and publish it atomically,
+// stored items are replaced by changed copies the same way
+type shard struct {
+	// mux serializes writers only
+	mux   sync.Mutex
+	table atomic.Pointer[table]
+	// count is guarded by mux, table grows when it exceeds number of buckets
+	count int
+}
+
+// table is replaced as a whole when it grows, so readers of the old one see all links
+// stored before the growth
+type table struct {
+	buckets []atomic.Pointer[node]
+}
+
+// node keeps item by value, so reader follows one pointer less
+type node struct {
+	hash uint64
+	id   string
+	item URLStorageItem
+	next *node
+}
+
+func newTable(size int) *table {
+	return &table{buckets: make([]atomic.Pointer[node], size)}
+}
+
+func (t *table) bucket(hash uint64) *atomic.Pointer[node] {
+	// low bits pick shard
+	return &t.buckets[(hash>>shardBits)&uint64(len(t.buckets)-1)]
+}
+
+// get doesn't lock, it may be called with or without lock of shard
+func (sh *shard) get(hash uint64, id string) (*URLStorageItem, bool) {
+	for n := sh.table.Load().bucket(hash).Load(); n != nil; n = n.next {
+		if n.hash == hash && n.id == id {
+			return &n.item, true
+		}
+	}
+	return nil, false
+}
+
+// put adds or replaces item of id, item is copied
+// be careful: Lock is required but not acquired here
+func (sh *shard) put(hash uint64, id string, item *URLStorageItem) {
+	b := sh.table.Load().bucket(hash)
+	head := b.Load()
+
+	var prefix []*node
+	for n := head; n != nil; n = n.next {
+		if n.hash == hash && n.id == id {
+			// nodes before the replaced one are copied, readers of the chain never see it changing
+			chain := &node{hash: hash, id: id, item: *item, next: n.next}
+			for i := len(prefix) - 1; i >= 0; i-- {
+				chain = &node{hash: prefix[i].hash, id: prefix[i].id, item: prefix[i].item, next: chain}
+			}
+			b.Store(chain)
+			return
+		}
+		prefix = append(prefix, n)
+	}
+
+	if sh.count >= len(sh.table.Load().buckets) {
+		sh.grow()
+		b = sh.table.Load().bucket(hash)
+		head = b.Load()
+	}
+	b.Store(&node{hash: hash, id: id, item: *item, next: head})
+	sh.count++
+}
+
+// grow publishes a table twice as big, old chains are copied since nodes are immutable
+// be careful: Lock is required but not acquired here
+func (sh *shard) grow() {
+	old := sh.table.Load()
+	t := newTable(2 * len(old.buckets))
+	for i := range old.buckets {
+		for n := old.buckets[i].Load(); n != nil; n = n.next {
+			b := t.bucket(n.hash)
+			b.Store(&node{hash: n.hash, id: n.id, item: n.item, next: b.Load()})
+		}
+	}
+	sh.table.Store(t)
+}
+
+// urlShard is a part of index keyed by canonical URL (see urlnorm.Canonical)
+type urlShard struct {
+	mux  sync.RWMutex
+	data map[string]URLStorageItemInverted
+}
+
+// userShard is a part of index of user links, ids are appended in creation order and never removed
+type userShard struct {
+	mux sync.RWMutex
+	ids map[string][]string
+}
+
+// in-memory url storage.
+// Locks order: link shards, then URL shards (both by ascending index), then one user shard.
+// Reports mux is taken before any shard
 type URLStorage struct {
-	mux          sync.RWMutex
-	data         map[string]URLStorageItem
-	dataInverted map[string]URLStorageItemInverted
+	seed   maphash.Seed
+	shards [shardCount]shard
+	urls   [shardCount]urlShard
+	users  [shardCount]userShard
+
+	reportsMux sync.RWMutex
+	// reports are ordered by ID
+	reports      []repository.Report
+	lastReportID int64
+	// openReports indexes open reports by link and reporter, value is position in reports
+	openReports map[reportKey]int
+	// openReportsCount is a number of open reports per link
+	openReportsCount map[string]int
+}
+
+func NewURLStorage() *URLStorage {
+	return newURLStorage(maphash.MakeSeed())
+}
+
+func newURLStorage(seed maphash.Seed) *URLStorage {
+	s := &URLStorage{
+		seed:             seed,
+		openReports:      make(map[reportKey]int),
+		openReportsCount: make(map[string]int),
+	}
+	for i := range shardCount {
+		s.shards[i].table.Store(newTable(minBuckets))
+		s.urls[i].data = make(map[string]URLStorageItemInverted)
+		s.users[i].ids = make(map[string][]string)
+	}
+	return s
+}
+
+func (s *URLStorage) index(key string) int {
+	return int(maphash.String(s.seed, key) & (shardCount - 1))
+}
+
+func (s *URLStorage) shard(id string) *shard {
+	return &s.shards[s.index(id)]
+}
+
+// load is lock-free, so it may be called under lock of any shard
+func (s *URLStorage) load(id string) (*URLStorageItem, bool) {
+	hash := maphash.String(s.seed, id)
+	return s.shards[hash&(shardCount-1)].get(hash, id)
+}
+
+// store adds or replaces item of id
+// be careful: lock of id shard is required but not acquired here
+func (s *URLStorage) store(id string, item *URLStorageItem) {
+	hash := maphash.String(s.seed, id)
+	s.shards[hash&(shardCount-1)].put(hash, id, item)
+}
+
+func (s *URLStorage) urlShard(canonicalURL string) *urlShard {
+	return &s.urls[s.index(canonicalURL)]
+}
+
+// lock locks shards of ids and canonical URLs in ascending order, so concurrent writers
+// don't deadlock. It returns function to unlock them
+func (s *URLStorage) lock(ids, canonicalURLs []string) (unlock func()) {
+	idShards := s.indexes(ids)
+	urlShards := s.indexes(canonicalURLs)
+	for _, i := range idShards {
+		s.shards[i].mux.Lock()
+	}
+	for _, i := range urlShards {
+		s.urls[i].mux.Lock()
+	}
+	return func() {
+		for _, i := range urlShards {
+			s.urls[i].mux.Unlock()
+		}
+		for _, i := range idShards {
+			s.shards[i].mux.Unlock()
+		}
+	}
 }
 
-func NewURLStorage() repository.URLRepository {
-	return &URLStorage{
-		data:         make(map[string]URLStorageItem),
-		dataInverted: make(map[string]URLStorageItemInverted),
+// indexes returns sorted unique shard indexes of keys
+func (s *URLStorage) indexes(keys []string) []int {
+	indexes := make([]int, 0, len(keys))
+	for _, key := range keys {
+		indexes = append(indexes, s.index(key))
 	}
+	slices.Sort(indexes)
+	return slices.Compact(indexes)
 }
 
-func (s *URLStorage) Create(id, originalURL, userID string) error {
+// addUserLink adds id to user index, it's called after item is stored
+func (s *URLStorage) addUserLink(userID, id string) {
+	u := &s.users[s.index(userID)]
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	u.ids[userID] = append(u.ids[userID], id)
+}
+
+// userLinks returns ids of user links. Ids are only appended, so the result is shared with index
+// but it's capped to prevent appending into it
+func (s *URLStorage) userLinks(userID string) []string {
+	u := &s.users[s.index(userID)]
+	u.mux.RLock()
+	defer u.mux.RUnlock()
+	ids := u.ids[userID]
+	return ids[:len(ids):len(ids)]
+}
+
+func (s *URLStorage) Create(id, originalURL, userID string, settings repository.LinkSettings) error {
 	if id == "" {
 		return fmt.Errorf("%w", repository.ErrEmptyID)
 	}
 
-	s.mux.Lock()
-	defer s.mux.Unlock()
+	canonicalURL := urlnorm.Canonical(originalURL)
+	// the same order as lock() has, but without allocations
+	sh := s.shard(id)
+	sh.mux.Lock()
+	defer sh.mux.Unlock()
+	urls := s.urlShard(canonicalURL)
+	urls.mux.Lock()
+	defer urls.mux.Unlock()
 
 	// check id
-	_, ok := s.data[id]
-	if ok {
+	if _, ok := s.load(id); ok {
 		return fmt.Errorf("%w: %s", repository.ErrIDAlreadyExists, id)
 	}
 	// check url
-	_, ok = s.dataInverted[originalURL]
-	if ok {
+	if _, ok := urls.data[canonicalURL]; ok {
 		return fmt.Errorf("%w: %s", repository.ErrURLAlreadyExists, originalURL)
 	}
 
-	s.data[id] = URLStorageItem{
+	s.store(id, &URLStorageItem{
 		OriginalURL: originalURL,
 		UserID:      userID,
-	}
-	s.dataInverted[originalURL] = URLStorageItemInverted{
+		CreatedAt:   time.Now().UTC(),
+		Settings:    settings.Clone(),
+	})
+	urls.data[canonicalURL] = URLStorageItemInverted{
 		ID:     id,
 		UserID: userID,
 	}
+	s.addUserLink(userID, id)
 	return nil
 }
 
 func (s *URLStorage) CreateBatch(items []repository.URLItem, userID string) error {
-	s.mux.Lock()
-	defer s.mux.Unlock()
-
+	ids := make([]string, 0, len(items))
+	canonicalURLs := make([]string, 0, len(items))
 	for _, item := range items {
 		if item.ID == "" {
 			return fmt.Errorf("%w", repository.ErrEmptyID)
 		}
+		ids = append(ids, item.ID)
+		canonicalURLs = append(canonicalURLs, urlnorm.Canonical(item.OriginalURL))
+	}
+
+	unlock := s.lock(ids, canonicalURLs)
+	defer unlock()
+
+	// ids and urls must be uniq inside the batch too,
+	// all conflicts are collected so caller may fix them at once
+	seenIDs := make(map[string]struct{}, len(items))
+	seenURLs := make(map[string]struct{}, len(items))
+	conflict := &repository.BatchConflictError{}
+	for i, item := range items {
 		// check if id is uniq
-		if _, ok := s.data[item.ID]; ok {
-			return fmt.Errorf("%w: %s", repository.ErrIDAlreadyExists, item.ID)
+		_, stored := s.load(item.ID)
+		_, seen := seenIDs[item.ID]
+		if stored || seen {
+			conflict.IDs = append(conflict.IDs, item.ID)
 		}
+		seenIDs[item.ID] = struct{}{}
 		// check if url is uniq
-		if _, ok := s.dataInverted[item.OriginalURL]; ok {
-			return fmt.Errorf("%w: %s", repository.ErrURLAlreadyExists, item.OriginalURL)
+		canonicalURL := canonicalURLs[i]
+		_, stored = s.urlShard(canonicalURL).data[canonicalURL]
+		_, seen = seenURLs[canonicalURL]
+		if stored || seen {
+			conflict.URLs = append(conflict.URLs, item.OriginalURL)
 		}
+		seenURLs[canonicalURL] = struct{}{}
+	}
+	if !conflict.Empty() {
+		return conflict
 	}
 
-	for _, item := range items {
-		s.data[item.ID] = URLStorageItem{
-			OriginalURL: item.OriginalURL,
-			UserID:      userID,
+	now := time.Now().UTC()
+	for i, item := range items {
+		createdAt := item.CreatedAt.UTC()
+		if item.CreatedAt.IsZero() {
+			createdAt = now
 		}
-		s.dataInverted[item.OriginalURL] = URLStorageItemInverted{
+		s.store(item.ID, &URLStorageItem{
+			OriginalURL: item.OriginalURL,
+			UserID:      item.Owner(userID),
+			CreatedAt:   createdAt,
+		})
+		s.urlShard(canonicalURLs[i]).data[canonicalURLs[i]] = URLStorageItemInverted{
 			ID:     item.ID,
-			UserID: userID,
+			UserID: item.Owner(userID),
 		}
+		s.addUserLink(item.Owner(userID), item.ID)
 	}
 	return nil
 }
 
 // Get retrieves the original URL by its short ID.
 // Returns ErrNotFound if the ID doesn't exist.
+// It takes no locks, so redirects never wait for writers
 func (s *URLStorage) GetURLByID(id string) (string, error) {
-	s.mux.RLock()
-	defer s.mux.RUnlock()
-	// getting key from map returns additional bool output ('false' if key doesn't exist)
-	item, ok := s.data[id]
+	item, ok := s.load(id)
 	if !ok {
 		return "", repository.ErrNotFound
 	}
@@ -109,15 +374,20 @@ func (s *URLStorage) GetURLByID(id string) (string, error) {
 	if item.DeletedFlag {
 		return "", repository.ErrDeleted
 	}
+	if item.BannedFlag {
+		return "", repository.ErrBanned
+	}
 
 	return item.OriginalURL, nil
 }
 
 func (s *URLStorage) GetIDByURL(url string) (string, error) {
-	s.mux.RLock()
-	defer s.mux.RUnlock()
+	canonicalURL := urlnorm.Canonical(url)
+	urls := s.urlShard(canonicalURL)
+	urls.mux.RLock()
+	defer urls.mux.RUnlock()
 
-	itemInverted, ok := s.dataInverted[url]
+	itemInverted, ok := urls.data[canonicalURL]
 	if !ok {
 		return "", repository.ErrNotFound
 	}
@@ -128,14 +398,13 @@ func (s *URLStorage) GetIDByURL(url string) (string, error) {
 	return itemInverted.ID, nil
 }
 
+// GetURLsByUserID reads user index, so it doesn't depend on size of storage
 func (s *URLStorage) GetURLsByUserID(userID string) ([]repository.UserURL, error) {
-	s.mux.RLock()
-	defer s.mux.RUnlock()
-
 	var userURLs []repository.UserURL
 
-	for id, item := range s.data {
-		if item.UserID == userID && !item.DeletedFlag {
+	for _, id := range s.userLinks(userID) {
+		item, ok := s.load(id)
+		if ok && !item.DeletedFlag {
 			userURLs = append(userURLs, repository.UserURL{
 				ShortID:     id,
 				OriginalURL: item.OriginalURL,
@@ -146,31 +415,374 @@ func (s *URLStorage) GetURLsByUserID(userID string) ([]repository.UserURL, error
 	return userURLs, nil
 }
 
+// IterateUserURLs copies user links and calls fn for them,
+// so slow consumer doesn't block writers
+func (s *URLStorage) IterateUserURLs(ctx context.Context, userID string, fn func(repository.UserURL) error) error {
+	var userURLs []repository.UserURL
+	for _, id := range s.userLinks(userID) {
+		if item, ok := s.load(id); ok {
+			userURLs = append(userURLs, repository.UserURL{
+				ShortID:     id,
+				OriginalURL: item.OriginalURL,
+				CreatedAt:   item.CreatedAt,
+				Deleted:     item.DeletedFlag,
+			})
+		}
+	}
+
+	sort.Slice(userURLs, func(i, j int) bool {
+		if !userURLs[i].CreatedAt.Equal(userURLs[j].CreatedAt) {
+			return userURLs[i].CreatedAt.Before(userURLs[j].CreatedAt)
+		}
+		return userURLs[i].ShortID < userURLs[j].ShortID
+	})
+
+	for _, u := range userURLs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *URLStorage) DeleteBatch(userID string, ids []string) error {
-	s.mux.Lock()
-	defer s.mux.Unlock()
+	s.deleteOwned(ids, func(item *URLStorageItem) bool { return item.UserID == userID })
+	return nil
+}
 
+// deleteOwned marks links deleted when owned returns true for them and returns their number,
+// absent, already deleted and not owned links are skipped.
+// Shards of all ids are locked at once, so snapshot sees either all links deleted or none
+func (s *URLStorage) deleteOwned(ids []string, owned func(item *URLStorageItem) bool) int64 {
+	unlock := s.lock(ids, nil)
+	defer unlock()
+
+	var n int64
 	for _, id := range ids {
-		item, ok := s.data[id]
-		if !ok {
+		item, ok := s.load(id)
+		if !ok || item.DeletedFlag || !owned(item) {
 			continue
 		}
+		s.markDeleted(id, *item)
+		n++
+	}
+	return n
+}
+
+// markDeleted sets deleted flag in both datasets
+// be careful: lock of id shard is required but not acquired here
+func (s *URLStorage) markDeleted(id string, item URLStorageItem) {
+	item.DeletedFlag = true
+	s.store(id, &item)
+
+	canonicalURL := urlnorm.Canonical(item.OriginalURL)
+	urls := s.urlShard(canonicalURL)
+	urls.mux.Lock()
+	defer urls.mux.Unlock()
+
+	itemInverted, ok := urls.data[canonicalURL]
+	if !ok {
+		// in case of damaged inverted dataset
+		log.Printf("inconsistent inverted dataset for item: %s", id)
+		return
+	}
+	// duplicates loaded from old data are not indexed (see PutLinks)
+	if itemInverted.ID == id {
+		itemInverted.DeletedFlag = true
+		urls.data[canonicalURL] = itemInverted
+	}
+}
+
+// update stores copy of item changed by fn, nothing is stored when fn fails
+func (s *URLStorage) update(id string, fn func(item *URLStorageItem) error) error {
+	sh := s.shard(id)
+	sh.mux.Lock()
+	defer sh.mux.Unlock()
+
+	current, ok := s.load(id)
+	if !ok {
+		return repository.ErrNotFound
+	}
+	item := *current
+	if err := fn(&item); err != nil {
+		return err
+	}
+	s.store(id, &item)
+	return nil
+}
+
+// updateOwned is update of not deleted link of userID
+func (s *URLStorage) updateOwned(id, userID string, fn func(item *URLStorageItem) error) error {
+	return s.update(id, func(item *URLStorageItem) error {
+		if item.UserID != userID {
+			return fmt.Errorf("%w: %s", repository.ErrForbidden, id)
+		}
+		if item.DeletedFlag {
+			return repository.ErrDeleted
+		}
+		return fn(item)
+	})
+}
+
+func (s *URLStorage) GetLink(id string) (repository.Link, error) {
+	item, ok := s.load(id)
+	if !ok {
+		return repository.Link{}, repository.ErrNotFound
+	}
+
+	return item.link(id), nil
+}
+
+func (s *URLStorage) UpdateLinkSettings(id, userID string, settings repository.LinkSettings) error {
+	return s.updateOwned(id, userID, func(item *URLStorageItem) error {
+		item.Settings = settings.Clone()
+		return nil
+	})
+}
+
+func (s *URLStorage) UpdateRedirectRules(id, userID string, rules []repository.RedirectRule) error {
+	return s.updateOwned(id, userID, func(item *URLStorageItem) error {
+		item.Rules = slices.Clone(rules)
+		return nil
+	})
+}
+
+func (s *URLStorage) AddRedirectRule(id, userID string, rule repository.RedirectRule, maxRules int) ([]repository.RedirectRule, error) {
+	var rules []repository.RedirectRule
+	err := s.updateOwned(id, userID, func(item *URLStorageItem) error {
+		if len(item.Rules) >= maxRules {
+			return fmt.Errorf("%w: max %d", repository.ErrTooManyRules, maxRules)
+		}
+		// stored slice may be read concurrently, so it's never appended in place
+		item.Rules = append(slices.Clip(item.Rules), rule)
+		rules = slices.Clone(item.Rules)
+		return nil
+	})
+	return rules, err
+}
+
+func (s *URLStorage) DeleteRedirectRule(id, userID string, index int) error {
+	return s.updateOwned(id, userID, func(item *URLStorageItem) error {
+		if index < 0 || index >= len(item.Rules) {
+			return fmt.Errorf("%w: rule %d", repository.ErrNotFound, index)
+		}
+		item.Rules = slices.Delete(slices.Clone(item.Rules), index, index+1)
+		return nil
+	})
+}
+
+func (s *URLStorage) UpdateVariants(id, userID string, variants []repository.Variant) error {
+	return s.updateOwned(id, userID, func(item *URLStorageItem) error {
+		clicks := make(map[string]int64, len(item.Variants))
+		for _, v := range item.Variants {
+			clicks[v.ID] = v.Clicks
+		}
+		updated := make([]repository.Variant, 0, len(variants))
+		for _, v := range variants {
+			v.Clicks = clicks[v.ID]
+			updated = append(updated, v)
+		}
+		item.Variants = updated
+		return nil
+	})
+}
+
+func (s *URLStorage) AddVariantClick(id, variantID string) error {
+	return s.update(id, func(item *URLStorageItem) error {
+		i := slices.IndexFunc(item.Variants, func(v repository.Variant) bool { return v.ID == variantID })
+		if i < 0 {
+			return fmt.Errorf("%w: variant %s", repository.ErrNotFound, variantID)
+		}
+		// stored slice may be read concurrently, so it's copied
+		item.Variants = slices.Clone(item.Variants)
+		item.Variants[i].Clicks++
+		return nil
+	})
+}
+
+// rangeItems calls fn for all stored items until it returns false. Shards are not locked,
+// so links changed during the call may be seen in either state
+func (s *URLStorage) rangeItems(fn func(id string, item *URLStorageItem) bool) {
+	for i := range s.shards {
+		if !rangeTable(s.shards[i].table.Load(), fn) {
+			return
+		}
+	}
+}
+
+// chains returns bucket chains of all shards taken at one point in time: all shards are
+// locked meanwhile, so no write is seen half done. Chains are never changed in place,
+// so they may be walked after the locks are released
+func (s *URLStorage) chains() []*node {
+	for i := range s.shards {
+		s.shards[i].mux.Lock()
+	}
+	defer func() {
+		for i := range s.shards {
+			s.shards[i].mux.Unlock()
+		}
+	}()
+
+	var chains []*node
+	for i := range s.shards {
+		t := s.shards[i].table.Load()
+		for j := range t.buckets {
+			if n := t.buckets[j].Load(); n != nil {
+				chains = append(chains, n)
+			}
+		}
+	}
+	return chains
+}
 
-		if item.UserID == userID && !item.DeletedFlag {
-			itemInverted, ok := s.dataInverted[item.OriginalURL]
-			if !ok {
-				// in case of damaged inverted dataset
-				log.Printf("inconsistent inverted dataset for item: %s", id)
-				continue
+func rangeTable(t *table, fn func(id string, item *URLStorageItem) bool) bool {
+	for i := range t.buckets {
+		for n := t.buckets[i].Load(); n != nil; n = n.next {
+			if !fn(n.id, &n.item) {
+				return false
 			}
+		}
+	}
+	return true
+}
+
+// IterateLinks calls fn for copies of all links ordered by short ID
+func (s *URLStorage) IterateLinks(ctx context.Context, fn func(repository.Link) error) error {
+	return RangeLinks(ctx, s.Snapshot(), fn)
+}
+
+// RangeLinks sorts links by short ID and calls fn for them until it fails or ctx is done
+func RangeLinks(ctx context.Context, links []repository.Link, fn func(repository.Link) error) error {
+	sort.Slice(links, func(i, j int) bool { return links[i].ShortID < links[j].ShortID })
 
-			item.DeletedFlag = true
-			s.data[id] = item
+	for _, link := range links {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Snapshot returns a point-in-time copy of all stored links including deleted ones
+func (s *URLStorage) Snapshot() []repository.Link {
+	links := make([]repository.Link, 0)
+	for _, chain := range s.chains() {
+		for n := chain; n != nil; n = n.next {
+			links = append(links, n.item.link(n.id))
+		}
+	}
+	return links
+}
 
-			itemInverted.DeletedFlag = true
-			s.dataInverted[item.OriginalURL] = itemInverted
+// PutLinks puts already existing links into storage as is (with their owners and flags).
+// Nothing is loaded if even one link breaks id uniqueness.
+// Links which were stored before canonicalization may share the same canonical URL,
+// the first of them is found by GetIDByURL and the rest are still available by ID
+func (s *URLStorage) PutLinks(links []repository.Link) error {
+	ids := make([]string, 0, len(links))
+	canonicalURLs := make([]string, 0, len(links))
+	for _, link := range links {
+		if link.ShortID == "" {
+			return fmt.Errorf("%w", repository.ErrEmptyID)
 		}
+		ids = append(ids, link.ShortID)
+		canonicalURLs = append(canonicalURLs, urlnorm.Canonical(link.OriginalURL))
 	}
 
+	unlock := s.lock(ids, canonicalURLs)
+	defer unlock()
+
+	seenIDs := make(map[string]struct{}, len(links))
+	for _, link := range links {
+		if _, ok := s.load(link.ShortID); ok {
+			return fmt.Errorf("%w: %s", repository.ErrIDAlreadyExists, link.ShortID)
+		}
+		if _, ok := seenIDs[link.ShortID]; ok {
+			return fmt.Errorf("%w: %s", repository.ErrIDAlreadyExists, link.ShortID)
+		}
+		seenIDs[link.ShortID] = struct{}{}
+	}
+
+	for i, link := range links {
+		s.store(link.ShortID, &URLStorageItem{
+			OriginalURL: link.OriginalURL,
+			UserID:      link.UserID,
+			CreatedAt:   link.CreatedAt,
+			DeletedFlag: link.Deleted,
+			BannedFlag:  link.Banned,
+			Settings:    link.Settings.Clone(),
+			Rules:       slices.Clone(link.Rules),
+			Variants:    append([]repository.Variant(nil), link.Variants...),
+		})
+		s.addUserLink(link.UserID, link.ShortID)
+
+		canonicalURL := canonicalURLs[i]
+		urls := s.urlShard(canonicalURL)
+		if existing, ok := urls.data[canonicalURL]; ok {
+			log.Printf("duplicated canonical URL %s: %s is kept, %s is available by id only",
+				canonicalURL, existing.ID, link.ShortID)
+			continue
+		}
+		urls.data[canonicalURL] = URLStorageItemInverted{
+			ID:          link.ShortID,
+			UserID:      link.UserID,
+			DeletedFlag: link.Deleted,
+		}
+	}
+	return nil
+}
+
+// RestoreLinks puts links into a staged storage with the same seed, so its shards
+// are swapped with empty ones under all locks. Writers are blocked during the swap only
+func (s *URLStorage) RestoreLinks(ctx context.Context, fill func(put func([]repository.Link) error) error) error {
+	// fail fast, emptiness is checked again on swap
+	empty := true
+	s.rangeItems(func(string, *URLStorageItem) bool {
+		empty = false
+		return false
+	})
+	if !empty {
+		return fmt.Errorf("%w", repository.ErrNotEmpty)
+	}
+
+	staged := newURLStorage(s.seed)
+	if err := fill(staged.PutLinks); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// the locks order of URLStorage, user shards go last
+	for i := range shardCount {
+		s.shards[i].mux.Lock()
+		defer s.shards[i].mux.Unlock()
+	}
+	for i := range shardCount {
+		s.urls[i].mux.Lock()
+		defer s.urls[i].mux.Unlock()
+	}
+	for i := range shardCount {
+		s.users[i].mux.Lock()
+		defer s.users[i].mux.Unlock()
+	}
+
+	for i := range shardCount {
+		if s.shards[i].count > 0 {
+			return fmt.Errorf("%w", repository.ErrNotEmpty)
+		}
+	}
+	for i := range shardCount {
+		s.shards[i].table.Store(staged.shards[i].table.Load())
+		s.shards[i].count = staged.shards[i].count
+		s.urls[i].data = staged.urls[i].data
+		s.users[i].ids = staged.users[i].ids
+	}
 	return nil
 }
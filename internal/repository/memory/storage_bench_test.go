@@ -0,0 +1,200 @@
+package memory
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/bissquit/url-shortener/pkg/urlnorm"
+)
+
+// lockedStorage is the former single mutex design kept as a baseline for benchmarks.
+// Contention shows up with several CPUs:
+//
+//	go test -run '^$' -bench URLStorage -cpu 1,4,8 ./internal/repository/memory
+type lockedStorage struct {
+	mux          sync.RWMutex
+	data         map[string]URLStorageItem
+	dataInverted map[string]URLStorageItemInverted
+}
+
+func newLockedStorage() *lockedStorage {
+	return &lockedStorage{
+		data:         make(map[string]URLStorageItem),
+		dataInverted: make(map[string]URLStorageItemInverted),
+	}
+}
+
+func (s *lockedStorage) Create(id, originalURL, userID string, _ repository.LinkSettings) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if _, ok := s.data[id]; ok {
+		return repository.ErrIDAlreadyExists
+	}
+	canonicalURL := urlnorm.Canonical(originalURL)
+	if _, ok := s.dataInverted[canonicalURL]; ok {
+		return repository.ErrURLAlreadyExists
+	}
+	s.data[id] = URLStorageItem{OriginalURL: originalURL, UserID: userID, CreatedAt: time.Now().UTC()}
+	s.dataInverted[canonicalURL] = URLStorageItemInverted{ID: id, UserID: userID}
+	return nil
+}
+
+func (s *lockedStorage) GetURLByID(id string) (string, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	item, ok := s.data[id]
+	if !ok {
+		return "", repository.ErrNotFound
+	}
+	return item.OriginalURL, nil
+}
+
+func (s *lockedStorage) GetURLsByUserID(userID string) ([]repository.UserURL, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	var userURLs []repository.UserURL
+	for id, item := range s.data {
+		if item.UserID == userID && !item.DeletedFlag {
+			userURLs = append(userURLs, repository.UserURL{ShortID: id, OriginalURL: item.OriginalURL})
+		}
+	}
+	return userURLs, nil
+}
+
+type benchStorage interface {
+	Create(id, originalURL, userID string, settings repository.LinkSettings) error
+	GetURLByID(id string) (string, error)
+}
+
+var benchStorages = []struct {
+	name string
+	new  func() benchStorage
+}{
+	{name: "locked", new: func() benchStorage { return newLockedStorage() }},
+	{name: "sharded", new: func() benchStorage { return NewURLStorage() }},
+}
+
+const (
+	benchLinks = 100_000
+	// every user has benchLinks/benchUsers links
+	benchUsers = 10_000
+)
+
+// benchKeys are arguments of Create precomputed, so formatting isn't measured
+type benchKeys struct {
+	ids, urls, users []string
+}
+
+func newBenchKeys(from, n int) benchKeys {
+	k := benchKeys{ids: make([]string, n), urls: make([]string, n), users: make([]string, n)}
+	for i := range n {
+		k.ids[i] = fmt.Sprintf("id%08d", from+i)
+		k.urls[i] = fmt.Sprintf("http://example.com/%d", from+i)
+		k.users[i] = fmt.Sprintf("user%d", (from+i)%benchUsers)
+	}
+	return k
+}
+
+func (k benchKeys) create(s benchStorage, i int) error {
+	return s.Create(k.ids[i], k.urls[i], k.users[i], repository.LinkSettings{})
+}
+
+func fillBenchStorage(b *testing.B, s benchStorage) benchKeys {
+	b.Helper()
+	keys := newBenchKeys(0, benchLinks)
+	for i := range benchLinks {
+		if err := keys.create(s, i); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return keys
+}
+
+func BenchmarkURLStorageGetURLByID(b *testing.B) {
+	for _, bs := range benchStorages {
+		b.Run(bs.name, func(b *testing.B) {
+			s := bs.new()
+			keys := fillBenchStorage(b, s)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					_, _ = s.GetURLByID(keys.ids[i%benchLinks])
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkURLStorageCreate(b *testing.B) {
+	for _, bs := range benchStorages {
+		b.Run(bs.name, func(b *testing.B) {
+			s := bs.new()
+			keys := newBenchKeys(0, b.N)
+			var n atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					_ = keys.create(s, int(n.Add(1)-1))
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkURLStorageMixed is a redirect heavy load: 9 reads per write
+func BenchmarkURLStorageMixed(b *testing.B) {
+	for _, bs := range benchStorages {
+		b.Run(bs.name, func(b *testing.B) {
+			s := bs.new()
+			keys := fillBenchStorage(b, s)
+			// every goroutine writes first, so there may be one write more per goroutine
+			created := newBenchKeys(benchLinks, b.N/10+runtime.GOMAXPROCS(0))
+			var n atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					if i%10 == 0 {
+						_ = created.create(s, int(n.Add(1)-1)%len(created.ids))
+					} else {
+						_, _ = s.GetURLByID(keys.ids[i%benchLinks])
+					}
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkURLStorageGetURLsByUserID(b *testing.B) {
+	type userStorage interface {
+		benchStorage
+		GetURLsByUserID(userID string) ([]repository.UserURL, error)
+	}
+	for _, bs := range []struct {
+		name string
+		new  func() userStorage
+	}{
+		{name: "locked", new: func() userStorage { return newLockedStorage() }},
+		{name: "sharded", new: func() userStorage { return NewURLStorage() }},
+	} {
+		b.Run(bs.name, func(b *testing.B) {
+			s := bs.new()
+			keys := fillBenchStorage(b, s)
+			b.ResetTimer()
+			for i := range b.N {
+				_, _ = s.GetURLsByUserID(keys.users[i%benchLinks])
+			}
+		})
+	}
+}
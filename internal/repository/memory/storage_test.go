@@ -1,10 +1,16 @@
 package memory
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/bissquit/url-shortener/internal/repository"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_URLStorageCreate(t *testing.T) {
@@ -18,11 +24,11 @@ func Test_URLStorageCreate(t *testing.T) {
 	s := NewURLStorage()
 
 	// create new
-	err := s.Create(id, url, userID)
+	err := s.Create(id, url, userID, repository.LinkSettings{})
 	assert.NoError(t, err)
 
 	// trying to create existed
-	err = s.Create(id, urlNew, userID)
+	err = s.Create(id, urlNew, userID, repository.LinkSettings{})
 	assert.Error(t, err)
 	// check not rewrited
 	u, err := s.GetURLByID(id)
@@ -39,7 +45,7 @@ func Test_URLStorageGet(t *testing.T) {
 	)
 
 	s := NewURLStorage()
-	s.Create(id, url, userID)
+	s.Create(id, url, userID, repository.LinkSettings{})
 
 	u, err := s.GetURLByID(id)
 	assert.NoError(t, err)
@@ -49,3 +55,307 @@ func Test_URLStorageGet(t *testing.T) {
 	assert.Error(t, err)
 	assert.Equal(t, repository.ErrNotFound, err)
 }
+
+func Test_URLStorageCanonicalURL(t *testing.T) {
+	const userID = "same-user-id"
+
+	s := NewURLStorage()
+	assert.NoError(t, s.Create("id", "http://Example.com:80/a/?utm_source=x", userID, repository.LinkSettings{}))
+
+	// raw URL is kept for redirect
+	u, err := s.GetURLByID("id")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://Example.com:80/a/?utm_source=x", u)
+
+	// equal URLs are found by canonical form
+	id, err := s.GetIDByURL("http://example.com/a")
+	assert.NoError(t, err)
+	assert.Equal(t, "id", id)
+
+	err = s.Create("another-id", "HTTP://example.com/a", userID, repository.LinkSettings{})
+	assert.ErrorIs(t, err, repository.ErrURLAlreadyExists)
+
+	err = s.CreateBatch([]repository.URLItem{
+		{ID: "batch-1", OriginalURL: "http://example.com/b?y=1&x=2"},
+		{ID: "batch-2", OriginalURL: "http://example.com/b/?x=2&y=1"},
+	}, userID)
+	assert.ErrorIs(t, err, repository.ErrURLAlreadyExists)
+}
+
+func Test_URLStorageCreateBatchConflicts(t *testing.T) {
+	const userID = "same-user-id"
+
+	s := NewURLStorage()
+	assert.NoError(t, s.Create("taken", "http://example.com/taken", userID, repository.LinkSettings{}))
+
+	err := s.CreateBatch([]repository.URLItem{
+		{ID: "new-1", OriginalURL: "http://example.com/1"},
+		{ID: "taken", OriginalURL: "http://example.com/2"},
+		{ID: "new-1", OriginalURL: "http://example.com/3"},
+		{ID: "new-4", OriginalURL: "http://example.com/taken"},
+	}, userID)
+
+	var conflict *repository.BatchConflictError
+	require.ErrorAs(t, err, &conflict)
+	assert.Equal(t, []string{"taken", "new-1"}, conflict.IDs)
+	assert.Equal(t, []string{"http://example.com/taken"}, conflict.URLs)
+	assert.ErrorIs(t, err, repository.ErrIDAlreadyExists)
+	assert.ErrorIs(t, err, repository.ErrURLAlreadyExists)
+
+	// nothing is stored
+	_, err = s.GetURLByID("new-1")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+}
+
+func Test_URLStorageUserURLs(t *testing.T) {
+	s := NewURLStorage()
+	require.NoError(t, s.Create("first", "http://example.com/1", "user", repository.LinkSettings{}))
+	require.NoError(t, s.Create("another", "http://example.com/2", "another-user", repository.LinkSettings{}))
+	require.NoError(t, s.CreateBatch([]repository.URLItem{
+		{ID: "second", OriginalURL: "http://example.com/3"},
+		{ID: "third", OriginalURL: "http://example.com/4"},
+	}, "user"))
+	require.NoError(t, s.PutLinks([]repository.Link{
+		{ShortID: "restored", OriginalURL: "http://example.com/5", UserID: "user"},
+	}))
+	require.NoError(t, s.DeleteBatch("user", []string{"second", "another"}))
+
+	urls, err := s.GetURLsByUserID("user")
+	require.NoError(t, err)
+	assert.Equal(t, []repository.UserURL{
+		{ShortID: "first", OriginalURL: "http://example.com/1"},
+		{ShortID: "third", OriginalURL: "http://example.com/4"},
+		{ShortID: "restored", OriginalURL: "http://example.com/5"},
+	}, urls)
+
+	// link of another user is not deleted by DeleteBatch
+	_, err = s.GetURLByID("another")
+	assert.NoError(t, err)
+
+	n, err := s.DeleteByUserID("user")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	urls, err = s.GetURLsByUserID("user")
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+	_, err = s.GetIDByURL("http://example.com/1")
+	assert.ErrorIs(t, err, repository.ErrDeleted)
+}
+
+func Test_URLStorageConcurrentWrites(t *testing.T) {
+	const (
+		writers = 8
+		links   = 100
+	)
+
+	s := NewURLStorage()
+	var wg sync.WaitGroup
+	var created atomic.Int64
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range links {
+				// every URL is created by two writers and only one of them wins
+				id := fmt.Sprintf("id-%d-%d", w, i)
+				if s.Create(id, fmt.Sprintf("http://example.com/%d/%d", w/2, i), "user", repository.LinkSettings{}) == nil {
+					created.Add(1)
+				}
+				_, _ = s.GetURLByID(id)
+				if i%10 == 0 {
+					_ = s.DeleteBatch("user", []string{id})
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(writers/2*links), created.Load())
+	stats, err := s.GetStats()
+	require.NoError(t, err)
+	assert.Equal(t, created.Load(), stats.Total)
+	assert.Equal(t, int64(writers/2*links/10), stats.Deleted)
+	urls, err := s.GetURLsByUserID("user")
+	require.NoError(t, err)
+	assert.Len(t, urls, int(stats.Live))
+}
+
+func Test_URLStorageRedirectRules(t *testing.T) {
+	s := NewURLStorage()
+	require.NoError(t, s.Create("id", "http://example.com", "owner", repository.LinkSettings{}))
+
+	rules, err := s.AddRedirectRule("id", "owner", repository.RedirectRule{Device: "ios", URL: "http://example.com/ios"}, 2)
+	require.NoError(t, err)
+	assert.Len(t, rules, 1)
+	// returned rules are a copy
+	rules[0].URL = "http://changed"
+	rules, err = s.AddRedirectRule("id", "owner", repository.RedirectRule{Country: "DE", URL: "http://example.de"}, 2)
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/ios", rules[0].URL)
+
+	_, err = s.AddRedirectRule("id", "owner", repository.RedirectRule{URL: "http://example.org"}, 2)
+	assert.ErrorIs(t, err, repository.ErrTooManyRules)
+	_, err = s.AddRedirectRule("id", "another", repository.RedirectRule{URL: "http://example.org"}, 10)
+	assert.ErrorIs(t, err, repository.ErrForbidden)
+
+	assert.ErrorIs(t, s.DeleteRedirectRule("id", "owner", 2), repository.ErrNotFound)
+	require.NoError(t, s.DeleteRedirectRule("id", "owner", 0))
+	link, err := s.GetLink("id")
+	require.NoError(t, err)
+	assert.Equal(t, []repository.RedirectRule{{Country: "DE", URL: "http://example.de"}}, link.Rules)
+}
+
+func Test_URLStorageLinkSettingsCopy(t *testing.T) {
+	s := NewURLStorage()
+	require.NoError(t, s.Create("id", "http://example.com", "owner", repository.LinkSettings{}))
+
+	utm := map[string]string{"utm_source": "news"}
+	require.NoError(t, s.UpdateLinkSettings("id", "owner", repository.LinkSettings{UTM: utm}))
+	utm["utm_source"] = "changed"
+
+	link, err := s.GetLink("id")
+	require.NoError(t, err)
+	link.Settings.UTM["utm_medium"] = "changed"
+
+	link, err = s.GetLink("id")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"utm_source": "news"}, link.Settings.UTM)
+}
+
+func Test_URLStorageRestoreLinks(t *testing.T) {
+	links := []repository.Link{
+		{ShortID: "id1", OriginalURL: "http://example.com/1", UserID: "owner"},
+		{ShortID: "id2", OriginalURL: "http://example.com/2", UserID: "owner"},
+	}
+	errBroken := errors.New("broken archive")
+
+	t.Run("failed fill stores nothing", func(t *testing.T) {
+		s := NewURLStorage()
+		err := s.RestoreLinks(context.Background(), func(put func([]repository.Link) error) error {
+			require.NoError(t, put(links[:1]))
+			return errBroken
+		})
+		assert.ErrorIs(t, err, errBroken)
+		_, err = s.GetLink("id1")
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+	})
+
+	t.Run("link created meanwhile", func(t *testing.T) {
+		s := NewURLStorage()
+		err := s.RestoreLinks(context.Background(), func(put func([]repository.Link) error) error {
+			require.NoError(t, put(links))
+			return s.Create("other", "http://example.com/other", "user", repository.LinkSettings{})
+		})
+		assert.ErrorIs(t, err, repository.ErrNotEmpty)
+		_, err = s.GetLink("id1")
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+		_, err = s.GetLink("other")
+		assert.NoError(t, err)
+	})
+
+	t.Run("restored links are indexed", func(t *testing.T) {
+		s := NewURLStorage()
+		require.NoError(t, s.RestoreLinks(context.Background(), func(put func([]repository.Link) error) error {
+			return put(links)
+		}))
+		id, err := s.GetIDByURL("http://example.com/2")
+		require.NoError(t, err)
+		assert.Equal(t, "id2", id)
+		userLinks, err := s.GetURLsByUserID("owner")
+		require.NoError(t, err)
+		assert.Len(t, userLinks, 2)
+
+		err = s.RestoreLinks(context.Background(), func(func([]repository.Link) error) error { return nil })
+		assert.ErrorIs(t, err, repository.ErrNotEmpty)
+	})
+}
+
+func Test_URLStorageLockFreeReads(t *testing.T) {
+	// enough links to grow shard tables several times and to chain buckets
+	const links = 5000
+
+	s := NewURLStorage()
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var found bool
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			// link is never lost while tables grow and chains are replaced
+			_, err := s.GetURLByID("id-1")
+			if err == nil {
+				found = true
+			} else if found || !errors.Is(err, repository.ErrNotFound) {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+		}
+	}()
+
+	for i := range links {
+		require.NoError(t, s.Create(fmt.Sprintf("id-%d", i), fmt.Sprintf("http://example.com/%d", i), "user", repository.LinkSettings{}))
+	}
+	for i := 0; i < links; i += 2 {
+		require.NoError(t, s.DeleteBatch("user", []string{fmt.Sprintf("id-%d", i)}))
+	}
+	close(stop)
+	wg.Wait()
+
+	for i := range links {
+		_, err := s.GetURLByID(fmt.Sprintf("id-%d", i))
+		if i%2 == 0 {
+			assert.ErrorIs(t, err, repository.ErrDeleted)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+	stats, err := s.GetStats()
+	require.NoError(t, err)
+	assert.Equal(t, int64(links), stats.Total)
+	assert.Equal(t, int64(links/2), stats.Deleted)
+}
+
+func Test_URLStorageSnapshotPointInTime(t *testing.T) {
+	s := NewURLStorage()
+	// pairs of links in different shards deleted by one batch
+	var pairs [][]string
+	for i := 0; len(pairs) < 50; i += 2 {
+		first, second := fmt.Sprintf("id-%d", i), fmt.Sprintf("id-%d", i+1)
+		if s.index(first) == s.index(second) {
+			continue
+		}
+		require.NoError(t, s.Create(first, "http://example.com/"+first, "user", repository.LinkSettings{}))
+		require.NoError(t, s.Create(second, "http://example.com/"+second, "user", repository.LinkSettings{}))
+		pairs = append(pairs, []string{first, second})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, pair := range pairs {
+			_ = s.DeleteBatch("user", pair)
+		}
+	}()
+
+	for {
+		deleted := make(map[string]bool)
+		require.NoError(t, s.IterateLinks(context.Background(), func(link repository.Link) error {
+			deleted[link.ShortID] = link.Deleted
+			return nil
+		}))
+		for _, pair := range pairs {
+			require.Equal(t, deleted[pair[0]], deleted[pair[1]], "batch %v is seen half deleted", pair)
+		}
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+}
@@ -1,6 +1,13 @@
 package repository
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+)
 
 var (
 	ErrNotFound         = errors.New("not found")
@@ -9,11 +16,65 @@ var (
 	ErrEmptyID          = errors.New("empty id")
 	ErrDeleted          = errors.New("deleted")
 	ErrForbidden        = errors.New("forbidden")
+	ErrBanned           = errors.New("banned")
+	ErrReportResolved   = errors.New("report is already resolved")
+	ErrTooManyRules     = errors.New("too many redirect rules")
+	ErrTooManyReports   = errors.New("too many open reports")
+	ErrNotEmpty         = errors.New("storage is not empty")
 )
 
+// BatchConflictError lists every item which prevented CreateBatch from inserting a batch.
+// Nothing is stored when it's returned.
+// It matches ErrIDAlreadyExists and ErrURLAlreadyExists with errors.Is
+type BatchConflictError struct {
+	// IDs already taken by stored links or repeated inside the batch
+	IDs []string
+	// URLs (as passed in the batch) already shortened or repeated inside the batch
+	URLs []string
+}
+
+func (e *BatchConflictError) Error() string {
+	var parts []string
+	if len(e.IDs) > 0 {
+		parts = append(parts, fmt.Sprintf("%s: %s", ErrIDAlreadyExists, strings.Join(e.IDs, ", ")))
+	}
+	if len(e.URLs) > 0 {
+		parts = append(parts, fmt.Sprintf("%s: %s", ErrURLAlreadyExists, strings.Join(e.URLs, ", ")))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func (e *BatchConflictError) Unwrap() []error {
+	var errs []error
+	if len(e.IDs) > 0 {
+		errs = append(errs, ErrIDAlreadyExists)
+	}
+	if len(e.URLs) > 0 {
+		errs = append(errs, ErrURLAlreadyExists)
+	}
+	return errs
+}
+
+// Empty reports whether no conflicts were found
+func (e *BatchConflictError) Empty() bool {
+	return len(e.IDs) == 0 && len(e.URLs) == 0
+}
+
 type URLItem struct {
 	ID          string
 	OriginalURL string
+	// UserID overrides owner passed to CreateBatch, it's set by import
+	UserID string
+	// CreatedAt is set to current time when it's zero
+	CreatedAt time.Time
+}
+
+// Owner returns item owner falling back to batch owner
+func (i URLItem) Owner(batchUserID string) string {
+	if i.UserID != "" {
+		return i.UserID
+	}
+	return batchUserID
 }
 
 type BatchItemInput struct {
@@ -29,16 +90,165 @@ type BatchItemOutput struct {
 type UserURL struct {
 	ShortID     string
 	OriginalURL string
+	// CreatedAt is zero for links created before it was tracked
+	CreatedAt time.Time
+	Deleted   bool
+}
+
+// LinkSettings keeps per-link options which change the way a short URL is served.
+// Zero values mean "use the global default".
+type LinkSettings struct {
+	RedirectCode int    `json:"redirect_code,omitempty"`
+	Preview      bool   `json:"preview,omitempty"`
+	QueryPolicy  string `json:"query_policy,omitempty"`
+	// UTM parameters are added to destination if they are not set yet
+	UTM map[string]string `json:"utm,omitempty"`
+}
+
+func (s LinkSettings) IsZero() bool {
+	return s.RedirectCode == 0 && !s.Preview && s.QueryPolicy == "" && len(s.UTM) == 0
+}
+
+// Clone returns settings which don't share UTM map with s
+func (s LinkSettings) Clone() LinkSettings {
+	s.UTM = maps.Clone(s.UTM)
+	return s
+}
+
+// RedirectRule sends matching visitors to URL instead of original one.
+// Empty Device or Country matches any value
+type RedirectRule struct {
+	Device  string `json:"device,omitempty"`
+	Country string `json:"country,omitempty"`
+	URL     string `json:"url"`
+}
+
+// Variant is one of weighted destinations of a split (A/B) link
+type Variant struct {
+	ID     string `json:"id"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+	// Clicks is maintained by storage and ignored on update
+	Clicks int64 `json:"clicks"`
+}
+
+// Link is a full view of a stored short URL.
+// OriginalURL is the primary destination, rules and variants may send visitors elsewhere
+type Link struct {
+	ShortID     string
+	OriginalURL string
+	UserID      string
+	CreatedAt   time.Time
+	Deleted     bool
+	// Banned links are blocked by admin and never redirect
+	Banned   bool
+	Settings LinkSettings
+	// Rules are evaluated in order, the first matching one wins
+	Rules []RedirectRule
+	// Variants split traffic which is not matched by rules
+	Variants []Variant
+}
+
+// LinkFilter selects links for admin search, empty fields match everything
+type LinkFilter struct {
+	// Query is a case-insensitive substring of original URL or exact short ID
+	Query          string
+	UserID         string
+	IncludeDeleted bool
+	Limit          int
+	Offset         int
+}
+
+type Stats struct {
+	Total   int64 `json:"total"`
+	Live    int64 `json:"live"`
+	Deleted int64 `json:"deleted"`
+	Banned  int64 `json:"banned"`
+	// Users is a number of distinct owners of live links
+	Users int64 `json:"users"`
+}
+
+// Report statuses. Resolving a report as blocked bans the reported link
+const (
+	ReportOpen      = "open"
+	ReportBlocked   = "blocked"
+	ReportDismissed = "dismissed"
+)
+
+// Report is a public complaint about a link waiting for moderation
+type Report struct {
+	ID         int64     `json:"id"`
+	ShortID    string    `json:"short_id"`
+	Reason     string    `json:"reason,omitempty"`
+	ReporterID string    `json:"reporter_id"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+	ResolvedAt time.Time `json:"resolved_at,omitzero"`
+}
+
+// IsLive reports whether link may be redirected to
+func (l Link) IsLive() bool {
+	return !l.Deleted && !l.Banned
 }
 
 type URLRepository interface {
 	// create
-	Create(id, originalURL, userID string) error
+	// Create stores link together with its settings, zero settings mean server defaults
+	Create(id, originalURL, userID string, settings LinkSettings) error
+	// CreateBatch stores all items or none of them.
+	// Conflicting IDs and URLs are reported together with *BatchConflictError
 	CreateBatch(items []URLItem, userID string) error
+	// update
+	UpdateLinkSettings(id, userID string, settings LinkSettings) error
+	UpdateRedirectRules(id, userID string, rules []RedirectRule) error
+	// AddRedirectRule appends rule unless link already has maxRules (ErrTooManyRules)
+	// and returns the updated list. Concurrent changes of rules don't overwrite each other
+	AddRedirectRule(id, userID string, rule RedirectRule, maxRules int) ([]RedirectRule, error)
+	// DeleteRedirectRule removes rule by its position, absent position is ErrNotFound
+	DeleteRedirectRule(id, userID string, index int) error
+	// UpdateVariants replaces variants keeping clicks of ones with the same ID
+	UpdateVariants(id, userID string, variants []Variant) error
+	AddVariantClick(id, variantID string) error
 	// delete
 	DeleteBatch(userID string, ids []string) error
 	// get
 	GetURLByID(id string) (string, error)
 	GetIDByURL(url string) (string, error)
 	GetURLsByUserID(userID string) ([]UserURL, error)
+	// IterateUserURLs calls fn for every user link including deleted ones in order of creation
+	// without loading all of them at once. Iteration stops on the first error returned by fn
+	IterateUserURLs(ctx context.Context, userID string, fn func(UserURL) error) error
+	// GetLink returns deleted and banned links too, so caller must check them
+	GetLink(id string) (Link, error)
+	// admin (not scoped by user)
+	// SearchLinks returns links ordered by short ID
+	SearchLinks(filter LinkFilter) ([]Link, error)
+	SetBanned(id string, banned bool) error
+	ForceDelete(id string) error
+	// DeleteByUserID marks all user links deleted and returns their number
+	DeleteByUserID(userID string) (int64, error)
+	GetStats() (Stats, error)
+	// full scan, it's used to move data between storages
+	// IterateLinks calls fn for every link including deleted and banned ones ordered by short ID.
+	// Links are taken from a single point-in-time state, so it may be used for backups.
+	// Iteration stops on the first error returned by fn
+	IterateLinks(ctx context.Context, fn func(Link) error) error
+	// PutLinks stores links as is with their owners, flags, settings and clicks.
+	// Nothing is stored if one of IDs already exists.
+	// Canonical URL duplicates are stored but only the first of them is found by GetIDByURL,
+	// ErrURLAlreadyExists means the same URL was created concurrently and nothing is stored
+	PutLinks(links []Link) error
+	// RestoreLinks fills empty storage at once: fill calls put for every chunk of links
+	// and nothing is stored if fill fails or storage isn't empty (ErrNotEmpty).
+	// Links are put the same way as PutLinks does
+	RestoreLinks(ctx context.Context, fill func(put func([]Link) error) error) error
+	// moderation
+	// CreateReport fills ID, Status and CreatedAt of report.
+	// An open report of the same reporter for the same link is returned instead of a new one,
+	// otherwise link may have up to maxOpen open reports (ErrTooManyReports)
+	CreateReport(report Report, maxOpen int) (Report, error)
+	// GetReports returns reports ordered by ID, empty status matches all of them
+	GetReports(status string) ([]Report, error)
+	// ResolveReport changes status of an open report, ReportBlocked also bans the link
+	ResolveReport(reportID int64, status string) (Report, error)
 }
@@ -0,0 +1,14 @@
+package server
+
+import (
+	"time"
+
+	"github.com/bissquit/url-shortener/internal/grpcapi"
+	"google.golang.org/grpc"
+)
+
+// GRPCServer serves the same shortener as HTTP routes, so they share checker
+// and background deletions waited by Drain
+func (s *Server) GRPCServer(callTimeout time.Duration, opts ...grpc.ServerOption) *grpc.Server {
+	return grpcapi.Register(s.shortener, callTimeout, opts...)
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// hsts asks browsers to use HTTPS only, header is sent over TLS connections only
+func hsts(maxAge time.Duration) func(http.Handler) http.Handler {
+	value := "max-age=" + strconv.FormatInt(int64(maxAge/time.Second), 10)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS != nil {
+				w.Header().Set("Strict-Transport-Security", value)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RedirectToHTTPS sends every request to the same host and path on httpsAddr port
+func RedirectToHTTPS(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+			// IPv6 address must be bracketed without port too
+			host = "[" + host + "]"
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		// 308 keeps method and body of POST requests
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
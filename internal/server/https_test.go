@@ -0,0 +1,64 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_hsts(t *testing.T) {
+	handler := hsts(24 * time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// plain HTTP must not get the header
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Empty(t, w.Header().Get("Strict-Transport-Security"))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.TLS = &tls.ConnectionState{}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, "max-age=86400", w.Header().Get("Strict-Transport-Security"))
+}
+
+func Test_RedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		name      string
+		httpsAddr string
+		target    string
+		want      string
+	}{
+		{
+			name:      "default HTTPS port is omitted",
+			httpsAddr: ":443",
+			target:    "http://example.com/abc?x=1",
+			want:      "https://example.com/abc?x=1",
+		},
+		{
+			name:      "custom HTTPS port replaces HTTP one",
+			httpsAddr: ":8443",
+			target:    "http://example.com:8080/abc",
+			want:      "https://example.com:8443/abc",
+		},
+		{
+			name:      "IPv6 host",
+			httpsAddr: ":443",
+			target:    "http://[::1]:8080/",
+			want:      "https://[::1]/",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			RedirectToHTTPS(tt.httpsAddr).ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.target, nil))
+
+			assert.Equal(t, http.StatusPermanentRedirect, w.Code)
+			assert.Equal(t, tt.want, w.Header().Get("Location"))
+		})
+	}
+}
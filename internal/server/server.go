@@ -2,17 +2,24 @@ package server
 
 import (
 	"context"
+	"encoding/json"
+	"expvar"
 	"log"
 	"net/http"
 	"time"
 
 	"github.com/bissquit/url-shortener/internal/auth"
+	"github.com/bissquit/url-shortener/internal/clientip"
 	"github.com/bissquit/url-shortener/internal/compress"
 	"github.com/bissquit/url-shortener/internal/config"
 	"github.com/bissquit/url-shortener/internal/handler"
 	"github.com/bissquit/url-shortener/internal/logging"
+	"github.com/bissquit/url-shortener/internal/ratelimit"
 	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/bissquit/url-shortener/internal/repository/db"
 	"github.com/bissquit/url-shortener/internal/service"
+	"github.com/bissquit/url-shortener/internal/service/urlcheck"
+	"github.com/bissquit/url-shortener/migrations"
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -22,12 +29,41 @@ type Server struct {
 	storage   repository.URLRepository
 	router    *chi.Mux
 	generator service.IDGenerator
+	geoIP     handler.GeoLocator
+	checker   *urlcheck.Checker
+	// checkPools reports primary and replicas health, it's nil without database
+	checkPools func(ctx context.Context) []db.PoolHealth
+	// shortener is shared by HTTP and gRPC, its background work is waited on shutdown
+	shortener *service.Shortener
 	DB        *pgxpool.Pool
 }
 
+// Option sets optional dependencies which must be known before routes are set up
+type Option func(*Server)
+
+func WithGeoIP(geoIP handler.GeoLocator) Option {
+	return func(s *Server) {
+		s.geoIP = geoIP
+	}
+}
+
+func WithURLChecker(checker *urlcheck.Checker) Option {
+	return func(s *Server) {
+		s.checker = checker
+	}
+}
+
+// WithPoolsHealth exposes database pools in /health (see db.PGStorage.CheckPools)
+func WithPoolsHealth(checkPools func(ctx context.Context) []db.PoolHealth) Option {
+	return func(s *Server) {
+		s.checkPools = checkPools
+	}
+}
+
 func NewServer(config *config.Config,
 	storage repository.URLRepository,
-	generator service.IDGenerator) *Server {
+	generator service.IDGenerator,
+	opts ...Option) *Server {
 	s := &Server{
 		config:    config,
 		storage:   storage,
@@ -35,6 +71,9 @@ func NewServer(config *config.Config,
 		generator: generator,
 		DB:        nil,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
 
 	s.setupRoutes()
 	return s
@@ -44,8 +83,13 @@ func (s *Server) setupRoutes() {
 	// add logging middleware to all routes
 	s.router.Use(auth.JWTAuth)
 	s.router.Use(logging.WithLogging)
-	s.router.Use(compress.GzipRequest)
-	s.router.Use(compress.GzipResponse)
+	s.router.Use(compress.Request(int64(s.config.MaxDecodedBody)))
+	// media types are validated with config
+	compressTypes, _ := s.config.CompressMediaTypes()
+	s.router.Use(compress.Response(compressTypes, s.config.CompressMinSize))
+	if s.config.EnableHTTPS && s.config.HSTSMaxAge > 0 {
+		s.router.Use(hsts(s.config.HSTSMaxAge))
+	}
 
 	s.router.NotFound(func(w http.ResponseWriter, r *http.Request) {
 		handler.BadRequest(w, "Not found")
@@ -55,6 +99,18 @@ func (s *Server) setupRoutes() {
 	})
 
 	h := handler.NewURLHandlers(s.storage, s.config.BaseURL, s.generator)
+	h.RedirectCode = s.config.RedirectCode
+	h.QueryPolicy = s.config.QueryPolicy
+	h.GeoIP = s.geoIP
+	// proxies are validated with config
+	proxies, _ := s.config.TrustedProxyNetworks()
+	h.ClientIP = clientip.New(proxies)
+	h.ReportLimiter = ratelimit.New(s.config.ReportRateLimit, time.Minute)
+	if s.checker == nil {
+		s.checker = urlcheck.New(s.config.BaseURL, s.config.AllowedSchemes, s.config.MaxURLLength)
+	}
+	h.Shortener.Checker = s.checker
+	s.shortener = h.Shortener
 
 	// post
 	s.router.Post("/", h.Create)
@@ -64,9 +120,48 @@ func (s *Server) setupRoutes() {
 	s.router.Get("/", h.Redirect)
 	s.router.Get("/{id}", h.Redirect)
 	s.router.Get("/ping", s.Ping)
+	s.router.Get("/health", s.Health)
 	s.router.Get("/api/user/urls", h.GetUserURLs)
+	s.router.Get("/api/user/urls/export", h.ExportUserURLs)
+	s.router.Get("/api/user/urls/{id}/settings", h.GetLinkSettings)
+	s.router.Get("/api/user/urls/{id}/rules", h.GetRedirectRules)
+	s.router.Get("/api/user/urls/{id}/variants", h.GetVariants)
+	s.router.Post("/api/user/urls/{id}/rules", h.AddRedirectRule)
+	s.router.Post("/api/report/{id}", h.ReportLink)
+	// put
+	s.router.Put("/api/user/urls/{id}/settings", h.UpdateLinkSettings)
+	s.router.Put("/api/user/urls/{id}/rules", h.ReplaceRedirectRules)
+	s.router.Put("/api/user/urls/{id}/variants", h.ReplaceVariants)
 	// delete
 	s.router.Delete("/api/user/urls", h.DeleteUserURLs)
+	s.router.Delete("/api/user/urls/{id}/rules/{index}", h.DeleteRedirectRule)
+
+	// admin
+	a := handler.NewAdminHandlers(s.storage, s.config.BaseURL)
+	a.Checker = s.checker
+	a.SchemaVersion = func() (uint, error) {
+		return migrations.SchemaVersion(s.config.DSN)
+	}
+	s.router.Route("/api/admin", func(r chi.Router) {
+		r.Use(auth.RequireAdmin)
+		r.Get("/urls", a.SearchLinks)
+		r.Get("/urls/{id}", a.GetLink)
+		r.Delete("/urls/{id}", a.ForceDelete)
+		r.Post("/urls/{id}/ban", a.Ban)
+		r.Delete("/urls/{id}/ban", a.Unban)
+		r.Post("/users/{userID}/disable", a.DisableUser)
+		r.Get("/stats", a.Stats)
+		r.Get("/reports", a.GetReports)
+		r.Post("/reports/{reportID}/resolve", a.ResolveReport)
+		r.Post("/import", a.Import)
+		r.Get("/backup", a.Backup)
+		r.Post("/restore", a.Restore)
+		r.Handle("/debug/vars", expvar.Handler())
+	})
+
+	// internal API is restricted by client IP instead of token, subnet is validated with config
+	trusted, _ := s.config.TrustedNetwork()
+	s.router.With(trustedSubnet(trusted, h.ClientIP)).Get("/api/internal/stats", a.InternalStats)
 }
 
 func (s *Server) Ping(w http.ResponseWriter, r *http.Request) {
@@ -88,6 +183,53 @@ func (s *Server) Ping(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// health statuses
+const (
+	healthOK = "ok"
+	// healthDegraded means some replicas are down and their reads go to primary
+	healthDegraded = "degraded"
+	healthDown     = "down"
+)
+
+type healthResponse struct {
+	Status string          `json:"status"`
+	Pools  []db.PoolHealth `json:"pools"`
+}
+
+// Health reports every database pool, it fails only if primary is down
+func (s *Server) Health(w http.ResponseWriter, r *http.Request) {
+	resp := healthResponse{Status: healthOK, Pools: []db.PoolHealth{}}
+	if s.checkPools != nil {
+		resp.Pools = s.checkPools(r.Context())
+	}
+
+	code := http.StatusOK
+	for i, pool := range resp.Pools {
+		if pool.Healthy {
+			continue
+		}
+		// primary goes first
+		if i == 0 {
+			resp.Status = healthDown
+			code = http.StatusServiceUnavailable
+			break
+		}
+		resp.Status = healthDegraded
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("ERROR: cannot write response body: %v", err)
+	}
+}
+
+// Drain waits for work started by handlers after response (e.g. deletions).
+// It must be called after http.Server is shut down
+func (s *Server) Drain(ctx context.Context) error {
+	return s.shortener.Wait(ctx)
+}
+
 func (s *Server) Handler() http.Handler {
 	return s.router
 }
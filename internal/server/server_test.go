@@ -1,17 +1,23 @@
 package server
 
 import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 
+	"github.com/bissquit/url-shortener/internal/auth"
 	"github.com/bissquit/url-shortener/internal/config"
 	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/bissquit/url-shortener/internal/repository/db"
 	"github.com/bissquit/url-shortener/internal/repository/memory"
 	"github.com/bissquit/url-shortener/internal/service/crypto"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_NewServer(t *testing.T) {
@@ -59,7 +65,7 @@ func Test_ServerRoutes(t *testing.T) {
 			method: http.MethodGet,
 			path:   "/skfjnvoe34nk",
 			setupStorage: func(s repository.URLRepository) {
-				s.Create("skfjnvoe34nk", testShortURL, userID)
+				s.Create("skfjnvoe34nk", testShortURL, userID, repository.LinkSettings{})
 			},
 			wantStatus: http.StatusTemporaryRedirect,
 		},
@@ -101,3 +107,96 @@ func Test_ServerRoutes(t *testing.T) {
 		})
 	}
 }
+
+func Test_ServerExportIsCompressed(t *testing.T) {
+	const userID = "export-user"
+
+	storage := memory.NewURLStorage()
+	require.NoError(t, storage.Create("export001", "https://example.com", userID, repository.LinkSettings{}))
+	cfg := config.GetDefaultConfig()
+	// export of a single link is smaller than default threshold
+	cfg.CompressMinSize = 0
+	srv := NewServer(cfg, storage, crypto.NewRandomGenerator())
+
+	token, err := auth.BuildToken(userID, "", 0)
+	require.NoError(t, err)
+	r := httptest.NewRequest(http.MethodGet, "/api/user/urls/export?format=csv", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	zr, err := gzip.NewReader(w.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "export001,http://localhost:8080/export001,https://example.com,")
+}
+
+func Test_ServerHealth(t *testing.T) {
+	tests := []struct {
+		name       string
+		pools      []db.PoolHealth
+		wantStatus int
+		wantHealth string
+	}{
+		{
+			name:       "no database",
+			pools:      nil,
+			wantStatus: http.StatusOK,
+			wantHealth: healthOK,
+		},
+		{
+			name: "all pools are healthy",
+			pools: []db.PoolHealth{
+				{Name: "primary", Healthy: true},
+				{Name: "replica-1", Healthy: true},
+			},
+			wantStatus: http.StatusOK,
+			wantHealth: healthOK,
+		},
+		{
+			name: "replica is down",
+			pools: []db.PoolHealth{
+				{Name: "primary", Healthy: true},
+				{Name: "replica-1", Error: "connection refused"},
+			},
+			wantStatus: http.StatusOK,
+			wantHealth: healthDegraded,
+		},
+		{
+			name: "primary is down",
+			pools: []db.PoolHealth{
+				{Name: "primary", Error: "connection refused"},
+				{Name: "replica-1", Healthy: true},
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantHealth: healthDown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []Option
+			if tt.pools != nil {
+				opts = append(opts, WithPoolsHealth(func(context.Context) []db.PoolHealth { return tt.pools }))
+			}
+			srv := NewServer(config.GetDefaultConfig(), memory.NewURLStorage(), crypto.NewRandomGenerator(), opts...)
+
+			w := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, tt.wantStatus, res.StatusCode)
+
+			var body healthResponse
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+			assert.Equal(t, tt.wantHealth, body.Status)
+			assert.Len(t, body.Pools, len(tt.pools))
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package server
+
+import (
+	"net/http"
+	"net/netip"
+
+	"github.com/bissquit/url-shortener/internal/clientip"
+)
+
+// trustedSubnet lets through clients from subnet only, invalid subnet forbids everyone.
+// Client IP headers are honored only when they are set by a trusted proxy (see clientip.Resolver)
+func trustedSubnet(subnet netip.Prefix, resolver *clientip.Resolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip, ok := resolver.ClientIP(r)
+			if !subnet.IsValid() || !ok || !subnet.Contains(ip) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/bissquit/url-shortener/internal/clientip"
+	"github.com/bissquit/url-shortener/internal/config"
+	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/bissquit/url-shortener/internal/repository/memory"
+	"github.com/bissquit/url-shortener/internal/service/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_trustedSubnet(t *testing.T) {
+	tests := []struct {
+		name       string
+		subnet     string
+		proxies    string
+		remoteAddr string
+		realIP     string
+		wantStatus int
+	}{
+		{
+			name:       "subnet is not set",
+			remoteAddr: "10.0.0.1:1234",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "X-Real-IP from untrusted client is ignored",
+			subnet:     "192.168.1.0/24",
+			remoteAddr: "203.0.113.5:1234",
+			realIP:     "192.168.1.15",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "X-Real-IP in subnet from trusted proxy",
+			subnet:     "192.168.1.0/24",
+			proxies:    "10.0.0.1",
+			remoteAddr: "10.0.0.1:1234",
+			realIP:     "192.168.1.15",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "X-Real-IP outside subnet from trusted proxy wins over remote address",
+			subnet:     "10.0.0.0/8",
+			proxies:    "10.0.0.1",
+			remoteAddr: "10.0.0.1:1234",
+			realIP:     "192.168.1.15",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "remote address in subnet",
+			subnet:     "10.0.0.0/8",
+			remoteAddr: "10.0.0.1:1234",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "IPv6 subnet",
+			subnet:     "2001:db8::/32",
+			remoteAddr: "[2001:db8::1]:1234",
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var subnet netip.Prefix
+			if tt.subnet != "" {
+				subnet = netip.MustParsePrefix(tt.subnet)
+			}
+			cfg := config.GetDefaultConfig()
+			cfg.TrustedProxies = tt.proxies
+			proxies, err := cfg.TrustedProxyNetworks()
+			require.NoError(t, err)
+			handler := trustedSubnet(subnet, clientip.New(proxies))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			r := httptest.NewRequest(http.MethodGet, "/api/internal/stats", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
+
+func Test_ServerInternalStats(t *testing.T) {
+	storage := memory.NewURLStorage()
+	require.NoError(t, storage.Create("a", "https://example.com/a", "user-1", repository.LinkSettings{}))
+	require.NoError(t, storage.Create("b", "https://example.com/b", "user-1", repository.LinkSettings{}))
+	require.NoError(t, storage.Create("c", "https://example.com/c", "user-2", repository.LinkSettings{}))
+	require.NoError(t, storage.Create("d", "https://example.com/d", "user-3", repository.LinkSettings{}))
+	require.NoError(t, storage.DeleteBatch("user-3", []string{"d"}))
+
+	cfg := config.GetDefaultConfig()
+	cfg.TrustedSubnet = "127.0.0.0/8"
+	srv := NewServer(cfg, storage, crypto.NewRandomGenerator())
+
+	r := httptest.NewRequest(http.MethodGet, "/api/internal/stats", nil)
+	r.RemoteAddr = "127.0.0.1:1234"
+	w := httptest.NewRecorder()
+	srv.router.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var got struct {
+		URLs  int64 `json:"urls"`
+		Users int64 `json:"users"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&got))
+	assert.Equal(t, int64(3), got.URLs)
+	assert.Equal(t, int64(2), got.Users)
+
+	r = httptest.NewRequest(http.MethodGet, "/api/internal/stats", nil)
+	r.RemoteAddr = "192.168.1.1:1234"
+	w = httptest.NewRecorder()
+	srv.router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// client outside subnet can't pretend to be inside it
+	r = httptest.NewRequest(http.MethodGet, "/api/internal/stats", nil)
+	r.RemoteAddr = "192.168.1.1:1234"
+	r.Header.Set("X-Real-IP", "127.0.0.1")
+	w = httptest.NewRecorder()
+	srv.router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
@@ -0,0 +1,367 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/bissquit/url-shortener/internal/config"
+	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/bissquit/url-shortener/internal/service/urlcheck"
+)
+
+var (
+	ErrNotFound = errors.New("link not found")
+	ErrDeleted  = errors.New("link is deleted")
+	ErrBlocked  = errors.New("link is blocked")
+	// ErrURLConflict means URL of a batch is already shortened, the whole batch is rejected
+	ErrURLConflict           = errors.New("URL is already shortened")
+	ErrIDGenerationExhausted = errors.New("id generation exhausted")
+)
+
+// ValidationError rejects a request value. Err of URL field is one of urlcheck errors
+type ValidationError struct {
+	Field string
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %v", e.Field, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+type ShortenRequest struct {
+	UserID   string
+	URL      string
+	Settings repository.LinkSettings
+}
+
+type ShortenResponse struct {
+	ShortURL string
+	// Created is false when URL is already stored, ShortURL is the existing one then
+	Created bool
+}
+
+type ShortenBatchRequest struct {
+	UserID string
+	Items  []repository.BatchItemInput
+}
+
+type ShortenBatchResponse struct {
+	// Items are in order of request items
+	Items []repository.BatchItemOutput
+}
+
+// UserURL is a link of the user
+type UserURL struct {
+	ShortURL    string `json:"short_url"`
+	OriginalURL string `json:"original_url"`
+}
+
+// Shortener creates, resolves and deletes links independently of transport
+type Shortener struct {
+	storage   repository.URLRepository
+	baseURL   string
+	generator IDGenerator
+	// Checker validates every destination URL before it's stored
+	Checker *urlcheck.Checker
+	// pending counts deletions running in background
+	pending sync.WaitGroup
+}
+
+func NewShortener(storage repository.URLRepository, baseURL string, generator IDGenerator, checker *urlcheck.Checker) *Shortener {
+	return &Shortener{
+		storage:   storage,
+		baseURL:   baseURL,
+		generator: generator,
+		Checker:   checker,
+	}
+}
+
+// ValidateLinkSettings accepts zero values meaning server defaults
+func ValidateLinkSettings(settings repository.LinkSettings) error {
+	if settings.RedirectCode != 0 && !config.IsRedirectCode(settings.RedirectCode) {
+		return &ValidationError{Field: "redirect code", Err: fmt.Errorf("unsupported: %d", settings.RedirectCode)}
+	}
+	if settings.QueryPolicy != "" && !config.IsQueryPolicy(settings.QueryPolicy) {
+		return &ValidationError{Field: "query policy", Err: fmt.Errorf("unsupported: %q", settings.QueryPolicy)}
+	}
+	for k := range settings.UTM {
+		if !strings.HasPrefix(k, "utm_") {
+			return &ValidationError{Field: "UTM", Err: fmt.Errorf("not an UTM parameter: %q", k)}
+		}
+	}
+	return nil
+}
+
+// Shorten stores URL with settings. Settings are applied only to a newly created link,
+// already stored URL is returned untouched with Created=false
+func (s *Shortener) Shorten(req ShortenRequest) (ShortenResponse, error) {
+	originalURL, err := s.Checker.Check(req.URL)
+	if err != nil {
+		return ShortenResponse{}, &ValidationError{Field: "URL", Err: err}
+	}
+	if err := ValidateLinkSettings(req.Settings); err != nil {
+		return ShortenResponse{}, err
+	}
+
+	const maxAttempts = 10
+
+	for i := 0; i < maxAttempts; i++ {
+		// trying to generate short ID
+		id, err := s.generator.GenerateShortID()
+		if err != nil {
+			return ShortenResponse{}, fmt.Errorf("cannot generate shorten ID: %w", err)
+		}
+		if id == "" {
+			return ShortenResponse{}, fmt.Errorf("generator returned empty id")
+		}
+
+		// trying to save ID
+		err = s.storage.Create(id, originalURL, req.UserID, req.Settings)
+		switch {
+		case err == nil:
+			shortURL, err := s.shortURL(id)
+			if err != nil {
+				return ShortenResponse{}, err
+			}
+			return ShortenResponse{ShortURL: shortURL, Created: true}, nil
+
+		case errors.Is(err, repository.ErrIDAlreadyExists):
+			// short_id collision --> trying another id
+			log.Printf("INFO: short_id collision (attempt %d/%d): %v", i+1, maxAttempts, err)
+			continue
+
+		case errors.Is(err, repository.ErrURLAlreadyExists):
+			// URL already exist --> make additional request to return existing short_url
+			existingID, err2 := s.storage.GetIDByURL(originalURL)
+			if err2 != nil {
+				return ShortenResponse{}, fmt.Errorf("url exists but cannot get id by url: %w", err2)
+			}
+			shortURL, err2 := s.shortURL(existingID)
+			if err2 != nil {
+				return ShortenResponse{}, err2
+			}
+			return ShortenResponse{ShortURL: shortURL}, nil
+
+		default:
+			return ShortenResponse{}, fmt.Errorf("unknown storage error: %w", err)
+		}
+	}
+
+	return ShortenResponse{}, fmt.Errorf("%w: attempts=%d", ErrIDGenerationExhausted, maxAttempts)
+}
+
+// ShortenBatch stores all URLs or none of them. Colliding IDs are regenerated,
+// ErrURLConflict is returned when any of URLs is already stored
+func (s *Shortener) ShortenBatch(req ShortenBatchRequest) (ShortenBatchResponse, error) {
+	if len(req.Items) == 0 {
+		return ShortenBatchResponse{}, &ValidationError{Field: "batch", Err: errors.New("empty batch")}
+	}
+
+	// return if even one url is invalid
+	batch := make([]repository.URLItem, 0, len(req.Items))
+	for _, item := range req.Items {
+		originalURL, err := s.Checker.Check(item.OriginalURL)
+		if err != nil {
+			return ShortenBatchResponse{}, &ValidationError{Field: "URL in a batch", Err: fmt.Errorf("%s: %w", item.OriginalURL, err)}
+		}
+		batch = append(batch, repository.URLItem{OriginalURL: originalURL})
+	}
+
+	const (
+		maxBatchAttempts = 10
+		maxIDAttempts    = 10
+	)
+
+	// all ids are generated on the first attempt, only colliding ones later
+	regenerate := make(map[int]struct{}, len(batch))
+	for i := range batch {
+		regenerate[i] = struct{}{}
+	}
+
+	for attempt := 0; attempt < maxBatchAttempts; attempt++ {
+		// 1) generate ids unique inside the batch (without storage lookups)
+		seenIDs := make(map[string]struct{}, len(batch))
+		for i, item := range batch {
+			if _, ok := regenerate[i]; !ok {
+				seenIDs[item.ID] = struct{}{}
+			}
+		}
+		// ids are generated in order of items, so it doesn't depend on map iteration
+		for i := range batch {
+			if _, ok := regenerate[i]; !ok {
+				continue
+			}
+			id, err := s.generateBatchID(seenIDs, maxIDAttempts)
+			if err != nil {
+				return ShortenBatchResponse{}, fmt.Errorf("cannot generate shorten ID: %w", err)
+			}
+			seenIDs[id] = struct{}{}
+			batch[i].ID = id
+		}
+
+		// 2) try to insert the whole batch
+		err := s.storage.CreateBatch(batch, req.UserID)
+
+		var conflict *repository.BatchConflictError
+		switch {
+		case err == nil:
+			return s.batchResult(req.Items, batch)
+
+		case errors.Is(err, repository.ErrURLAlreadyExists):
+			// original_url already exists → don't retry, it would never succeed
+			return ShortenBatchResponse{}, fmt.Errorf("%w: %w", ErrURLConflict, err)
+
+		case errors.As(err, &conflict):
+			// short_id collision → regenerate taken ids only
+			log.Printf("INFO: %d short_id collisions in batch attempt %d/%d: %v",
+				len(conflict.IDs), attempt+1, maxBatchAttempts, err)
+			regenerate = collidingItems(batch, conflict.IDs)
+
+		case errors.Is(err, repository.ErrIDAlreadyExists):
+			// storage doesn't tell which ids collided → regenerate all of them
+			log.Printf("INFO: short_id collision in batch attempt %d/%d: %v", attempt+1, maxBatchAttempts, err)
+			regenerate = collidingItems(batch, nil)
+
+		default:
+			return ShortenBatchResponse{}, fmt.Errorf("cannot insert batch: %w", err)
+		}
+	}
+
+	return ShortenBatchResponse{}, fmt.Errorf("%w: batch attempts=%d", ErrIDGenerationExhausted, maxBatchAttempts)
+}
+
+func (s *Shortener) batchResult(items []repository.BatchItemInput, batch []repository.URLItem) (ShortenBatchResponse, error) {
+	result := make([]repository.BatchItemOutput, 0, len(batch))
+	for i, item := range batch {
+		shortURL, err := s.shortURL(item.ID)
+		if err != nil {
+			return ShortenBatchResponse{}, err
+		}
+		result = append(result, repository.BatchItemOutput{
+			CorrelationID: items[i].CorrelationID,
+			ShortURL:      shortURL,
+		})
+	}
+	return ShortenBatchResponse{Items: result}, nil
+}
+
+// generateBatchID returns id which is not in seenIDs
+func (s *Shortener) generateBatchID(seenIDs map[string]struct{}, maxAttempts int) (string, error) {
+	for i := 0; i < maxAttempts; i++ {
+		id, err := s.generator.GenerateShortID()
+		if err != nil {
+			return "", err
+		}
+		if id == "" {
+			return "", errors.New("generator returned empty id")
+		}
+		if _, ok := seenIDs[id]; !ok {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("no unique id in %d attempts", maxAttempts)
+}
+
+// collidingItems returns indexes of batch items with given ids, nil ids means all items
+func collidingItems(batch []repository.URLItem, ids []string) map[int]struct{} {
+	colliding := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		colliding[id] = struct{}{}
+	}
+	indexes := make(map[int]struct{}, len(ids))
+	for i, item := range batch {
+		if _, ok := colliding[item.ID]; ok || ids == nil {
+			indexes[i] = struct{}{}
+		}
+	}
+	return indexes
+}
+
+// Expand returns original URL of a link without applying rules, variants and query policy
+func (s *Shortener) Expand(id string) (string, error) {
+	if id == "" {
+		return "", &ValidationError{Field: "id", Err: errors.New("empty id")}
+	}
+	link, err := s.storage.GetLink(id)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		return "", ErrNotFound
+	case err != nil:
+		return "", fmt.Errorf("cannot get link %s: %w", id, err)
+	case link.Deleted:
+		return "", ErrDeleted
+	case link.Banned:
+		return "", ErrBlocked
+	}
+	return link.OriginalURL, nil
+}
+
+// UserURLs returns links of the user, empty list is not an error
+func (s *Shortener) UserURLs(userID string) ([]UserURL, error) {
+	items, err := s.storage.GetURLsByUserID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get urls by user %s: %w", userID, err)
+	}
+
+	result := make([]UserURL, 0, len(items))
+	for _, it := range items {
+		shortURL, err := s.shortURL(it.ShortID)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, UserURL{
+			ShortURL:    shortURL,
+			OriginalURL: it.OriginalURL,
+		})
+	}
+	return result, nil
+}
+
+// DeleteURLs deletes links of the user in background, ids of other users are skipped.
+// Wait blocks until started deletions are finished
+func (s *Shortener) DeleteURLs(userID string, ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	s.pending.Add(1)
+	go func(userID string, ids []string) {
+		defer s.pending.Done()
+		if err := s.storage.DeleteBatch(userID, ids); err != nil {
+			log.Printf("delete batch failed: user=%s, ids=%v, err=%v", userID, ids, err)
+		}
+	}(userID, ids)
+}
+
+// Wait blocks until background deletions are finished or ctx is done.
+// No new requests must be served meanwhile
+func (s *Shortener) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Shortener) shortURL(id string) (string, error) {
+	shortURL, err := url.JoinPath(s.baseURL, id)
+	if err != nil {
+		return "", fmt.Errorf("cannot build shorten URL (baseURL=%q, id=%q): %w", s.baseURL, id, err)
+	}
+	return shortURL, nil
+}
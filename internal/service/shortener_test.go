@@ -0,0 +1,248 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/bissquit/url-shortener/internal/repository/memory"
+	"github.com/bissquit/url-shortener/internal/service/urlcheck"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testBaseURL = "http://localhost:8080"
+	testUserID  = "user-1"
+)
+
+var errNoEntropy = errors.New("no entropy")
+
+// sequenceGenerator returns ids one by one, err is returned instead when it's set
+type sequenceGenerator struct {
+	ids   []string
+	err   error
+	calls int
+}
+
+func (g *sequenceGenerator) GenerateShortID() (string, error) {
+	if g.err != nil {
+		return "", g.err
+	}
+	id := g.ids[g.calls%len(g.ids)]
+	g.calls++
+	return id, nil
+}
+
+func newTestShortener(storage repository.URLRepository, gen IDGenerator) *Shortener {
+	checker := urlcheck.New(testBaseURL, urlcheck.DefaultSchemes, urlcheck.DefaultMaxLength)
+	return NewShortener(storage, testBaseURL, gen, checker)
+}
+
+func Test_ShortenerShorten(t *testing.T) {
+	tests := []struct {
+		name    string
+		gen     *sequenceGenerator
+		setup   func(t *testing.T, s repository.URLRepository)
+		req     ShortenRequest
+		want    ShortenResponse
+		wantErr error
+		// wantInvalid expects ValidationError
+		wantInvalid bool
+	}{
+		{
+			name: "created",
+			gen:  &sequenceGenerator{ids: []string{"abc"}},
+			req:  ShortenRequest{UserID: testUserID, URL: "https://example.com"},
+			want: ShortenResponse{ShortURL: testBaseURL + "/abc", Created: true},
+		},
+		{
+			name: "URL is already stored",
+			gen:  &sequenceGenerator{ids: []string{"abc"}},
+			setup: func(t *testing.T, s repository.URLRepository) {
+				require.NoError(t, s.Create("existing", "https://example.com", testUserID, repository.LinkSettings{}))
+			},
+			req:  ShortenRequest{UserID: testUserID, URL: "https://example.com"},
+			want: ShortenResponse{ShortURL: testBaseURL + "/existing"},
+		},
+		{
+			name: "ID collision is retried",
+			gen:  &sequenceGenerator{ids: []string{"taken", "free"}},
+			setup: func(t *testing.T, s repository.URLRepository) {
+				require.NoError(t, s.Create("taken", "https://example.org", testUserID, repository.LinkSettings{}))
+			},
+			req:  ShortenRequest{UserID: testUserID, URL: "https://example.com"},
+			want: ShortenResponse{ShortURL: testBaseURL + "/free", Created: true},
+		},
+		{
+			name: "all generated IDs collide",
+			gen:  &sequenceGenerator{ids: []string{"taken"}},
+			setup: func(t *testing.T, s repository.URLRepository) {
+				require.NoError(t, s.Create("taken", "https://example.org", testUserID, repository.LinkSettings{}))
+			},
+			req:     ShortenRequest{UserID: testUserID, URL: "https://example.com"},
+			wantErr: ErrIDGenerationExhausted,
+		},
+		{
+			name:        "scheme is not allowed",
+			gen:         &sequenceGenerator{ids: []string{"abc"}},
+			req:         ShortenRequest{UserID: testUserID, URL: "javascript:alert(1)"},
+			wantErr:     urlcheck.ErrSchemeNotAllowed,
+			wantInvalid: true,
+		},
+		{
+			name: "invalid settings",
+			gen:  &sequenceGenerator{ids: []string{"abc"}},
+			req: ShortenRequest{UserID: testUserID, URL: "https://example.com",
+				Settings: repository.LinkSettings{UTM: map[string]string{"ref": "x"}}},
+			wantInvalid: true,
+		},
+		{
+			name:    "generator error",
+			gen:     &sequenceGenerator{err: errNoEntropy},
+			req:     ShortenRequest{UserID: testUserID, URL: "https://example.com"},
+			wantErr: errNoEntropy,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := memory.NewURLStorage()
+			if tt.setup != nil {
+				tt.setup(t, storage)
+			}
+
+			got, err := newTestShortener(storage, tt.gen).Shorten(tt.req)
+			if tt.wantErr != nil || tt.wantInvalid {
+				require.Error(t, err)
+				if tt.wantErr != nil {
+					assert.ErrorIs(t, err, tt.wantErr)
+				}
+				var invalid *ValidationError
+				assert.Equal(t, tt.wantInvalid, errors.As(err, &invalid))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_ShortenerShortenBatch(t *testing.T) {
+	items := []repository.BatchItemInput{
+		{CorrelationID: "1", OriginalURL: "https://example.com/1"},
+		{CorrelationID: "2", OriginalURL: "https://example.com/2"},
+	}
+
+	t.Run("only colliding ID is regenerated", func(t *testing.T) {
+		storage := memory.NewURLStorage()
+		require.NoError(t, storage.Create("b", "https://example.org", testUserID, repository.LinkSettings{}))
+		gen := &sequenceGenerator{ids: []string{"a", "b", "c"}}
+
+		got, err := newTestShortener(storage, gen).ShortenBatch(ShortenBatchRequest{UserID: testUserID, Items: items})
+		require.NoError(t, err)
+		assert.Equal(t, 3, gen.calls)
+		assert.Equal(t, []repository.BatchItemOutput{
+			{CorrelationID: "1", ShortURL: testBaseURL + "/a"},
+			{CorrelationID: "2", ShortURL: testBaseURL + "/c"},
+		}, got.Items)
+	})
+
+	t.Run("stored URL rejects batch", func(t *testing.T) {
+		storage := memory.NewURLStorage()
+		require.NoError(t, storage.Create("x", "https://example.com/2", testUserID, repository.LinkSettings{}))
+
+		_, err := newTestShortener(storage, &sequenceGenerator{ids: []string{"a", "b"}}).
+			ShortenBatch(ShortenBatchRequest{UserID: testUserID, Items: items})
+		assert.ErrorIs(t, err, ErrURLConflict)
+	})
+
+	t.Run("invalid URL rejects batch", func(t *testing.T) {
+		bad := append([]repository.BatchItemInput{}, items...)
+		bad[1].OriginalURL = "ftp://example.com"
+
+		_, err := newTestShortener(memory.NewURLStorage(), &sequenceGenerator{ids: []string{"a", "b"}}).
+			ShortenBatch(ShortenBatchRequest{UserID: testUserID, Items: bad})
+		var invalid *ValidationError
+		assert.ErrorAs(t, err, &invalid)
+		assert.ErrorIs(t, err, urlcheck.ErrSchemeNotAllowed)
+	})
+
+	t.Run("empty batch", func(t *testing.T) {
+		_, err := newTestShortener(memory.NewURLStorage(), &sequenceGenerator{ids: []string{"a"}}).
+			ShortenBatch(ShortenBatchRequest{UserID: testUserID})
+		var invalid *ValidationError
+		assert.ErrorAs(t, err, &invalid)
+	})
+}
+
+func Test_ShortenerExpand(t *testing.T) {
+	storage := memory.NewURLStorage()
+	require.NoError(t, storage.Create("live", "https://example.com/live", testUserID, repository.LinkSettings{}))
+	require.NoError(t, storage.Create("deleted", "https://example.com/deleted", testUserID, repository.LinkSettings{}))
+	require.NoError(t, storage.Create("banned", "https://example.com/banned", testUserID, repository.LinkSettings{}))
+	require.NoError(t, storage.DeleteBatch(testUserID, []string{"deleted"}))
+	require.NoError(t, storage.SetBanned("banned", true))
+	s := newTestShortener(storage, &sequenceGenerator{ids: []string{"a"}})
+
+	tests := []struct {
+		id      string
+		want    string
+		wantErr error
+	}{
+		{id: "live", want: "https://example.com/live"},
+		{id: "deleted", wantErr: ErrDeleted},
+		{id: "banned", wantErr: ErrBlocked},
+		{id: "unknown", wantErr: ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			got, err := s.Expand(tt.id)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_ShortenerDeleteURLs(t *testing.T) {
+	storage := memory.NewURLStorage()
+	require.NoError(t, storage.Create("mine", "https://example.com/mine", testUserID, repository.LinkSettings{}))
+	require.NoError(t, storage.Create("other", "https://example.com/other", "user-2", repository.LinkSettings{}))
+	s := newTestShortener(storage, &sequenceGenerator{ids: []string{"a"}})
+
+	s.DeleteURLs(testUserID, []string{"mine", "other"})
+	require.NoError(t, s.Wait(context.Background()))
+
+	urls, err := s.UserURLs(testUserID)
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+	urls, err = s.UserURLs("user-2")
+	require.NoError(t, err)
+	assert.Equal(t, []UserURL{{ShortURL: testBaseURL + "/other", OriginalURL: "https://example.com/other"}}, urls)
+}
+
+func Test_ShortenerWait(t *testing.T) {
+	s := newTestShortener(memory.NewURLStorage(), &sequenceGenerator{ids: []string{"a"}})
+
+	// nothing is pending
+	assert.NoError(t, s.Wait(context.Background()))
+
+	// pending deletion is not finished before ctx is done
+	s.pending.Add(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Wait(ctx), context.DeadlineExceeded)
+
+	// pending deletion is finished
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		s.pending.Done()
+	}()
+	assert.NoError(t, s.Wait(context.Background()))
+}
@@ -0,0 +1,202 @@
+package transfer
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/bissquit/url-shortener/internal/repository"
+)
+
+// ArchiveFormatVersion changes when archive layout changes,
+// data layout is described by schema version (see migrations.SchemaVersion)
+const ArchiveFormatVersion = 1
+
+var (
+	ErrBadArchive     = errors.New("bad archive")
+	ErrSchemaMismatch = errors.New("archive schema version doesn't match storage")
+	ErrNotEmpty       = repository.ErrNotEmpty
+)
+
+// archive is a gzipped NDJSON: header line, link lines and end line.
+// End line lets restore detect truncated archives
+const (
+	lineHeader = "header"
+	lineLink   = "link"
+	lineEnd    = "end"
+)
+
+type archiveLine struct {
+	Type   string         `json:"type"`
+	Header *archiveHeader `json:"header,omitempty"`
+	Link   *archiveLink   `json:"link,omitempty"`
+	End    *archiveEnd    `json:"end,omitempty"`
+}
+
+type archiveHeader struct {
+	FormatVersion int       `json:"format_version"`
+	SchemaVersion uint      `json:"schema_version"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type archiveEnd struct {
+	Links int64 `json:"links"`
+}
+
+// archiveLink doesn't depend on repository.Link, so archive format is changed deliberately only
+type archiveLink struct {
+	ShortID     string                    `json:"short_id"`
+	OriginalURL string                    `json:"original_url"`
+	UserID      string                    `json:"user_id"`
+	CreatedAt   time.Time                 `json:"created_at,omitzero"`
+	Deleted     bool                      `json:"is_deleted"`
+	Banned      bool                      `json:"is_banned"`
+	Settings    repository.LinkSettings   `json:"settings"`
+	Rules       []repository.RedirectRule `json:"rules,omitempty"`
+	Variants    []repository.Variant      `json:"variants,omitempty"`
+}
+
+func toArchiveLink(link repository.Link) *archiveLink {
+	return &archiveLink{
+		ShortID:     link.ShortID,
+		OriginalURL: link.OriginalURL,
+		UserID:      link.UserID,
+		CreatedAt:   link.CreatedAt,
+		Deleted:     link.Deleted,
+		Banned:      link.Banned,
+		Settings:    link.Settings,
+		Rules:       link.Rules,
+		Variants:    link.Variants,
+	}
+}
+
+func (l *archiveLink) link() repository.Link {
+	return repository.Link{
+		ShortID:     l.ShortID,
+		OriginalURL: l.OriginalURL,
+		UserID:      l.UserID,
+		CreatedAt:   l.CreatedAt,
+		Deleted:     l.Deleted,
+		Banned:      l.Banned,
+		Settings:    l.Settings,
+		Rules:       l.Rules,
+		Variants:    l.Variants,
+	}
+}
+
+// Backup writes all links of storage into w as archive of schemaVersion
+// and returns number of written links. Storage may be used by server meanwhile,
+// IterateLinks guarantees point-in-time state. Moderation reports are not included
+func Backup(ctx context.Context, storage repository.URLRepository, w io.Writer, schemaVersion uint) (int64, error) {
+	zw := gzip.NewWriter(w)
+	enc := json.NewEncoder(zw)
+
+	err := enc.Encode(archiveLine{Type: lineHeader, Header: &archiveHeader{
+		FormatVersion: ArchiveFormatVersion,
+		SchemaVersion: schemaVersion,
+		CreatedAt:     time.Now().UTC(),
+	}})
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	err = storage.IterateLinks(ctx, func(link repository.Link) error {
+		n++
+		return enc.Encode(archiveLine{Type: lineLink, Link: toArchiveLink(link)})
+	})
+	if err != nil {
+		return n, err
+	}
+
+	if err = enc.Encode(archiveLine{Type: lineEnd, End: &archiveEnd{Links: n}}); err != nil {
+		return n, err
+	}
+	return n, zw.Close()
+}
+
+// Restore puts links from archive into empty storage by chunks
+// and returns number of restored links. Archive schema version must be equal to schemaVersion.
+// Links are restored at once (see RestoreLinks), so nothing is stored on error
+func Restore(ctx context.Context, storage repository.URLRepository, r io.Reader, schemaVersion uint, chunkSize int) (int64, error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrBadArchive, err)
+	}
+	defer zr.Close()
+	dec := json.NewDecoder(bufio.NewReader(zr))
+
+	var header archiveLine
+	if err = dec.Decode(&header); err != nil || header.Type != lineHeader || header.Header == nil {
+		return 0, fmt.Errorf("%w: header is missing", ErrBadArchive)
+	}
+	if header.Header.FormatVersion != ArchiveFormatVersion {
+		return 0, fmt.Errorf("%w: unsupported format version %d", ErrBadArchive, header.Header.FormatVersion)
+	}
+	if header.Header.SchemaVersion != schemaVersion {
+		return 0, fmt.Errorf("%w: archive has %d, storage has %d",
+			ErrSchemaMismatch, header.Header.SchemaVersion, schemaVersion)
+	}
+
+	var restored int64
+	err = storage.RestoreLinks(ctx, func(put func([]repository.Link) error) error {
+		chunk := make([]repository.Link, 0, chunkSize)
+		flush := func() error {
+			if len(chunk) == 0 {
+				return nil
+			}
+			if err := put(chunk); err != nil {
+				return err
+			}
+			restored += int64(len(chunk))
+			chunk = chunk[:0]
+			return nil
+		}
+
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			var line archiveLine
+			if err := dec.Decode(&line); err != nil {
+				// EOF before end line means truncated archive too
+				return fmt.Errorf("%w: %w", ErrBadArchive, err)
+			}
+
+			switch {
+			case line.Type == lineLink && line.Link != nil:
+				chunk = append(chunk, line.Link.link())
+				if len(chunk) >= chunkSize {
+					if err := flush(); err != nil {
+						return err
+					}
+				}
+			case line.Type == lineEnd && line.End != nil:
+				if err := flush(); err != nil {
+					return err
+				}
+				if restored != line.End.Links {
+					return fmt.Errorf("%w: %d links are restored, archive has %d",
+						ErrBadArchive, restored, line.End.Links)
+				}
+				return nil
+			default:
+				return fmt.Errorf("%w: unexpected line of type %q", ErrBadArchive, line.Type)
+			}
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	return restored, nil
+}
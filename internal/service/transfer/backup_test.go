@@ -0,0 +1,96 @@
+package transfer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"testing"
+
+	"github.com/bissquit/url-shortener/internal/repository/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_BackupRestore(t *testing.T) {
+	from := memory.NewURLStorage()
+	require.NoError(t, from.PutLinks(testLinks()))
+
+	var archive bytes.Buffer
+	n, err := Backup(context.Background(), from, &archive, 11)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+
+	to := memory.NewURLStorage()
+	n, err = Restore(context.Background(), to, bytes.NewReader(archive.Bytes()), 11, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	for _, want := range testLinks() {
+		got, err := to.GetLink(want.ShortID)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	// restore needs empty storage
+	_, err = Restore(context.Background(), to, bytes.NewReader(archive.Bytes()), 11, 0)
+	assert.ErrorIs(t, err, ErrNotEmpty)
+}
+
+func Test_RestoreRejectsArchive(t *testing.T) {
+	from := memory.NewURLStorage()
+	require.NoError(t, from.PutLinks(testLinks()))
+	var archive bytes.Buffer
+	_, err := Backup(context.Background(), from, &archive, 11)
+	require.NoError(t, err)
+
+	// archive without end line
+	plain, err := gzip.NewReader(bytes.NewReader(archive.Bytes()))
+	require.NoError(t, err)
+	var content bytes.Buffer
+	_, err = content.ReadFrom(plain)
+	require.NoError(t, err)
+	lines := bytes.SplitAfter(content.Bytes(), []byte("\n"))
+	var truncated bytes.Buffer
+	zw := gzip.NewWriter(&truncated)
+	_, err = zw.Write(bytes.Join(lines[:len(lines)-2], nil))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	tests := []struct {
+		name          string
+		archive       []byte
+		schemaVersion uint
+		wantErr       error
+	}{
+		{
+			name:          "another schema version",
+			archive:       archive.Bytes(),
+			schemaVersion: 12,
+			wantErr:       ErrSchemaMismatch,
+		},
+		{
+			name:          "truncated archive",
+			archive:       truncated.Bytes(),
+			schemaVersion: 11,
+			wantErr:       ErrBadArchive,
+		},
+		{
+			name:          "not gzip",
+			archive:       content.Bytes(),
+			schemaVersion: 11,
+			wantErr:       ErrBadArchive,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			to := memory.NewURLStorage()
+			_, err := Restore(context.Background(), to, bytes.NewReader(tt.archive), tt.schemaVersion, 1)
+			assert.ErrorIs(t, err, tt.wantErr)
+
+			// chunks put before the error are not kept
+			stats, err := to.GetStats()
+			require.NoError(t, err)
+			assert.Zero(t, stats.Total)
+		})
+	}
+}
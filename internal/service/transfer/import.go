@@ -0,0 +1,403 @@
+// Package transfer moves links between storages and external files
+package transfer
+
+import (
+	"cmp"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/bissquit/url-shortener/internal/service/urlcheck"
+	"github.com/bissquit/url-shortener/pkg/urlnorm"
+)
+
+// import formats
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// ErrBadInput means that the whole input can't be read, errors of single rows are reported in result
+var ErrBadInput = errors.New("bad input")
+
+// DefaultChunkSize is how many links are passed to a single CreateBatch call
+const DefaultChunkSize = 500
+
+const maxShortIDLength = 64
+
+// custom IDs must be usable as a single path segment,
+// "+" is reserved for preview suffix
+var shortIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Record is one imported link, CSV header must contain the same names
+type Record struct {
+	ShortID     string    `json:"short_id"`
+	OriginalURL string    `json:"original_url"`
+	UserID      string    `json:"user_id"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// RowError describes why a row was not imported, rows are counted from 1 without CSV header
+type RowError struct {
+	Row     int    `json:"row"`
+	ShortID string `json:"short_id,omitempty"`
+	Error   string `json:"error"`
+}
+
+type ImportResult struct {
+	DryRun bool `json:"dry_run"`
+	Total  int  `json:"total"`
+	// Imported is a number of links which are (or would be on dry run) stored
+	Imported int        `json:"imported"`
+	Failed   int        `json:"failed"`
+	Errors   []RowError `json:"errors,omitempty"`
+}
+
+type ImportOptions struct {
+	Format string
+	// DryRun validates rows and checks conflicts without storing anything
+	DryRun bool
+	// DefaultUserID owns rows with empty user_id
+	DefaultUserID string
+	ChunkSize     int
+	// Checker validates destination URLs, nil turns the check off
+	Checker *urlcheck.Checker
+}
+
+type pendingItem struct {
+	row  int
+	item repository.URLItem
+}
+
+type importer struct {
+	storage repository.URLRepository
+	opts    ImportOptions
+	result  ImportResult
+	// uniqueness inside the file
+	seenIDs  map[string]int
+	seenURLs map[string]int
+	pending  []pendingItem
+}
+
+// Import reads links from r and stores them by chunks.
+// Invalid and conflicting rows are reported in result, error is returned
+// only when reading or storing can't be continued
+func Import(ctx context.Context, storage repository.URLRepository, r io.Reader, opts ImportOptions) (ImportResult, error) {
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = DefaultChunkSize
+	}
+	imp := &importer{
+		storage:  storage,
+		opts:     opts,
+		result:   ImportResult{DryRun: opts.DryRun},
+		seenIDs:  make(map[string]int),
+		seenURLs: make(map[string]int),
+	}
+
+	err := readRecords(r, opts.Format, func(row int, rec Record, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		imp.result.Total++
+		if err != nil {
+			imp.fail(row, rec.ShortID, err)
+			return nil
+		}
+		return imp.add(row, rec)
+	})
+	if err == nil {
+		err = imp.flush()
+	}
+
+	// conflicts of a chunk are found after validation errors of its later rows
+	slices.SortStableFunc(imp.result.Errors, func(a, b RowError) int { return cmp.Compare(a.Row, b.Row) })
+	imp.result.Failed = len(imp.result.Errors)
+	return imp.result, err
+}
+
+func (imp *importer) fail(row int, shortID string, err error) {
+	imp.result.Errors = append(imp.result.Errors, RowError{Row: row, ShortID: shortID, Error: err.Error()})
+}
+
+func (imp *importer) add(row int, rec Record) error {
+	item, err := imp.validate(rec)
+	if err != nil {
+		imp.fail(row, rec.ShortID, err)
+		return nil
+	}
+
+	canonicalURL := urlnorm.Canonical(item.OriginalURL)
+	if prev, ok := imp.seenIDs[item.ID]; ok {
+		imp.fail(row, rec.ShortID, fmt.Errorf("short_id is duplicated in row %d", prev))
+		return nil
+	}
+	if prev, ok := imp.seenURLs[canonicalURL]; ok {
+		imp.fail(row, rec.ShortID, fmt.Errorf("original_url is duplicated in row %d", prev))
+		return nil
+	}
+	imp.seenIDs[item.ID] = row
+	imp.seenURLs[canonicalURL] = row
+
+	// nothing is stored on dry run, so conflicts with stored links are looked up,
+	// otherwise they are reported by CreateBatch for the whole chunk (see flush)
+	if imp.opts.DryRun {
+		conflict, err := imp.conflict(item)
+		if err != nil {
+			return err
+		}
+		if conflict != nil {
+			imp.fail(row, rec.ShortID, conflict)
+			return nil
+		}
+	}
+
+	imp.pending = append(imp.pending, pendingItem{row: row, item: item})
+	if len(imp.pending) >= imp.opts.ChunkSize {
+		return imp.flush()
+	}
+	return nil
+}
+
+func (imp *importer) validate(rec Record) (repository.URLItem, error) {
+	if rec.ShortID == "" {
+		return repository.URLItem{}, errors.New("short_id is required")
+	}
+	if len(rec.ShortID) > maxShortIDLength || !shortIDPattern.MatchString(rec.ShortID) {
+		return repository.URLItem{}, fmt.Errorf("short_id must be up to %d letters, digits, '-' or '_'", maxShortIDLength)
+	}
+
+	originalURL := rec.OriginalURL
+	if originalURL == "" {
+		return repository.URLItem{}, errors.New("original_url is required")
+	}
+	if imp.opts.Checker != nil {
+		checked, err := imp.opts.Checker.Check(originalURL)
+		if err != nil {
+			return repository.URLItem{}, err
+		}
+		originalURL = checked
+	}
+
+	userID := rec.UserID
+	if userID == "" {
+		userID = imp.opts.DefaultUserID
+	}
+	if userID == "" {
+		return repository.URLItem{}, errors.New("user_id is required")
+	}
+
+	return repository.URLItem{
+		ID:          rec.ShortID,
+		OriginalURL: originalURL,
+		UserID:      userID,
+		CreatedAt:   rec.CreatedAt,
+	}, nil
+}
+
+// conflict looks up stored links which prevent storing item on dry run.
+// It returns rowErr if link can't be stored, err means storage failure
+func (imp *importer) conflict(item repository.URLItem) (rowErr, err error) {
+	_, err = imp.storage.GetLink(item.ID)
+	if err == nil {
+		return fmt.Errorf("%w: %s", repository.ErrIDAlreadyExists, item.ID), nil
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+
+	// deleted links keep their URLs
+	_, err = imp.storage.GetIDByURL(item.OriginalURL)
+	if err == nil || errors.Is(err, repository.ErrDeleted) {
+		return fmt.Errorf("%w: %s", repository.ErrURLAlreadyExists, item.OriginalURL), nil
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// flush stores pending links. Rows conflicting with stored links are taken
+// from *BatchConflictError and the rest of the chunk is stored again
+func (imp *importer) flush() error {
+	pending := imp.pending
+	imp.pending = nil
+	if imp.opts.DryRun {
+		imp.result.Imported += len(pending)
+		return nil
+	}
+
+	for len(pending) > 0 {
+		items := make([]repository.URLItem, 0, len(pending))
+		for _, p := range pending {
+			items = append(items, p.item)
+		}
+		err := imp.storage.CreateBatch(items, "")
+		if err == nil {
+			imp.result.Imported += len(items)
+			return nil
+		}
+		if !isConflict(err) {
+			return err
+		}
+
+		var conflict *repository.BatchConflictError
+		if !errors.As(err, &conflict) {
+			// storage doesn't tell which links conflict
+			return imp.storeOneByOne(pending)
+		}
+		rest := imp.dropConflicts(pending, conflict)
+		if len(rest) == len(pending) {
+			// conflicts don't match rows, it mustn't happen but it mustn't loop either
+			return imp.storeOneByOne(pending)
+		}
+		pending = rest
+	}
+	return nil
+}
+
+// dropConflicts reports rows listed in conflict and returns the rest of them
+func (imp *importer) dropConflicts(pending []pendingItem, conflict *repository.BatchConflictError) []pendingItem {
+	ids := make(map[string]struct{}, len(conflict.IDs))
+	for _, id := range conflict.IDs {
+		ids[id] = struct{}{}
+	}
+	urls := make(map[string]struct{}, len(conflict.URLs))
+	for _, u := range conflict.URLs {
+		urls[u] = struct{}{}
+	}
+
+	rest := make([]pendingItem, 0, len(pending))
+	for _, p := range pending {
+		if _, ok := ids[p.item.ID]; ok {
+			imp.fail(p.row, p.item.ID, fmt.Errorf("%w: %s", repository.ErrIDAlreadyExists, p.item.ID))
+			continue
+		}
+		if _, ok := urls[p.item.OriginalURL]; ok {
+			imp.fail(p.row, p.item.ID, fmt.Errorf("%w: %s", repository.ErrURLAlreadyExists, p.item.OriginalURL))
+			continue
+		}
+		rest = append(rest, p)
+	}
+	return rest
+}
+
+// storeOneByOne is a fallback for storages which don't report conflicts per link
+func (imp *importer) storeOneByOne(pending []pendingItem) error {
+	for _, p := range pending {
+		err := imp.storage.CreateBatch([]repository.URLItem{p.item}, "")
+		switch {
+		case err == nil:
+			imp.result.Imported++
+		case isConflict(err):
+			imp.fail(p.row, p.item.ID, err)
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func isConflict(err error) bool {
+	return errors.Is(err, repository.ErrIDAlreadyExists) || errors.Is(err, repository.ErrURLAlreadyExists)
+}
+
+// readRecords calls fn for every row, parse errors of a single row are passed to fn too
+func readRecords(r io.Reader, format string, fn func(row int, rec Record, err error) error) error {
+	switch format {
+	case FormatCSV:
+		return readCSV(r, fn)
+	case FormatNDJSON:
+		return readNDJSON(r, fn)
+	default:
+		return fmt.Errorf("%w: unsupported import format: %q", ErrBadInput, format)
+	}
+}
+
+func readCSV(r io.Reader, fn func(row int, rec Record, err error) error) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("%w: cannot read CSV header: %w", ErrBadInput, err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"short_id", "original_url"} {
+		if _, ok := columns[required]; !ok {
+			return fmt.Errorf("%w: CSV header must contain %s column", ErrBadInput, required)
+		}
+	}
+
+	for row := 1; ; row++ {
+		fields, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			if err = fn(row, Record{}, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(fields) {
+				return strings.TrimSpace(fields[i])
+			}
+			return ""
+		}
+		rec := Record{
+			ShortID:     field("short_id"),
+			OriginalURL: field("original_url"),
+			UserID:      field("user_id"),
+		}
+		if createdAt := field("created_at"); createdAt != "" {
+			rec.CreatedAt, err = time.Parse(time.RFC3339, createdAt)
+			if err != nil {
+				err = fmt.Errorf("created_at must be in RFC 3339 format: %q", createdAt)
+			}
+		}
+		if err = fn(row, rec, err); err != nil {
+			return err
+		}
+	}
+}
+
+func readNDJSON(r io.Reader, fn func(row int, rec Record, err error) error) error {
+	dec := json.NewDecoder(r)
+	for row := 1; ; row++ {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			// stream can't be continued after syntax error
+			return fmt.Errorf("%w: row %d: %w", ErrBadInput, row, err)
+		}
+
+		var rec Record
+		if err = json.Unmarshal(raw, &rec); err != nil {
+			err = fmt.Errorf("invalid record: %w", err)
+		}
+		if err = fn(row, rec, err); err != nil {
+			return err
+		}
+	}
+}
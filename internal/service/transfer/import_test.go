@@ -0,0 +1,215 @@
+package transfer
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/bissquit/url-shortener/internal/repository/memory"
+	"github.com/bissquit/url-shortener/internal/service/urlcheck"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Import(t *testing.T) {
+	tests := []struct {
+		name         string
+		format       string
+		input        string
+		dryRun       bool
+		chunkSize    int
+		wantImported int
+		wantErrRows  []int
+		wantErr      error
+		wantStored   []string
+	}{
+		{
+			name:   "csv with all columns",
+			format: FormatCSV,
+			input: "short_id,original_url,user_id,created_at\n" +
+				"abc,https://example.org,user1,2024-05-01T10:00:00Z\n" +
+				"def,https://example.net,,\n",
+			wantImported: 2,
+			wantStored:   []string{"abc", "def"},
+		},
+		{
+			name:   "csv columns in any order",
+			format: FormatCSV,
+			input: "original_url,short_id\n" +
+				"https://example.org,abc\n",
+			wantImported: 1,
+			wantStored:   []string{"abc"},
+		},
+		{
+			name:    "csv without required column",
+			format:  FormatCSV,
+			input:   "short_id,user_id\nabc,user1\n",
+			wantErr: ErrBadInput,
+		},
+		{
+			name:   "invalid rows are reported",
+			format: FormatCSV,
+			input: "short_id,original_url,user_id,created_at\n" +
+				"abc,https://example.org,,\n" +
+				"a/b,https://example.net,,\n" +
+				"ghi,ftp://example.net,,\n" +
+				"jkl,https://example.info,,yesterday\n" +
+				",https://example.biz,,\n",
+			wantImported: 1,
+			wantErrRows:  []int{2, 3, 4, 5},
+			wantStored:   []string{"abc"},
+		},
+		{
+			name:   "conflicts with stored and duplicates in file",
+			format: FormatCSV,
+			input: "short_id,original_url\n" +
+				"exists01,https://example.org\n" +
+				"abc,https://EXAMPLE.com/\n" +
+				"def,https://example.net\n" +
+				"def,https://example.info\n" +
+				"ghi,https://example.net/?utm_source=x\n",
+			wantImported: 1,
+			wantErrRows:  []int{1, 2, 4, 5},
+			wantStored:   []string{"def"},
+		},
+		{
+			name:   "dry run stores nothing",
+			format: FormatCSV,
+			input: "short_id,original_url\n" +
+				"abc,https://example.org\n" +
+				"exists01,https://example.net\n",
+			dryRun:       true,
+			wantImported: 1,
+			wantErrRows:  []int{2},
+		},
+		{
+			name:   "ndjson by small chunks",
+			format: FormatNDJSON,
+			input: `{"short_id":"abc","original_url":"https://example.org","user_id":"user1"}` + "\n" +
+				`{"short_id":"def","original_url":"https://example.net","created_at":"2024-05-01T10:00:00Z"}` + "\n" +
+				`{"short_id":1}` + "\n" +
+				`{"short_id":"ghi","original_url":"https://example.info"}` + "\n",
+			chunkSize:    2,
+			wantImported: 3,
+			wantErrRows:  []int{3},
+			wantStored:   []string{"abc", "def", "ghi"},
+		},
+		{
+			name:    "broken ndjson",
+			format:  FormatNDJSON,
+			input:   `{"short_id":"abc"`,
+			wantErr: ErrBadInput,
+		},
+		{
+			name:    "unknown format",
+			format:  "xml",
+			input:   "<urls/>",
+			wantErr: ErrBadInput,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := memory.NewURLStorage()
+			require.NoError(t, storage.Create("exists01", "https://example.com", "owner", repository.LinkSettings{}))
+
+			result, err := Import(context.Background(), storage, strings.NewReader(tt.input), ImportOptions{
+				Format:        tt.format,
+				DryRun:        tt.dryRun,
+				DefaultUserID: "importer",
+				ChunkSize:     tt.chunkSize,
+				Checker:       urlcheck.New("http://localhost:8080", urlcheck.DefaultSchemes, urlcheck.DefaultMaxLength),
+			})
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.dryRun, result.DryRun)
+			assert.Equal(t, tt.wantImported, result.Imported)
+			assert.Equal(t, len(tt.wantErrRows), result.Failed)
+			var errRows []int
+			for _, rowErr := range result.Errors {
+				errRows = append(errRows, rowErr.Row)
+			}
+			assert.Equal(t, tt.wantErrRows, errRows)
+
+			stats, err := storage.GetStats()
+			require.NoError(t, err)
+			assert.Equal(t, int64(1+len(tt.wantStored)), stats.Total)
+			for _, id := range tt.wantStored {
+				_, err := storage.GetLink(id)
+				assert.NoError(t, err, id)
+			}
+		})
+	}
+}
+
+func Test_ImportKeepsOwnerAndCreationTime(t *testing.T) {
+	storage := memory.NewURLStorage()
+
+	_, err := Import(context.Background(), storage, strings.NewReader(
+		"short_id,original_url,user_id,created_at\n"+
+			"abc,https://example.org,user1,2024-05-01T10:00:00+02:00\n"+
+			"def,https://example.net,,\n"),
+		ImportOptions{Format: FormatCSV, DefaultUserID: "importer"})
+	require.NoError(t, err)
+
+	link, err := storage.GetLink("abc")
+	require.NoError(t, err)
+	assert.Equal(t, "user1", link.UserID)
+	assert.Equal(t, "2024-05-01T08:00:00Z", link.CreatedAt.Format("2006-01-02T15:04:05Z07:00"))
+
+	link, err = storage.GetLink("def")
+	require.NoError(t, err)
+	assert.Equal(t, "importer", link.UserID)
+	assert.False(t, link.CreatedAt.IsZero())
+}
+
+// racingStorage creates a conflicting link right before the first batch,
+// links are never looked up by import
+type racingStorage struct {
+	*memory.URLStorage
+	raced   bool
+	lookups int
+}
+
+func (s *racingStorage) GetLink(id string) (repository.Link, error) {
+	s.lookups++
+	return s.URLStorage.GetLink(id)
+}
+
+func (s *racingStorage) GetIDByURL(url string) (string, error) {
+	s.lookups++
+	return s.URLStorage.GetIDByURL(url)
+}
+
+func (s *racingStorage) CreateBatch(items []repository.URLItem, userID string) error {
+	if !s.raced {
+		s.raced = true
+		if err := s.URLStorage.Create("def", "https://example.com", "another", repository.LinkSettings{}); err != nil {
+			return err
+		}
+	}
+	return s.URLStorage.CreateBatch(items, userID)
+}
+
+func Test_ImportReportsConflictingRows(t *testing.T) {
+	storage := &racingStorage{URLStorage: memory.NewURLStorage()}
+
+	result, err := Import(context.Background(), storage, strings.NewReader(
+		"short_id,original_url\n"+
+			"abc,https://example.org\n"+
+			"def,https://example.net\n"+
+			"ghi,https://example.info\n"),
+		ImportOptions{Format: FormatCSV, DefaultUserID: "importer"})
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, result.Imported)
+	require.Len(t, result.Errors, 1)
+	assert.Equal(t, 2, result.Errors[0].Row)
+	assert.Contains(t, result.Errors[0].Error, repository.ErrIDAlreadyExists.Error())
+	assert.Zero(t, storage.lookups)
+}
@@ -0,0 +1,94 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/bissquit/url-shortener/internal/repository"
+)
+
+// ErrVerification means that destination counters don't match source ones after migration
+var ErrVerification = errors.New("migration verification failed")
+
+type MigrateResult struct {
+	// Source is a state of source storage before migration
+	Source repository.Stats `json:"source"`
+	Copied int64            `json:"copied"`
+}
+
+// Migrate copies all links of from into to by chunks as is (with owners, flags and clicks)
+// and checks that destination has grown by source counters.
+// Source must not be changed during migration, so server should be stopped
+func Migrate(ctx context.Context, from, to repository.URLRepository, chunkSize int) (MigrateResult, error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	var (
+		result MigrateResult
+		err    error
+	)
+	if result.Source, err = from.GetStats(); err != nil {
+		return result, fmt.Errorf("cannot get source stats: %w", err)
+	}
+	before, err := to.GetStats()
+	if err != nil {
+		return result, fmt.Errorf("cannot get destination stats: %w", err)
+	}
+
+	chunk := make([]repository.Link, 0, chunkSize)
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		if err := to.PutLinks(chunk); err != nil {
+			return fmt.Errorf("cannot store links %s..%s: %w", chunk[0].ShortID, chunk[len(chunk)-1].ShortID, err)
+		}
+		result.Copied += int64(len(chunk))
+		chunk = chunk[:0]
+		return nil
+	}
+
+	err = from.IterateLinks(ctx, func(link repository.Link) error {
+		chunk = append(chunk, link)
+		if len(chunk) < chunkSize {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return result, err
+	}
+
+	after, err := to.GetStats()
+	if err != nil {
+		return result, fmt.Errorf("cannot get destination stats: %w", err)
+	}
+	return result, verify(result, before, after)
+}
+
+func verify(result MigrateResult, before, after repository.Stats) error {
+	src := result.Source
+	if result.Copied != src.Total {
+		return fmt.Errorf("%w: %d links are copied, source has %d", ErrVerification, result.Copied, src.Total)
+	}
+
+	checks := []struct {
+		name     string
+		src, dst int64
+	}{
+		{"total", src.Total, after.Total - before.Total},
+		{"deleted", src.Deleted, after.Deleted - before.Deleted},
+		{"banned", src.Banned, after.Banned - before.Banned},
+	}
+	for _, c := range checks {
+		if c.src != c.dst {
+			return fmt.Errorf("%w: source has %d %s links, destination got %d", ErrVerification, c.src, c.name, c.dst)
+		}
+	}
+	return nil
+}
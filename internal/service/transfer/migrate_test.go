@@ -0,0 +1,84 @@
+package transfer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bissquit/url-shortener/internal/repository"
+	"github.com/bissquit/url-shortener/internal/repository/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testLinks() []repository.Link {
+	return []repository.Link{
+		{
+			ShortID:     "link01",
+			OriginalURL: "https://example.com",
+			UserID:      "user1",
+			CreatedAt:   time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+			Settings:    repository.LinkSettings{RedirectCode: 301},
+			Rules:       []repository.RedirectRule{{Device: "ios", URL: "https://apps.example.com"}},
+			Variants:    []repository.Variant{{ID: "a", URL: "https://a.example.com", Weight: 1, Clicks: 10}},
+		},
+		{
+			ShortID:     "link02",
+			OriginalURL: "https://example.org",
+			UserID:      "user2",
+			Deleted:     true,
+		},
+		{
+			ShortID:     "link03",
+			OriginalURL: "https://example.net",
+			UserID:      "user2",
+			Banned:      true,
+		},
+	}
+}
+
+func Test_Migrate(t *testing.T) {
+	from := memory.NewURLStorage()
+	require.NoError(t, from.PutLinks(testLinks()))
+	to := memory.NewURLStorage()
+	require.NoError(t, to.Create("existing", "https://example.info", "user3", repository.LinkSettings{}))
+
+	// chunk is smaller than number of links
+	result, err := Migrate(context.Background(), from, to, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), result.Copied)
+	assert.Equal(t, int64(3), result.Source.Total)
+
+	for _, want := range testLinks() {
+		got, err := to.GetLink(want.ShortID)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	// second run conflicts by IDs
+	_, err = Migrate(context.Background(), from, to, 2)
+	assert.ErrorIs(t, err, repository.ErrIDAlreadyExists)
+}
+
+// lossyStorage silently drops deleted links
+type lossyStorage struct {
+	*memory.URLStorage
+}
+
+func (s *lossyStorage) PutLinks(links []repository.Link) error {
+	var kept []repository.Link
+	for _, link := range links {
+		if !link.Deleted {
+			kept = append(kept, link)
+		}
+	}
+	return s.URLStorage.PutLinks(kept)
+}
+
+func Test_MigrateVerification(t *testing.T) {
+	from := memory.NewURLStorage()
+	require.NoError(t, from.PutLinks(testLinks()))
+
+	_, err := Migrate(context.Background(), from, &lossyStorage{URLStorage: memory.NewURLStorage()}, 0)
+	assert.ErrorIs(t, err, ErrVerification)
+}
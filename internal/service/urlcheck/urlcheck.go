@@ -0,0 +1,261 @@
+package urlcheck
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+var (
+	ErrEmptyURL         = errors.New("empty URL value in request body")
+	ErrInvalidURL       = errors.New("invalid URL")
+	ErrTooLong          = errors.New("URL is too long")
+	ErrSchemeNotAllowed = errors.New("URL scheme is not allowed")
+	ErrSelfReference    = errors.New("URL points to the shortener itself")
+	ErrBlockedDomain    = errors.New("URL domain is blocked")
+)
+
+const (
+	DefaultMaxLength = 2048
+	DefaultSchemes   = "http,https"
+)
+
+// Checker validates destination URLs before they are stored
+type Checker struct {
+	// limits are replaced as a whole by SetLimits
+	limits atomic.Pointer[limits]
+	// host and port of the shortener itself
+	selfHost string
+	selfPort string
+	// blocklist is replaced as a whole on reload
+	blocklist atomic.Pointer[map[string]struct{}]
+	// state of the last loaded blocklist file
+	loaded atomic.Pointer[fileState]
+}
+
+type limits struct {
+	schemes   map[string]struct{}
+	maxLength int
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// New creates checker with schemes allowlist (comma separated) and max URL length.
+// baseURL is used to detect redirect loops, invalid one turns this check off
+func New(baseURL, schemes string, maxLength int) *Checker {
+	c := &Checker{}
+	c.SetLimits(schemes, maxLength)
+	if u, err := url.Parse(baseURL); err == nil && u.Host != "" {
+		c.selfHost, _ = normalizeHost(u.Hostname())
+		c.selfPort = effectivePort(u)
+	}
+	c.blocklist.Store(&map[string]struct{}{})
+	return c
+}
+
+// SetLimits replaces schemes allowlist (comma separated) and max URL length,
+// it's safe to call while URLs are checked
+func (c *Checker) SetLimits(schemes string, maxLength int) {
+	l := &limits{
+		schemes:   make(map[string]struct{}),
+		maxLength: maxLength,
+	}
+	for _, scheme := range strings.Split(schemes, ",") {
+		if scheme = strings.ToLower(strings.TrimSpace(scheme)); scheme != "" {
+			l.schemes[scheme] = struct{}{}
+		}
+	}
+	c.limits.Store(l)
+}
+
+// Check returns raw URL as is or one of the Err* errors. Only internationalized host is
+// converted to punycode, the rest of URL is kept, comparison form is built by urlnorm.Canonical
+func (c *Checker) Check(raw string) (string, error) {
+	if raw == "" {
+		return "", ErrEmptyURL
+	}
+	l := c.limits.Load()
+	if l.maxLength > 0 && len(raw) > l.maxLength {
+		return "", fmt.Errorf("%w: max %d characters", ErrTooLong, l.maxLength)
+	}
+
+	u, err := url.ParseRequestURI(raw)
+	if err != nil {
+		return "", ErrInvalidURL
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if _, ok := l.schemes[scheme]; !ok {
+		return "", fmt.Errorf("%w: %q", ErrSchemeNotAllowed, scheme)
+	}
+	if u.Host == "" {
+		return "", ErrInvalidURL
+	}
+
+	host, err := normalizeHost(u.Hostname())
+	if err != nil {
+		return "", ErrInvalidURL
+	}
+	if c.selfHost != "" && host == c.selfHost && effectivePort(u) == c.selfPort {
+		return "", ErrSelfReference
+	}
+	if c.isBlocked(host) {
+		return "", fmt.Errorf("%w: %s", ErrBlockedDomain, host)
+	}
+
+	if isASCII(u.Hostname()) {
+		return raw, nil
+	}
+	return replaceHost(raw, u, host), nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// replaceHost puts host into authority of raw URL parsed as u, userinfo and port are kept.
+// Host is a domain name here, IP addresses are always ASCII
+func replaceHost(raw string, u *url.URL, host string) string {
+	// scheme is lower-cased by parser, but its length is the same
+	prefix := len(u.Scheme) + len("://")
+	rest := raw[prefix:]
+	end := strings.IndexAny(rest, "/?#")
+	if end < 0 {
+		end = len(rest)
+	}
+	userinfo := strings.LastIndexByte(rest[:end], '@') + 1
+	if port := u.Port(); port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+	return raw[:prefix+userinfo] + host + rest[end:]
+}
+
+// isBlocked matches domain itself and all its subdomains
+func (c *Checker) isBlocked(host string) bool {
+	blocklist := *c.blocklist.Load()
+	if len(blocklist) == 0 {
+		return false
+	}
+	for {
+		if _, ok := blocklist[host]; ok {
+			return true
+		}
+		i := strings.IndexByte(host, '.')
+		if i < 0 {
+			return false
+		}
+		host = host[i+1:]
+	}
+}
+
+// LoadBlocklist replaces blocklist with domains from file, one per line.
+// Empty lines and lines starting with # are skipped
+func (c *Checker) LoadBlocklist(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	blocklist := make(map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domain, err := normalizeHost(strings.TrimSuffix(line, "."))
+		if err != nil {
+			return fmt.Errorf("blocklist: invalid domain %q: %w", line, err)
+		}
+		blocklist[domain] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	c.blocklist.Store(&blocklist)
+	c.loaded.Store(&fileState{modTime: info.ModTime(), size: info.Size()})
+	return nil
+}
+
+// ClearBlocklist allows all domains until the next LoadBlocklist
+func (c *Checker) ClearBlocklist() {
+	c.blocklist.Store(&map[string]struct{}{})
+	c.loaded.Store(nil)
+}
+
+// WatchBlocklist reloads blocklist when file modification time or size differs from the loaded one.
+// Reload errors are logged and the previous blocklist is kept. It blocks until ctx is done
+func (c *Checker) WatchBlocklist(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Printf("blocklist: cannot stat %s: %v", path, err)
+			continue
+		}
+		if loaded := c.loaded.Load(); loaded != nil &&
+			info.ModTime().Equal(loaded.modTime) && info.Size() == loaded.size {
+			continue
+		}
+
+		if err := c.LoadBlocklist(path); err != nil {
+			log.Printf("blocklist: cannot reload %s: %v", path, err)
+			continue
+		}
+		log.Printf("blocklist: reloaded %s", path)
+	}
+}
+
+// normalizeHost converts IDN to lowercase punycode
+func normalizeHost(host string) (string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+	return idna.Lookup.ToASCII(host)
+}
+
+func effectivePort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "https":
+		return "443"
+	case "http":
+		return "80"
+	}
+	return ""
+}
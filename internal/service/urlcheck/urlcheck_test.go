@@ -0,0 +1,89 @@
+package urlcheck
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CheckerCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    string
+		wantErr error
+	}{
+		{name: "valid url", raw: "https://example.com/a?b=c", want: "https://example.com/a?b=c"},
+		{name: "empty url", raw: "", wantErr: ErrEmptyURL},
+		{name: "not an url", raw: "%", wantErr: ErrInvalidURL},
+		{name: "javascript scheme", raw: "javascript:alert(1)", wantErr: ErrSchemeNotAllowed},
+		{name: "file scheme", raw: "file:///etc/passwd", wantErr: ErrSchemeNotAllowed},
+		{name: "data scheme", raw: "data:text/html,<script>", wantErr: ErrSchemeNotAllowed},
+		{name: "too long", raw: "https://example.com/" + string(make([]byte, 100)), wantErr: ErrTooLong},
+		{name: "self reference", raw: "http://short.ly/abc", wantErr: ErrSelfReference},
+		{name: "self reference with explicit port", raw: "http://SHORT.ly:80/abc", wantErr: ErrSelfReference},
+		{name: "same host another port", raw: "http://short.ly:8080/abc", want: "http://short.ly:8080/abc"},
+		{name: "mixed case host is kept", raw: "HTTP://Example.COM/a%2Fb/?b=c&a=d#x", want: "HTTP://Example.COM/a%2Fb/?b=c&a=d#x"},
+		{name: "idn host", raw: "https://пример.рф/путь", want: "https://xn--e1afmkfd.xn--p1ai/путь"},
+		{name: "idn host with userinfo and port", raw: "http://u@пример.рф:8080?q=путь", want: "http://u@xn--e1afmkfd.xn--p1ai:8080?q=путь"},
+		{name: "blocked domain", raw: "https://evil.com/", wantErr: ErrBlockedDomain},
+		{name: "blocked subdomain", raw: "https://www.EVIL.com/", wantErr: ErrBlockedDomain},
+		{name: "blocked idn domain", raw: "https://фишинг.рф/", wantErr: ErrBlockedDomain},
+		{name: "not blocked similar domain", raw: "https://notevil.com/", want: "https://notevil.com/"},
+	}
+
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# test\nevil.com\n\nфишинг.рф\n"), 0644))
+
+	c := New("http://short.ly", DefaultSchemes, 80)
+	require.NoError(t, c.LoadBlocklist(path))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.Check(tt.raw)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_CheckerWatchBlocklist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	require.NoError(t, os.WriteFile(path, []byte("evil.com\n"), 0644))
+
+	c := New("http://short.ly", DefaultSchemes, DefaultMaxLength)
+	require.NoError(t, c.LoadBlocklist(path))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.WatchBlocklist(ctx, path, 10*time.Millisecond)
+
+	require.NoError(t, os.WriteFile(path, []byte("evil.com\nbad.org\n"), 0644))
+
+	assert.Eventually(t, func() bool {
+		_, err := c.Check("https://bad.org/")
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func Test_CheckerSetLimits(t *testing.T) {
+	c := New("http://localhost:8080", DefaultSchemes, DefaultMaxLength)
+
+	_, err := c.Check("ftp://example.com/file")
+	assert.ErrorIs(t, err, ErrSchemeNotAllowed)
+
+	c.SetLimits("http,https,ftp", 25)
+	_, err = c.Check("ftp://example.com/file")
+	assert.NoError(t, err)
+	_, err = c.Check("https://example.com/very/long/path")
+	assert.ErrorIs(t, err, ErrTooLong)
+}
@@ -0,0 +1,117 @@
+package tlscert
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Reloader serves certificate from files and replaces it when files change,
+// so certificate may be renewed without restart
+type Reloader struct {
+	certPath string
+	keyPath  string
+	cert     atomic.Pointer[tls.Certificate]
+	// state of the loaded files
+	certState fileState
+	keyState  fileState
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func (s fileState) equal(other fileState) bool {
+	return s.modTime.Equal(other.modTime) && s.size == other.size
+}
+
+func stat(path string) (fileState, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}, err
+	}
+	return fileState{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// New loads certificate and key, invalid pair is an error
+func New(certPath, keyPath string) (*Reloader, error) {
+	r := &Reloader{certPath: certPath, keyPath: keyPath}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads files again, the previous certificate is kept on error
+func (r *Reloader) Reload() error {
+	// files are checked before they are read, so a change during reload is caught next time
+	certState, err := stat(r.certPath)
+	if err != nil {
+		return err
+	}
+	keyState, err := stat(r.keyPath)
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		return err
+	}
+	r.cert.Store(&cert)
+	r.certState, r.keyState = certState, keyState
+	return nil
+}
+
+// GetCertificate is used as tls.Config.GetCertificate
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// changed reports whether any of files differs from the loaded one
+func (r *Reloader) changed() (bool, error) {
+	certState, err := stat(r.certPath)
+	if err != nil {
+		return false, err
+	}
+	keyState, err := stat(r.keyPath)
+	if err != nil {
+		return false, err
+	}
+	return !certState.equal(r.certState) || !keyState.equal(r.keyState), nil
+}
+
+// Watch reloads certificate when modification time or size of files changes.
+// Certificate and key are usually replaced one by one, so a mismatched pair is logged
+// and retried on the next tick. It blocks until ctx is done
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := r.changed()
+		if err != nil {
+			log.Printf("tls: cannot stat certificate files: %v", err)
+			continue
+		}
+		if !changed {
+			continue
+		}
+
+		if err := r.Reload(); err != nil {
+			log.Printf("tls: cannot reload certificate %s: %v", r.certPath, err)
+			continue
+		}
+		log.Printf("tls: reloaded certificate %s", r.certPath)
+	}
+}
@@ -0,0 +1 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS settings JSONB NOT NULL DEFAULT '{}';
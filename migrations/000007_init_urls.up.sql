@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS url_variants (
+    short_id text NOT NULL REFERENCES urls(short_id) ON DELETE CASCADE,
+    variant_id text NOT NULL,
+    position integer NOT NULL,
+    url text NOT NULL,
+    weight integer NOT NULL,
+    clicks bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY (short_id, variant_id)
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_canonical_url;
+ALTER TABLE urls DROP COLUMN IF EXISTS canonical_url;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_original_url ON urls(original_url);
@@ -0,0 +1,42 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS canonical_url TEXT;
+
+-- the same as urlnorm.Canonical, keep them in sync
+CREATE OR REPLACE FUNCTION canonical_url_v1(u text) RETURNS text LANGUAGE sql IMMUTABLE AS $$
+SELECT CASE WHEN m IS NULL THEN u ELSE
+    lower(m[1]) || '://' ||
+    COALESCE(m[2] || '@', '') ||
+    CASE lower(m[1])
+        WHEN 'http' THEN regexp_replace(lower(m[3]), ':80$', '')
+        WHEN 'https' THEN regexp_replace(lower(m[3]), ':443$', '')
+        ELSE lower(m[3])
+    END ||
+    regexp_replace(m[4], '/+$', '') ||
+    COALESCE('?' || (
+        SELECT string_agg(p, '&' ORDER BY p COLLATE "C")
+        FROM regexp_split_to_table(m[5], '&') AS p
+        WHERE p <> '' AND split_part(p, '=', 1) !~ '^(utm_.*|fbclid|gclid|yclid|mc_cid|mc_eid|_ga)$'
+    ), '') ||
+    COALESCE('#' || m[6], '')
+END
+FROM regexp_match(u, '^([A-Za-z][A-Za-z0-9+.-]*)://(?:([^@/?#]*)@)?([^/?#]*)([^?#]*)(?:\?([^#]*))?(?:#(.*))?$') AS m
+$$;
+
+UPDATE urls SET canonical_url = canonical_url_v1(original_url);
+
+-- URLs stored before canonicalization may become duplicates,
+-- only one of them (live one if possible) is found by canonical URL
+UPDATE urls SET canonical_url = NULL
+WHERE short_id IN (
+    SELECT short_id FROM (
+        SELECT short_id, row_number() OVER (
+            PARTITION BY canonical_url ORDER BY is_deleted, short_id
+        ) AS rn
+        FROM urls
+    ) d
+    WHERE rn > 1
+);
+
+DROP FUNCTION canonical_url_v1(text);
+
+DROP INDEX IF EXISTS idx_original_url;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_canonical_url ON urls(canonical_url);
@@ -0,0 +1 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS is_banned BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS reports (
+    id bigserial PRIMARY KEY,
+    short_id text NOT NULL REFERENCES urls(short_id) ON DELETE CASCADE,
+    reason text NOT NULL DEFAULT '',
+    reporter_id text NOT NULL,
+    status text NOT NULL DEFAULT 'open',
+    created_at timestamptz NOT NULL DEFAULT now(),
+    resolved_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_reports_status ON reports (status);
+-- one open report per reporter and link
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reports_open ON reports (short_id, reporter_id) WHERE status = 'open';
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_user_id_created_at;
+ALTER TABLE urls DROP COLUMN IF EXISTS created_at;
@@ -0,0 +1,4 @@
+-- links created before this migration have unknown creation time
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS created_at timestamptz;
+ALTER TABLE urls ALTER COLUMN created_at SET DEFAULT now();
+CREATE INDEX IF NOT EXISTS idx_user_id_created_at ON urls (user_id, created_at);
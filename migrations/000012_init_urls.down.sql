@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS idx_user_id_created_at ON urls (user_id, created_at);
+DROP INDEX IF EXISTS idx_user_id_created_at_short_id;
@@ -0,0 +1,4 @@
+-- user links are paged by (COALESCE(created_at, '-infinity'), short_id),
+-- index on the plain column can't serve this order
+CREATE INDEX IF NOT EXISTS idx_user_id_created_at_short_id ON urls (user_id, (COALESCE(created_at, '-infinity')), short_id);
+DROP INDEX IF EXISTS idx_user_id_created_at;
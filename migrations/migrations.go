@@ -2,6 +2,9 @@ package migrations
 
 import (
 	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
@@ -39,3 +42,55 @@ func InitializeDB(databaseURL string) error {
 	}
 	return err
 }
+
+// LatestVersion returns version of the last embedded migration.
+// File and in-memory storages always keep data of this version
+func LatestVersion() (uint, error) {
+	d, err := iofs.New(embeddedMigrations, ".")
+	if err != nil {
+		return 0, err
+	}
+	defer d.Close()
+
+	version, err := d.First()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		next, err := d.Next(version)
+		if errors.Is(err, fs.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		version = next
+	}
+}
+
+// SchemaVersion returns version of applied migrations if databaseURL is set
+// and LatestVersion otherwise
+func SchemaVersion(databaseURL string) (uint, error) {
+	if databaseURL == "" {
+		return LatestVersion()
+	}
+
+	d, err := iofs.New(embeddedMigrations, ".")
+	if err != nil {
+		return 0, err
+	}
+	m, err := migrate.NewWithSourceInstance("iofs", d, databaseURL)
+	if err != nil {
+		return 0, err
+	}
+	defer m.Close()
+
+	version, dirty, err := m.Version()
+	if err != nil {
+		return 0, err
+	}
+	if dirty {
+		return 0, fmt.Errorf("database schema version %d is dirty", version)
+	}
+	return version, nil
+}
@@ -0,0 +1,85 @@
+// Package urlnorm builds canonical form of URL which is used to find duplicates.
+//
+// Canonical form is built on raw string parts, without decoding and re-encoding,
+// so it can be reproduced in SQL (see migrations/000008_init_urls.up.sql):
+//   - scheme and host are lowercased, default port (80 for http, 443 for https) is removed
+//   - trailing slashes of path are removed
+//   - empty and tracking query parameters are removed, the rest are sorted bytewise
+//
+// Strings which don't look like "scheme://authority..." are returned as is
+package urlnorm
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// scheme, userinfo, host[:port], path, query, fragment
+	urlPattern = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9+.-]*)://(?:([^@/?#]*)@)?([^/?#]*)([^?#]*)(?:\?([^#]*))?(?:#(.*))?$`)
+	// trackingParam matches query keys which don't change destination
+	trackingParam = regexp.MustCompile(`^(utm_.*|fbclid|gclid|yclid|mc_cid|mc_eid|_ga)$`)
+)
+
+func Canonical(raw string) string {
+	m := urlPattern.FindStringSubmatchIndex(raw)
+	if m == nil {
+		return raw
+	}
+	group := func(i int) (string, bool) {
+		if m[2*i] < 0 {
+			return "", false
+		}
+		return raw[m[2*i]:m[2*i+1]], true
+	}
+
+	var b strings.Builder
+	b.Grow(len(raw))
+
+	scheme, _ := group(1)
+	scheme = strings.ToLower(scheme)
+	b.WriteString(scheme)
+	b.WriteString("://")
+
+	if userinfo, ok := group(2); ok {
+		b.WriteString(userinfo)
+		b.WriteByte('@')
+	}
+
+	host, _ := group(3)
+	host = strings.ToLower(host)
+	switch scheme {
+	case "http":
+		host = strings.TrimSuffix(host, ":80")
+	case "https":
+		host = strings.TrimSuffix(host, ":443")
+	}
+	b.WriteString(host)
+
+	path, _ := group(4)
+	b.WriteString(strings.TrimRight(path, "/"))
+
+	if query, ok := group(5); ok {
+		params := make([]string, 0, strings.Count(query, "&")+1)
+		for _, p := range strings.Split(query, "&") {
+			key, _, _ := strings.Cut(p, "=")
+			if p == "" || trackingParam.MatchString(key) {
+				continue
+			}
+			params = append(params, p)
+		}
+		if len(params) > 0 {
+			sort.Strings(params)
+			b.WriteByte('?')
+			b.WriteString(strings.Join(params, "&"))
+		}
+	}
+
+	if fragment, ok := group(6); ok {
+		b.WriteByte('#')
+		b.WriteString(fragment)
+	}
+
+	return b.String()
+}
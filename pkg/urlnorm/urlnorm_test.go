@@ -0,0 +1,33 @@
+package urlnorm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Canonical(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{raw: "http://Example.com/a/", want: "http://example.com/a"},
+		{raw: "http://example.com/a", want: "http://example.com/a"},
+		{raw: "HTTP://EXAMPLE.COM:80/", want: "http://example.com"},
+		{raw: "https://example.com:443/a", want: "https://example.com/a"},
+		{raw: "https://example.com:80/a", want: "https://example.com:80/a"},
+		{raw: "http://example.com/A/b//", want: "http://example.com/A/b"},
+		{raw: "http://example.com/?b=2&a=1", want: "http://example.com?a=1&b=2"},
+		{raw: "http://example.com/?utm_source=x&a=1&fbclid=y&gclid", want: "http://example.com?a=1"},
+		{raw: "http://example.com/?utm_source=x&&", want: "http://example.com"},
+		{raw: "http://User@Example.com/a#Frag/", want: "http://User@example.com/a#Frag/"},
+		{raw: "mailto:someone@example.com", want: "mailto:someone@example.com"},
+		{raw: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			assert.Equal(t, tt.want, Canonical(tt.raw))
+		})
+	}
+}